@@ -0,0 +1,308 @@
+// Copyright 2018 cotox. All rights reserved.
+// Use of this source code is governed by a GPLv3
+// license that can be found in the LICENSE file.
+
+package rssutil
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// atomFeed mirrors an Atom feed document for encoding.
+type atomFeed struct {
+	XMLName   xml.Name       `xml:"feed"`
+	NS        string         `xml:"xmlns,attr"`
+	ID        string         `xml:"id"`
+	Title     atomText       `xml:"title"`
+	Subtitle  *atomText      `xml:"subtitle,omitempty"`
+	Updated   string         `xml:"updated"`
+	Links     []atomLink     `xml:"link"`
+	Author    *atomPerson    `xml:"author,omitempty"`
+	Category  []atomCategory `xml:"category"`
+	Rights    string         `xml:"rights,omitempty"`
+	Generator string         `xml:"generator,omitempty"`
+	Logo      string         `xml:"logo,omitempty"`
+	Entries   []atomEntry    `xml:"entry"`
+}
+
+// atomEntry mirrors an Atom entry for encoding.
+type atomEntry struct {
+	ID        string         `xml:"id"`
+	Title     atomText       `xml:"title"`
+	Updated   string         `xml:"updated"`
+	Published string         `xml:"published,omitempty"`
+	Links     []atomLink     `xml:"link"`
+	Author    *atomPerson    `xml:"author,omitempty"`
+	Category  []atomCategory `xml:"category"`
+	Summary   *atomText      `xml:"summary,omitempty"`
+	Content   *atomText      `xml:"content,omitempty"`
+}
+
+type atomText struct {
+	Type  string `xml:"type,attr,omitempty"`
+	Value string `xml:",chardata"`
+}
+
+// atomLink is a Link, with the length of the resource for enclosures.
+type atomLink struct {
+	Link
+	Length int64 `xml:"length,attr,omitempty"`
+}
+
+type atomPerson struct {
+	Name  string `xml:"name"`
+	Email string `xml:"email,omitempty"`
+}
+
+type atomCategory struct {
+	Term   string `xml:"term,attr"`
+	Scheme string `xml:"scheme,attr,omitempty"`
+}
+
+// ToAtom returns rss as an Atom 1.0 document, RFC 4287, XML declaration
+// included.
+//
+// Atom requires what RSS leaves optional, so some values are derived: the
+// id of the feed is its self link or else its link, that of an entry its
+// GUID or else its link, and either gets a name made from its title and
+// description if it has none of these. The feed is updated at its lastBuildDate, pubDate or newest
+// item, in that order of preference, or else now. An entry is updated at
+// its UpdatedDate or else its pubDate, or when the feed is if undated.
+// Descriptions become HTML summaries, ContentEncoded HTML content and
+// media objects enclosure links.
+func (rss *RSS) ToAtom() ([]byte, error) {
+	return rss.toAtom(time.Now())
+}
+
+// toAtom is ToAtom with an undated feed updated at now.
+func (rss *RSS) toAtom(now time.Time) ([]byte, error) {
+	ch := rss.Channel
+	updated := ch.LastBuildDate
+	if !hasDate(updated) {
+		updated = ch.PubDate
+	}
+	if !hasDate(updated) {
+		for i := range ch.Items {
+			if newer(ch.Items[i].PubDate, updated) {
+				updated = ch.Items[i].PubDate
+			}
+		}
+	}
+	feedUpdated := now
+	if hasDate(updated) {
+		feedUpdated = time.Time(*updated)
+	}
+
+	doc := atomFeed{
+		NS:        atomNS,
+		ID:        ch.AtomLinkByRel("self"),
+		Title:     atomText{Value: ch.Title},
+		Updated:   feedUpdated.Format(time.RFC3339),
+		Category:  atomCategories(ch.Categories),
+		Rights:    ch.Copyright,
+		Generator: ch.Generator,
+	}
+	if doc.ID == "" {
+		doc.ID = ch.Link
+	}
+	if doc.ID == "" {
+		doc.ID = contentID(ch.Title, ch.Description)
+	}
+	if ch.Description != "" {
+		doc.Subtitle = &atomText{Value: ch.Description}
+	}
+	if ch.Link != "" {
+		doc.Links = append(doc.Links, atomLink{Link: Link{Href: ch.Link, Rel: "alternate"}})
+	}
+	for _, l := range ch.AtomLinks {
+		if l.rel() != "alternate" || l.Href != ch.Link {
+			doc.Links = append(doc.Links, atomLink{Link: l})
+		}
+	}
+	if ch.ManagingEditor != "" {
+		doc.Author = &atomPerson{Name: ch.ManagingEditor}
+	}
+	if ch.Image != nil {
+		doc.Logo = ch.Image.URL
+	}
+
+	for _, it := range ch.Items {
+		e := atomEntry{
+			ID:       it.GUID.Value,
+			Title:    atomText{Value: it.Title},
+			Updated:  doc.Updated,
+			Category: atomCategories(it.Categories),
+		}
+		if e.ID == "" {
+			e.ID = it.Link
+		}
+		if e.ID == "" {
+			e.ID = contentID(it.Title, it.Description)
+		}
+		if hasDate(it.PubDate) {
+			e.Updated = it.PubDate.String()
+			e.Published = e.Updated
+		}
+		if hasDate(it.UpdatedDate) {
+			e.Updated = it.UpdatedDate.String()
+		}
+		if it.Link != "" {
+			e.Links = append(e.Links, atomLink{Link: Link{Href: it.Link, Rel: "alternate"}})
+		}
+		for _, l := range it.AltLinks {
+			e.Links = append(e.Links, atomLink{Link: l})
+		}
+		media := it.Media
+		if len(media) == 0 && it.Enclosure != nil {
+			media = []RSSEnclosure{*it.Enclosure}
+		}
+		for _, m := range media {
+			e.Links = append(e.Links, atomLink{Link: Link{Href: m.URL, Rel: "enclosure", Type: m.Type}, Length: m.Length})
+		}
+		if it.Author != "" {
+			e.Author = &atomPerson{Name: it.Author}
+		}
+		if it.Description != "" {
+			e.Summary = &atomText{Type: "html", Value: it.Description}
+		}
+		if it.ContentEncoded != "" {
+			e.Content = &atomText{Type: "html", Value: it.ContentEncoded}
+		}
+		doc.Entries = append(doc.Entries, e)
+	}
+
+	b, err := xml.Marshal(doc)
+	if err != nil {
+		logErr(err)
+		return nil, err
+	}
+	return append([]byte(xml.Header), b...), nil
+}
+
+// contentID returns a urn:sha1 name for what has title and description,
+// for a feed or entry that has no other id.
+func contentID(title, description string) string {
+	sum := sha1.Sum([]byte(title + "\x00" + description))
+	return "urn:sha1:" + hex.EncodeToString(sum[:])
+}
+
+// atomCategories returns a as Atom categories, named by their value.
+func atomCategories(a []RSSCategory) []atomCategory {
+	var categories []atomCategory
+	for _, ca := range a {
+		categories = append(categories, atomCategory{Term: ca.Value, Scheme: ca.Domain})
+	}
+	return categories
+}
+
+// AtomVersion is the Version of an RSS read from an Atom feed by
+// AtomFeed.
+const AtomVersion = "atom1.0"
+
+// atomFeedDocument mirrors an Atom feed document for decoding.
+type atomFeedDocument struct {
+	XMLName    xml.Name
+	Title      atomContent    `xml:"title"`
+	Subtitle   atomContent    `xml:"subtitle"`
+	Links      []atomLink     `xml:"link"`
+	Updated    string         `xml:"updated"`
+	Rights     atomContent    `xml:"rights"`
+	Generator  string         `xml:"generator"`
+	Logo       string         `xml:"logo"`
+	Icon       string         `xml:"icon"`
+	Authors    []atomPerson   `xml:"author"`
+	Categories []atomCategory `xml:"category"`
+	Entries    entrySink      `xml:"entry"`
+}
+
+// AtomFeed decodes the Atom 1.0 feed b, RFC 4287, into an RSS whose
+// Version is AtomVersion, which Feed, reading RSS only, doesn't. The
+// feed maps to the channel and its entries to items as they do in an RSS
+// feed mixing them in: the id of an entry is its GUID, its summary the
+// description, or else its content, and its published date, or else its
+// updated one, the pubDate. Of several alternate links, the first to an
+// HTML page is the link; the others, and links of other relations, are
+// kept in AltLinks, and those of the feed in AtomLinks, enclosure links
+// making media objects.
+//
+// Of the feed, the subtitle is the description, the rights the copyright,
+// the updated date the lastBuildDate, the first author the managing
+// editor, written "email (Name)" as in RSS, and the logo, or else the
+// icon, the image. The FeedOptions in DefaultFeedOptions apply.
+func AtomFeed(b []byte) (*RSS, error) {
+	return atomFeedWithOptions(b, DefaultFeedOptions)
+}
+
+// atomFeedWithOptions is AtomFeed decoding according to opts.
+func atomFeedWithOptions(b []byte, opts FeedOptions) (*RSS, error) {
+	logTrace("atomFeed()")
+
+	if opts.MaxFeedBytes > 0 && int64(len(b)) > opts.MaxFeedBytes {
+		logErr(ErrFeedTooLarge)
+		return nil, ErrFeedTooLarge
+	}
+
+	var doc atomFeedDocument
+	items := itemSink{opts: &opts}
+	doc.Entries.items = &items
+	if err := newDecoder(bytes.NewReader(b), opts).Decode(&doc); err != nil {
+		logErr(err)
+		return nil, err
+	}
+	if doc.XMLName.Space != atomNS || doc.XMLName.Local != "feed" {
+		err := fmt.Errorf("not an Atom feed: a <%s> document", doc.XMLName.Local)
+		logErr(err)
+		return nil, err
+	}
+
+	ch := RSSChannel{
+		Title:       doc.Title.text,
+		Description: doc.Subtitle.text,
+		Copyright:   doc.Rights.text,
+		Generator:   doc.Generator,
+		Items:       items.items,
+	}
+	var links []Link
+	for _, l := range doc.Links {
+		l.Href = strings.TrimSpace(l.Href)
+		links = append(links, l.Link)
+	}
+	ch.Link, ch.AtomLinks = alternateLink(links)
+	ch.LastBuildDate = parseEntryDate(doc.Updated, opts.DefaultLocation)
+	if len(doc.Authors) > 0 {
+		switch a := doc.Authors[0]; {
+		case a.Email != "" && a.Name != "":
+			ch.ManagingEditor = a.Email + " (" + a.Name + ")"
+		case a.Email != "":
+			ch.ManagingEditor = a.Email
+		default:
+			ch.ManagingEditor = a.Name
+		}
+	}
+	for _, ca := range doc.Categories {
+		if ca.Term != "" {
+			ch.Categories = append(ch.Categories, RSSCategory{Value: ca.Term, Domain: ca.Scheme})
+		}
+	}
+	logo := strings.TrimSpace(doc.Logo)
+	if logo == "" {
+		logo = strings.TrimSpace(doc.Icon)
+	}
+	if logo != "" {
+		ch.Image = &RSSImage{URL: logo, Title: ch.Title, Link: ch.Link}
+	}
+	trimChannel(&ch, opts.Trim)
+
+	return &RSS{
+		Version:      AtomVersion,
+		Channel:      ch,
+		origin:       b,
+		lastUpdateAt: time.Now(),
+	}, nil
+}
@@ -0,0 +1,21 @@
+// Copyright 2018 cotox. All rights reserved.
+// Use of this source code is governed by a GPLv3
+// license that can be found in the LICENSE file.
+
+//go:build brotli
+// +build brotli
+
+package rssutil
+
+import (
+	"io"
+
+	"github.com/andybalholm/brotli"
+)
+
+func init() {
+	contentDecoders["br"] = func(r io.Reader) (io.Reader, error) {
+		return brotli.NewReader(r), nil
+	}
+	acceptEncoding += ", br"
+}
@@ -0,0 +1,43 @@
+// Copyright 2018 cotox. All rights reserved.
+// Use of this source code is governed by a GPLv3
+// license that can be found in the LICENSE file.
+
+//go:build brotli
+// +build brotli
+
+package rssutil
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+)
+
+func TestFetchFeedBrotli(t *testing.T) {
+	var br bytes.Buffer
+	w := brotli.NewWriter(&br)
+	w.Write([]byte(`<rss version="2.0"><channel><title>Brotli</title></channel></rss>`))
+	w.Close()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.Contains(r.Header.Get("Accept-Encoding"), "br") {
+			t.Errorf("Accept-Encoding doesn't list br, %#v", r.Header.Get("Accept-Encoding"))
+		}
+		w.Header().Set("Content-Encoding", "br")
+		w.Write(br.Bytes())
+	}))
+	defer srv.Close()
+
+	rss, err := FetchFeed(context.Background(), srv.URL, FetchOptions{})
+	if err != nil {
+		t.Fatal("fetch failed:", err)
+	}
+	if rss.Channel.Title != "Brotli" {
+		t.Errorf("rss.Channel.Title != \"Brotli\", %#v", rss.Channel.Title)
+	}
+}
@@ -0,0 +1,51 @@
+// Copyright 2018 cotox. All rights reserved.
+// Use of this source code is governed by a GPLv3
+// license that can be found in the LICENSE file.
+
+package rssutil
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+
+	"golang.org/x/text/encoding/htmlindex"
+)
+
+// charsetReader implements xml.Decoder.CharsetReader for the encodings
+// feeds declare besides UTF-8: any encoding of the WHATWG Encoding
+// Standard, such as windows-1252, GBK, GB18030, Big5, Shift_JIS or
+// EUC-KR, decoded by golang.org/x/text.
+//
+// Like web browsers, it decodes ISO-8859-1 as its superset
+// windows-1252: feeds labelled Latin-1 routinely contain windows-1252
+// punctuation, and the C1 control characters the two disagree on never
+// appear in real text.
+func charsetReader(label string, input io.Reader) (io.Reader, error) {
+	switch strings.ToLower(strings.TrimSpace(label)) {
+	case "utf-8", "utf8", "us-ascii", "ascii":
+		return input, nil
+	}
+	enc, err := htmlindex.Get(label)
+	if err != nil {
+		return nil, fmt.Errorf("unsupported charset %q", label)
+	}
+	return enc.NewDecoder().Reader(input), nil
+}
+
+// prologEncodingRE matches the encoding declaration of an XML prolog.
+var prologEncodingRE = regexp.MustCompile(`^(?:\x{FEFF})?\s*<\?xml[^>]*?\sencoding\s*=\s*["']([^"']*)["']`)
+
+// prologEncoding returns the encoding declared by the XML prolog of b, or
+// "" if it declares none.
+func prologEncoding(b []byte) string {
+	if len(b) > 1024 {
+		b = b[:1024]
+	}
+	m := prologEncodingRE.FindSubmatch(b)
+	if m == nil {
+		return ""
+	}
+	return string(m[1])
+}
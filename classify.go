@@ -0,0 +1,135 @@
+// Copyright 2018 cotox. All rights reserved.
+// Use of this source code is governed by a GPLv3
+// license that can be found in the LICENSE file.
+
+package rssutil
+
+import (
+	"bytes"
+	"encoding/xml"
+	"html"
+	"regexp"
+	"strings"
+)
+
+// Classify tells what the document b is, for callers handed an arbitrary
+// URL that may not be a feed. kind is:
+//
+//	"feed"        an RSS, Atom or JSON feed, or one a registered Parser
+//	              accepts; feeds is nil
+//	"opml"        an OPML subscription list; feeds are the xmlUrl of its
+//	              outlines, nested ones included
+//	"html-index"  an HTML page advertising feeds; feeds are the hrefs of
+//	              its <link rel="alternate"> feed links
+//
+// It returns "" and nil for anything else, HTML pages advertising no feed
+// among them. URLs are returned as written, duplicates removed; relative
+// ones are relative to the document.
+func Classify(b []byte) (kind string, feeds []string) {
+	if parserFor(b) != nil {
+		return "feed", nil
+	}
+	switch documentFormat(b, "") {
+	case "rss", "rdf", "atom", "jsonfeed":
+		return "feed", nil
+	case "opml":
+		if feeds = opmlFeeds(b); feeds != nil {
+			return "opml", feeds
+		}
+	case "html":
+		if feeds = htmlFeeds(b); feeds != nil {
+			return "html-index", feeds
+		}
+	}
+	return "", nil
+}
+
+// opmlOutline is an <outline> of an OPML document.
+type opmlOutline struct {
+	XMLURL   string        `xml:"xmlUrl,attr"`
+	Outlines []opmlOutline `xml:"outline"`
+}
+
+// opmlFeeds returns the feed URLs of the OPML document b, or nil if it has
+// none or doesn't decode.
+func opmlFeeds(b []byte) []string {
+	var doc struct {
+		Outlines []opmlOutline `xml:"body>outline"`
+	}
+	d := xml.NewDecoder(bytes.NewReader(b))
+	d.Strict = false
+	d.CharsetReader = charsetReader
+	if err := d.Decode(&doc); err != nil {
+		logWarnf("decode OPML: %v", err)
+		return nil
+	}
+
+	var feeds []string
+	seen := make(map[string]bool)
+	var walk func([]opmlOutline)
+	walk = func(a []opmlOutline) {
+		for _, o := range a {
+			if u := strings.TrimSpace(o.XMLURL); u != "" && !seen[u] {
+				seen[u] = true
+				feeds = append(feeds, u)
+			}
+			walk(o.Outlines)
+		}
+	}
+	walk(doc.Outlines)
+	return feeds
+}
+
+var (
+	// linkTagRE matches the <link> tags of an HTML page.
+	linkTagRE = regexp.MustCompile(`(?is)<link\b[^>]*>`)
+
+	// htmlAttrRE matches an attribute of an HTML tag, its value quoted or
+	// not.
+	htmlAttrRE = regexp.MustCompile(`(?is)([a-z][a-z0-9-]*)\s*=\s*(?:"([^"]*)"|'([^']*)'|([^\s"'>]+))`)
+)
+
+// feedTypes are the media types of feed links in HTML pages.
+var feedTypes = map[string]bool{
+	"application/rss+xml":   true,
+	"application/atom+xml":  true,
+	"application/rdf+xml":   true,
+	"application/feed+json": true,
+	"application/json":      true,
+}
+
+// htmlFeeds returns the URLs of the feeds the HTML page b advertises, or
+// nil if it advertises none. The page isn't parsed, HTML being seldom
+// well-formed XML; its <link> tags are matched.
+func htmlFeeds(b []byte) []string {
+	var feeds []string
+	seen := make(map[string]bool)
+	for _, tag := range linkTagRE.FindAll(b, -1) {
+		attrs := make(map[string]string)
+		for _, m := range htmlAttrRE.FindAllSubmatch(tag, -1) {
+			attrs[strings.ToLower(string(m[1]))] = html.UnescapeString(string(m[2]) + string(m[3]) + string(m[4]))
+		}
+		typ := strings.ToLower(strings.TrimSpace(attrs["type"]))
+		if i := strings.IndexByte(typ, ';'); i >= 0 {
+			typ = strings.TrimSpace(typ[:i])
+		}
+		if !isAlternate(attrs["rel"]) || !feedTypes[typ] {
+			continue
+		}
+		if u := strings.TrimSpace(attrs["href"]); u != "" && !seen[u] {
+			seen[u] = true
+			feeds = append(feeds, u)
+		}
+	}
+	return feeds
+}
+
+// isAlternate reports whether the rel attribute v includes "alternate".
+func isAlternate(v string) bool {
+	for _, rel := range strings.Fields(v) {
+		if strings.EqualFold(rel, "alternate") {
+			return true
+		}
+	}
+	return false
+}
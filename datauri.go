@@ -0,0 +1,70 @@
+// Copyright 2018 cotox. All rights reserved.
+// Use of this source code is governed by a GPLv3
+// license that can be found in the LICENSE file.
+
+package rssutil
+
+import (
+	"encoding/base64"
+	"errors"
+	"net/url"
+	"strings"
+)
+
+// errNotDataURI is returned by DecodeData for enclosures fetched over
+// the network.
+var errNotDataURI = errors.New("enclosure url isn't a data URI")
+
+// IsDataURI reports whether the url of ec is a data: URI, holding the
+// media itself rather than pointing at it.
+func (ec RSSEnclosure) IsDataURI() bool {
+	u := strings.TrimSpace(ec.URL)
+	return len(u) >= 5 && strings.EqualFold(u[:5], "data:")
+}
+
+// DecodeData returns the media held by the data: URI url of ec, and its
+// MIME type, parameters included. The type defaults to
+// "text/plain;charset=US-ASCII", and to text/plain when only parameters
+// are given, as RFC 2397 says. The payload may be base64 encoded, padded
+// or not and with embedded whitespace, or percent encoded.
+func (ec RSSEnclosure) DecodeData() ([]byte, string, error) {
+	if !ec.IsDataURI() {
+		return nil, "", errNotDataURI
+	}
+	u := strings.TrimSpace(ec.URL)[5:]
+	i := strings.IndexByte(u, ',')
+	if i < 0 {
+		return nil, "", errors.New("malformed data URI: missing comma")
+	}
+	mediaType, payload := u[:i], u[i+1:]
+
+	isBase64 := false
+	if j := strings.LastIndexByte(mediaType, ';'); j >= 0 && strings.EqualFold(strings.TrimSpace(mediaType[j+1:]), "base64") {
+		isBase64 = true
+		mediaType = mediaType[:j]
+	}
+	switch mediaType = strings.TrimSpace(mediaType); {
+	case mediaType == "":
+		mediaType = "text/plain;charset=US-ASCII"
+	case mediaType[0] == ';':
+		mediaType = "text/plain" + mediaType
+	}
+
+	if !isBase64 {
+		b, err := url.PathUnescape(payload)
+		if err != nil {
+			return nil, "", err
+		}
+		return []byte(b), mediaType, nil
+	}
+
+	if p, err := url.PathUnescape(payload); err == nil {
+		payload = p
+	}
+	payload = strings.Join(strings.Fields(payload), "")
+	b, err := base64.RawStdEncoding.DecodeString(strings.TrimRight(payload, "="))
+	if err != nil {
+		return nil, "", err
+	}
+	return b, mediaType, nil
+}
@@ -0,0 +1,1010 @@
+// Copyright 2018 cotox. All rights reserved.
+// Use of this source code is governed by a GPLv3
+// license that can be found in the LICENSE file.
+
+package rssutil
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// atomNS is the namespace of the Atom Syndication Format.
+const atomNS = "http://www.w3.org/2005/Atom"
+
+// dcNS is the namespace of the Dublin Core elements.
+const dcNS = "http://purl.org/dc/elements/1.1/"
+
+// mediaNS is the namespace of Media RSS.
+const mediaNS = "http://search.yahoo.com/mrss/"
+
+// contentNS is the namespace of the RSS content module.
+const contentNS = "http://purl.org/rss/1.0/modules/content/"
+
+// itunesNS is the namespace of the iTunes podcast elements.
+const itunesNS = "http://www.itunes.com/dtds/podcast-1.0.dtd"
+
+// isITunesNS reports whether space is the iTunes namespace, which is
+// sometimes declared with an https scheme or different case.
+func isITunesNS(space string) bool {
+	space = strings.TrimPrefix(strings.TrimPrefix(space, "http://"), "https://")
+	return strings.EqualFold(space, strings.TrimPrefix(itunesNS, "http://"))
+}
+
+// isDCNS reports whether space is the Dublin Core namespace. Some
+// generators declare it with an https scheme.
+func isDCNS(space string) bool {
+	return space == dcNS || space == "https://purl.org/dc/elements/1.1/"
+}
+
+// rssDocument mirrors RSS for decoding. Feed decodes into it rather than
+// into RSS so that items can be handled one at a time as they're read.
+type rssDocument struct {
+	XMLName xml.Name
+	Version string          `xml:"version,attr"`
+	Channel channelDocument `xml:"channel"`
+}
+
+// version returns the version of the document. An <rss> root with a
+// channel but without a version is taken to be RSS 2.0, as such feeds
+// almost always are.
+func (doc *rssDocument) version() string {
+	return rssVersion(doc.XMLName, doc.Version, doc.Channel.XMLName.Local != "")
+}
+
+// rssVersion returns the version of a document with the given root
+// element, version attribute and, if hasChannel, a channel, as
+// rssDocument.version does.
+func rssVersion(root xml.Name, version string, hasChannel bool) string {
+	v := strings.TrimSpace(version)
+	if v == "" && root.Local == "rss" && hasChannel {
+		return "2.0"
+	}
+	return v
+}
+
+// channelDocument mirrors RSSChannel for decoding. Its fields shadow
+// those of RSSChannel that need more than the default decoding: every
+// <item> is routed through an itemSink, <link> and <atom:link> are told
+// apart, so are <category> and <itunes:category>, <skipDays> holds day
+// names and dates are parsed in the configured location. Categories are
+// also read from a <categories> wrapper, which some generators emit, and
+// items from Atom <entry> elements, which hybrid feeds use instead.
+type channelDocument struct {
+	XMLName xml.Name
+	RSSChannel
+	Link           linkSink     `xml:"link"`
+	PubDate        dateSink     `xml:"pubDate"`
+	LastBuildDate  dateSink     `xml:"lastBuildDate"`
+	Categories     categorySink `xml:"category"`
+	Wrapped        categorySink `xml:"categories>category"`
+	Items          itemSink     `xml:"item"`
+	Entries        entrySink    `xml:"entry"`
+	SkipDays       []string     `xml:"skipDays>day"`
+	NewLocation    string       `xml:"newLocation"`
+	Image          imageSink    `xml:"image"`
+	ITunesAuthor   itunesText   `xml:"author"`
+	ITunesSubtitle itunesText   `xml:"subtitle"`
+	ITunesSummary  itunesText   `xml:"summary"`
+	ITunesExplicit itunesText   `xml:"explicit"`
+	ITunesNewURL   itunesText   `xml:"new-feed-url"`
+	ITunesOwner    itunesOwner  `xml:"owner"`
+
+	trim TrimFields
+}
+
+// setOptions makes c decode according to opts.
+func (c *channelDocument) setOptions(opts *FeedOptions) {
+	c.PubDate.loc = opts.DefaultLocation
+	c.LastBuildDate.loc = opts.DefaultLocation
+	c.Items.opts = opts
+	c.Entries.items = &c.Items
+	c.trim = opts.Trim
+}
+
+// channel returns the decoded RSSChannel, trimmed as setOptions said.
+func (c *channelDocument) channel() RSSChannel {
+	ch := c.RSSChannel
+	ch.Link = c.Link.link
+	ch.AtomLinks = c.Link.atom
+	ch.PubDate = c.PubDate.date
+	ch.LastBuildDate = c.LastBuildDate.date
+	ch.Image = c.Image.image
+	ch.Categories = append(c.Categories.categories, c.Wrapped.categories...)
+	itunes := append(c.Categories.itunes, c.Wrapped.itunes...)
+	ch.NewLocation = strings.Trim(c.NewLocation, cutset)
+	if ch.NewLocation == "" {
+		ch.NewLocation = c.ITunesNewURL.value
+	}
+	ch.Items = c.Items.items
+	ch.SkipDays = nil
+	for _, name := range c.SkipDays {
+		day, ok := weekdays[strings.TrimSpace(name)]
+		if !ok {
+			logWarnf("unknown skipDays day %q", name)
+			continue
+		}
+		ch.SkipDays = append(ch.SkipDays, day)
+	}
+	if ext := (ITunesChannel{
+		Author:     c.ITunesAuthor.value,
+		Subtitle:   c.ITunesSubtitle.value,
+		Summary:    c.ITunesSummary.value,
+		Image:      c.Image.itunes.href,
+		Categories: itunes,
+		Explicit:   c.ITunesExplicit.value,
+		OwnerName:  c.ITunesOwner.Name.value,
+		OwnerEmail: c.ITunesOwner.Email.value,
+	}); ext.Author != "" || ext.Subtitle != "" || ext.Summary != "" ||
+		ext.Image != "" || ext.Explicit != "" || ext.Categories != nil ||
+		ext.OwnerName != "" || ext.OwnerEmail != "" {
+		ch.ITunes = &ext
+	}
+	trimChannel(&ch, c.trim)
+
+	// iTunes categories count as categories too, for feeds that only
+	// give those.
+	for _, name := range itunes {
+		if !inCategory(ch.Categories, name) {
+			ch.Categories = append(ch.Categories, RSSCategory{Value: name})
+		}
+	}
+	return ch
+}
+
+// weekdays maps the day names used by <skipDays> to time.Weekday.
+var weekdays = map[string]time.Weekday{
+	"Sunday":    time.Sunday,
+	"Monday":    time.Monday,
+	"Tuesday":   time.Tuesday,
+	"Wednesday": time.Wednesday,
+	"Thursday":  time.Thursday,
+	"Friday":    time.Friday,
+	"Saturday":  time.Saturday,
+}
+
+// itemSink collects the <item> elements of a channel, applying the
+// per-item FeedOptions as each one is decoded.
+type itemSink struct {
+	opts  *FeedOptions
+	items []RSSItem
+	guids map[string]int // index in items of the item using each GUID
+}
+
+// UnmarshalXML implements the xml.Unmarshaler interface.
+func (s *itemSink) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	if s.full() {
+		return d.Skip()
+	}
+
+	var doc itemDocument
+	doc.PubDate.loc = s.opts.DefaultLocation
+	if err := d.DecodeElement(&doc, &start); err != nil {
+		return err
+	}
+	s.add(doc.item(s.opts.Trim))
+	return nil
+}
+
+// full reports whether s holds FeedOptions.MaxItems items already.
+func (s *itemSink) full() bool {
+	return s.opts.MaxItems > 0 && len(s.items) >= s.opts.MaxItems
+}
+
+// add appends the decoded item it to s, unless FeedOptions say to drop
+// it.
+func (s *itemSink) add(it RSSItem) {
+	if s.opts.ItemTransform != nil && !s.opts.ItemTransform(&it) {
+		return
+	}
+	if it.GUID.Value != "" {
+		if i, ok := s.guids[it.GUID.Value]; ok {
+			logWarnf("duplicate guid %q", it.GUID.Value)
+			switch s.opts.DuplicateGUIDs {
+			case KeepFirstGUID:
+				return
+			case KeepNewestGUID:
+				if newer(it.PubDate, s.items[i].PubDate) {
+					s.items[i] = it
+				}
+				return
+			}
+		} else {
+			if s.guids == nil {
+				s.guids = make(map[string]int)
+			}
+			s.guids[it.GUID.Value] = len(s.items)
+		}
+	}
+	s.items = append(s.items, it)
+}
+
+// entrySink decodes the Atom <entry> elements some feeds put in an RSS
+// channel in place of <item>s, adding them to items like those.
+type entrySink struct {
+	items *itemSink
+}
+
+// UnmarshalXML implements the xml.Unmarshaler interface.
+func (s *entrySink) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	if s.items.full() {
+		return d.Skip()
+	}
+
+	var doc entryDocument
+	if err := d.DecodeElement(&doc, &start); err != nil {
+		return err
+	}
+	s.items.add(doc.item(s.items.opts))
+	return nil
+}
+
+// entryDocument mirrors an Atom entry for decoding. The elements are
+// matched whatever their namespace, as feeds mixing Atom into RSS are
+// seldom careful about it.
+type entryDocument struct {
+	ID         string         `xml:"id"`
+	Title      atomContent    `xml:"title"`
+	Links      []atomLink     `xml:"link"`
+	Summary    atomContent    `xml:"summary"`
+	Content    atomContent    `xml:"content"`
+	Published  string         `xml:"published"`
+	Updated    string         `xml:"updated"`
+	Authors    []atomPerson   `xml:"author"`
+	Categories []atomCategory `xml:"category"`
+}
+
+// atomContent decodes an Atom text construct: its text, HTML being
+// escaped in it, or for XHTML the markup inside its <div>, without
+// namespaces. The markup is written anew from its tokens, the decoder
+// not saving the raw document when MaxDepth or MaxTokens guard it.
+type atomContent struct {
+	text string
+}
+
+// UnmarshalXML implements the xml.Unmarshaler interface.
+func (c *atomContent) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	xhtml := false
+	for _, a := range start.Attr {
+		if a.Name.Local == "type" && strings.TrimSpace(a.Value) == "xhtml" {
+			xhtml = true
+		}
+	}
+	if !xhtml {
+		return d.DecodeElement(&c.text, &start)
+	}
+
+	var b bytes.Buffer
+	e := xml.NewEncoder(&b)
+	depth := 0
+	div := false // whether the content is wrapped in a <div>, as it should
+	for {
+		t, err := d.Token()
+		if err != nil {
+			return err
+		}
+		switch tok := t.(type) {
+		case xml.StartElement:
+			depth++
+			if depth == 1 && tok.Name.Local == "div" && b.Len() == 0 {
+				div = true
+				continue
+			}
+			tok.Name.Space = ""
+			attr := tok.Attr[:0]
+			for _, a := range tok.Attr {
+				if a.Name.Space != "xmlns" && a.Name.Local != "xmlns" {
+					a.Name.Space = ""
+					attr = append(attr, a)
+				}
+			}
+			tok.Attr = attr
+			t = tok
+		case xml.EndElement:
+			if depth == 0 {
+				if err := e.Flush(); err != nil {
+					return err
+				}
+				c.text = b.String()
+				return nil
+			}
+			depth--
+			if depth == 0 && div {
+				continue
+			}
+			tok.Name.Space = ""
+			t = tok
+		case xml.CharData:
+			if depth == 0 && (div || len(bytes.TrimSpace(tok)) == 0) {
+				continue
+			}
+		case xml.ProcInst, xml.Directive:
+			continue
+		}
+		if err := e.EncodeToken(t); err != nil {
+			return err
+		}
+		if err := e.Flush(); err != nil {
+			return err
+		}
+	}
+}
+
+// item returns the decoded entry as an RSSItem, trimmed as opts say: its
+// id is the GUID, its alternate link the link, its enclosure links
+// its media and its summary the description, or else its content, which
+// is otherwise ContentEncoded. The pubDate is the published date, or the
+// updated date, required by Atom, if there is none.
+func (doc *entryDocument) item(opts *FeedOptions) RSSItem {
+	it := RSSItem{
+		Title: doc.Title.text,
+		GUID:  GUID{Value: doc.ID},
+	}
+	var links []Link
+	for _, l := range doc.Links {
+		l.Href = strings.TrimSpace(l.Href)
+		if l.rel() == "enclosure" {
+			it.Media = append(it.Media, RSSEnclosure{URL: l.Href, Type: l.Type, Length: l.Length})
+			continue
+		}
+		links = append(links, l.Link)
+	}
+	it.Link, it.AltLinks = alternateLink(links)
+	if len(it.Media) > 0 {
+		it.Enclosure = &it.Media[0]
+	}
+
+	summary, content := doc.Summary.text, doc.Content.text
+	if strings.TrimSpace(summary) != "" {
+		it.Description, it.ContentEncoded = summary, content
+	} else {
+		it.Description = content
+	}
+
+	it.UpdatedDate = parseEntryDate(doc.Updated, opts.DefaultLocation)
+	if it.PubDate = parseEntryDate(doc.Published, opts.DefaultLocation); it.PubDate == nil {
+		it.PubDate = it.UpdatedDate
+	}
+	if len(doc.Authors) > 0 {
+		it.Author = doc.Authors[0].Name
+	}
+	for _, ca := range doc.Categories {
+		if ca.Term != "" {
+			it.Categories = append(it.Categories, RSSCategory{Value: ca.Term, Domain: ca.Scheme})
+		}
+	}
+	trimItem(&it, opts.Trim)
+	return it
+}
+
+// alternateLink returns the href of the alternate link among links, and
+// the other links in order. That is the first alternate link to a page,
+// of type HTML or not given, or else the first alternate link: entries
+// also link to their alternate versions, such as a PDF.
+func alternateLink(links []Link) (href string, rest []Link) {
+	i := -1
+	for j, l := range links {
+		if l.rel() != "alternate" {
+			continue
+		}
+		if t := strings.ToLower(strings.TrimSpace(l.Type)); t == "" || t == "text/html" || t == "application/xhtml+xml" {
+			i = j
+			break
+		}
+		if i < 0 {
+			i = j
+		}
+	}
+	for j, l := range links {
+		if j == i {
+			href = l.Href
+		} else {
+			rest = append(rest, l)
+		}
+	}
+	return href, rest
+}
+
+// parseEntryDate parses an Atom date, RFC 3339, or an RSS one as broken
+// feeds give, or returns nil, with a warning if v isn't empty.
+func parseEntryDate(v string, loc *time.Location) *RFC822 {
+	if v = strings.Trim(v, cutset); v == "" {
+		return nil
+	}
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		d := RFC822(t)
+		return &d
+	}
+	if d, _, err := parseRFC822(v, loc); err == nil {
+		return &d
+	}
+	logWarnf("bad entry date %q", v)
+	return nil
+}
+
+// itemDocument mirrors RSSItem for decoding, collecting the elements
+// that map onto RSSItem fields from other vocabularies.
+type itemDocument struct {
+	RSSItem
+	Link       linkSink       `xml:"link"`
+	Enclosures []RSSEnclosure `xml:"enclosure"`
+	Media      mediaSink      `xml:"content"`
+	MediaGroup mediaSink      `xml:"group"`
+	PubDate    dateSink       `xml:"pubDate"`
+	Published  atomDateSink   `xml:"published"`
+	Updated    atomDateSink   `xml:"updated"`
+	Subjects   subjectSink    `xml:"subject"`
+	Encoded    encodedSink    `xml:"encoded"`
+	Author     authorSink     `xml:"author"`
+
+	ITunesSubtitle    itunesText  `xml:"subtitle"`
+	ITunesSummary     itunesText  `xml:"summary"`
+	ITunesImage       itunesImage `xml:"image"`
+	ITunesDuration    itunesText  `xml:"duration"`
+	ITunesEpisode     itunesText  `xml:"episode"`
+	ITunesSeason      itunesText  `xml:"season"`
+	ITunesEpisodeType itunesText  `xml:"episodeType"`
+	ITunesExplicit    itunesText  `xml:"explicit"`
+}
+
+// item returns the decoded RSSItem, with the fields in trim trimmed.
+// Dublin Core subjects are appended to its categories, and the
+// <itunes:author> stands for a missing author.
+func (doc *itemDocument) item(trim TrimFields) RSSItem {
+	it := doc.RSSItem
+	it.Author = doc.Author.author
+	if it.Author == "" {
+		it.Author = doc.Author.itunes.value
+	}
+	it.Link = doc.Link.link
+	it.AltLinks = doc.Link.atom
+	it.PubDate, it.localDate = doc.PubDate.date, doc.PubDate.local
+	if it.PubDate == nil {
+		it.PubDate = doc.Published.date
+	}
+	it.UpdatedDate = doc.Updated.date
+	it.ContentEncoded = doc.Encoded.value
+	it.Media = append(doc.Enclosures, doc.Media.media...)
+	it.Media = append(it.Media, doc.MediaGroup.media...)
+	if len(doc.Enclosures) > 0 {
+		it.Enclosure = &it.Media[0]
+	}
+	if ext := (ITunesItem{
+		Author:      doc.Author.itunes.value,
+		Subtitle:    doc.ITunesSubtitle.value,
+		Summary:     doc.ITunesSummary.value,
+		Image:       doc.ITunesImage.href,
+		Duration:    parseDuration(doc.ITunesDuration.value),
+		Episode:     doc.ITunesEpisode.value,
+		Season:      doc.ITunesSeason.value,
+		EpisodeType: doc.ITunesEpisodeType.value,
+		Explicit:    doc.ITunesExplicit.value,
+	}); ext != (ITunesItem{}) {
+		it.ITunes = &ext
+	}
+	trimItem(&it, trim)
+
+subjects:
+	for _, v := range doc.Subjects.values {
+		for _, ca := range it.Categories {
+			if ca.Value == v {
+				continue subjects
+			}
+		}
+		it.Categories = append(it.Categories, RSSCategory{Value: v})
+	}
+	return it
+}
+
+// dateSink decodes a date element, reading dates that carry no zone in
+// loc.
+type dateSink struct {
+	loc   *time.Location
+	date  *RFC822
+	local bool // the date carries no zone
+}
+
+// UnmarshalXML implements the xml.Unmarshaler interface.
+func (s *dateSink) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	var v string
+	if err := d.DecodeElement(&v, &start); err != nil {
+		return err
+	}
+	t, zoned, err := parseRFC822(v, s.loc)
+	if err != nil {
+		return err
+	}
+	s.date, s.local = &t, !zoned
+	return nil
+}
+
+// atomDateSink decodes an Atom date element, an RFC 3339 date. Other
+// elements of the same name are skipped, and so are dates that don't
+// parse, with a warning: they only add to the RSS elements.
+type atomDateSink struct {
+	date *RFC822
+}
+
+// UnmarshalXML implements the xml.Unmarshaler interface.
+func (s *atomDateSink) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	if start.Name.Space != atomNS {
+		return d.Skip()
+	}
+	var v string
+	if err := d.DecodeElement(&v, &start); err != nil {
+		return err
+	}
+	t, err := time.Parse(time.RFC3339, strings.Trim(v, cutset))
+	if err != nil {
+		logWarnf("bad atom:%s date %q", start.Name.Local, v)
+		return nil
+	}
+	date := RFC822(t)
+	s.date = &date
+	return nil
+}
+
+// mediaSink collects <media:content> elements, and those of
+// <media:group> elements.
+type mediaSink struct {
+	media []RSSEnclosure
+}
+
+// UnmarshalXML implements the xml.Unmarshaler interface.
+func (s *mediaSink) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	if start.Name.Space != mediaNS {
+		return d.Skip()
+	}
+	if start.Name.Local == "group" {
+		var group struct {
+			Content mediaSink `xml:"content"`
+		}
+		if err := d.DecodeElement(&group, &start); err != nil {
+			return err
+		}
+		s.media = append(s.media, group.Content.media...)
+		return nil
+	}
+
+	var c struct {
+		URL      string `xml:"url,attr"`
+		FileSize string `xml:"fileSize,attr"`
+		Type     string `xml:"type,attr"`
+		Bitrate  string `xml:"bitrate,attr"`
+	}
+	if err := d.DecodeElement(&c, &start); err != nil {
+		return err
+	}
+	// Sizes and bitrates are sometimes given with decimals or units;
+	// those that don't parse are left unknown.
+	size, _ := strconv.ParseInt(strings.TrimSpace(c.FileSize), 10, 64)
+	bitrate, _ := strconv.ParseFloat(strings.TrimSpace(c.Bitrate), 64)
+	s.media = append(s.media, RSSEnclosure{
+		URL:     strings.TrimSpace(c.URL),
+		Length:  size,
+		Type:    strings.TrimSpace(c.Type),
+		Bitrate: int(bitrate),
+	})
+	return nil
+}
+
+// subjectSink collects the values of <dc:subject> elements.
+type subjectSink struct {
+	values []string
+}
+
+// UnmarshalXML implements the xml.Unmarshaler interface.
+func (s *subjectSink) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	if !isDCNS(start.Name.Space) {
+		return d.Skip()
+	}
+	var v string
+	if err := d.DecodeElement(&v, &start); err != nil {
+		return err
+	}
+	if v = strings.Trim(v, cutset); v != "" {
+		s.values = append(s.values, v)
+	}
+	return nil
+}
+
+// categorySink collects <category> elements, and apart from them
+// <itunes:category> ones, which give their name in a text attribute and
+// may nest a subcategory. A subcategory is recorded as "Parent/Child",
+// after its parent.
+type categorySink struct {
+	categories []RSSCategory
+	itunes     []string
+}
+
+// UnmarshalXML implements the xml.Unmarshaler interface.
+func (s *categorySink) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	if !isITunesNS(start.Name.Space) {
+		var ca RSSCategory
+		if err := d.DecodeElement(&ca, &start); err != nil {
+			return err
+		}
+		s.categories = append(s.categories, ca)
+		return nil
+	}
+
+	var ca struct {
+		Text string `xml:"text,attr"`
+		Sub  []struct {
+			Text string `xml:"text,attr"`
+		} `xml:"category"`
+	}
+	if err := d.DecodeElement(&ca, &start); err != nil {
+		return err
+	}
+	name := strings.Trim(ca.Text, cutset)
+	if name == "" {
+		return nil
+	}
+	s.itunes = append(s.itunes, name)
+	for _, sub := range ca.Sub {
+		if v := strings.Trim(sub.Text, cutset); v != "" {
+			s.itunes = append(s.itunes, name+"/"+v)
+		}
+	}
+	return nil
+}
+
+// itunesText decodes an iTunes text element, ignoring elements of the
+// same name in other namespaces. The first non-empty one is kept.
+type itunesText struct {
+	value string
+}
+
+// UnmarshalXML implements the xml.Unmarshaler interface.
+func (s *itunesText) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	if !isITunesNS(start.Name.Space) || s.value != "" {
+		return d.Skip()
+	}
+	var v string
+	if err := d.DecodeElement(&v, &start); err != nil {
+		return err
+	}
+	s.value = strings.Trim(v, cutset)
+	return nil
+}
+
+// encodedSink decodes a <content:encoded>, ignoring elements of the same
+// name in other namespaces.
+type encodedSink struct {
+	value string
+}
+
+// UnmarshalXML implements the xml.Unmarshaler interface.
+func (s *encodedSink) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	if start.Name.Space != contentNS {
+		return d.Skip()
+	}
+	return d.DecodeElement(&s.value, &start)
+}
+
+// itunesImage decodes an <itunes:image>, which gives its URL in an href
+// attribute, ignoring elements of the same name in other namespaces. The
+// first non-empty one is kept.
+type itunesImage struct {
+	href string
+}
+
+// UnmarshalXML implements the xml.Unmarshaler interface.
+func (s *itunesImage) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	if !isITunesNS(start.Name.Space) || s.href != "" {
+		return d.Skip()
+	}
+	var im struct {
+		Href string `xml:"href,attr"`
+	}
+	if err := d.DecodeElement(&im, &start); err != nil {
+		return err
+	}
+	s.href = strings.Trim(im.Href, cutset)
+	return nil
+}
+
+// imageSink decodes the <image> of a channel, and apart from it the
+// <itunes:image>, which encoding/xml on its own matches against the same
+// field.
+type imageSink struct {
+	image  *RSSImage
+	itunes itunesImage
+}
+
+// UnmarshalXML implements the xml.Unmarshaler interface.
+func (s *imageSink) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	if isITunesNS(start.Name.Space) {
+		return s.itunes.UnmarshalXML(d, start)
+	}
+	if s.image != nil {
+		return d.Skip()
+	}
+	s.image = new(RSSImage)
+	return d.DecodeElement(s.image, &start)
+}
+
+// authorSink decodes the <author> of an item, and apart from it the
+// <itunes:author>.
+type authorSink struct {
+	author string
+	itunes itunesText
+}
+
+// UnmarshalXML implements the xml.Unmarshaler interface.
+func (s *authorSink) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	if isITunesNS(start.Name.Space) {
+		return s.itunes.UnmarshalXML(d, start)
+	}
+	return d.DecodeElement(&s.author, &start)
+}
+
+// itunesOwner decodes an <itunes:owner>, ignoring elements of the same
+// name in other namespaces.
+type itunesOwner struct {
+	Name  itunesText `xml:"name"`
+	Email itunesText `xml:"email"`
+}
+
+// UnmarshalXML implements the xml.Unmarshaler interface.
+func (s *itunesOwner) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	if !isITunesNS(start.Name.Space) {
+		return d.Skip()
+	}
+	type owner itunesOwner // without the UnmarshalXML method
+	return d.DecodeElement((*owner)(s), &start)
+}
+
+// linkSink collects <link> elements. A text element without a namespace
+// is the RSS link; one in the Atom namespace, or carrying an href as
+// Atom-style links do, is an atom link. encoding/xml on its own matches
+// both against a field tagged "link".
+type linkSink struct {
+	link string
+	atom []Link
+}
+
+// UnmarshalXML implements the xml.Unmarshaler interface.
+func (s *linkSink) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	var l struct {
+		Link
+		Text string `xml:",chardata"`
+	}
+	if err := d.DecodeElement(&l, &start); err != nil {
+		return err
+	}
+	if start.Name.Space == atomNS || l.Href != "" {
+		s.atom = append(s.atom, l.Link)
+		return nil
+	}
+
+	if s.link == "" {
+		s.link = strings.Trim(l.Text, cutset)
+	}
+	return nil
+}
+
+// cutset is trimmed from both ends of text elements.
+const cutset = " \t\n"
+
+// trimChannel trims the text elements of c in trim, except its items.
+func trimChannel(c *RSSChannel, trim TrimFields) {
+	trimTitleDescription(&c.Title, &c.Description, trim)
+	if trim&TrimOther != 0 {
+		trimStrings(&c.Language, &c.Copyright, &c.ManagingEditor, &c.WebMaster,
+			&c.Generator, &c.Docs, &c.Rating)
+		trimCategories(c.Categories)
+	}
+	if c.Image != nil {
+		trimStrings(&c.Image.URL, &c.Image.Link)
+		trimTitleDescription(&c.Image.Title, &c.Image.Description, trim)
+	}
+	if c.TextInput != nil {
+		trimStrings(&c.TextInput.Link)
+		if trim&TrimOther != 0 {
+			trimStrings(&c.TextInput.Name)
+		}
+		trimTitleDescription(&c.TextInput.Title, &c.TextInput.Description, trim)
+	}
+}
+
+// trimItem trims the text elements of it in trim.
+func trimItem(it *RSSItem, trim TrimFields) {
+	trimTitleDescription(&it.Title, &it.Description, trim)
+	if trim&TrimDescription != 0 {
+		trimStrings(&it.ContentEncoded)
+	}
+	if trim&TrimOther != 0 {
+		trimStrings(&it.Author, &it.Comments, &it.GUID.Value)
+		trimCategories(it.Categories)
+		if it.Source != nil {
+			trimStrings(&it.Source.Value)
+		}
+	}
+}
+
+// trimTitleDescription trims title and description as trim says.
+func trimTitleDescription(title, description *string, trim TrimFields) {
+	if trim&TrimTitle != 0 {
+		trimStrings(title)
+	}
+	if trim&TrimDescription != 0 {
+		trimStrings(description)
+	}
+}
+
+// trimCategories trims the values and domains of a.
+func trimCategories(a []RSSCategory) {
+	for i := range a {
+		trimStrings(&a[i].Value, &a[i].Domain)
+	}
+}
+
+// trimStrings trims cutset from each of a.
+func trimStrings(a ...*string) {
+	for _, p := range a {
+		*p = strings.Trim(*p, cutset)
+	}
+}
+
+// rootElement returns the lowercased local name of the root element of
+// b, or "" if b doesn't start like XML or HTML.
+func rootElement(b []byte) string {
+	d := xml.NewDecoder(bytes.NewReader(b))
+	d.Strict = false
+	d.CharsetReader = charsetReader
+	for i := 0; i < 100; i++ {
+		t, err := d.RawToken()
+		if err != nil {
+			return ""
+		}
+		switch t := t.(type) {
+		case xml.StartElement:
+			return strings.ToLower(t.Name.Local)
+		case xml.CharData:
+			if len(bytes.TrimSpace(t)) > 0 {
+				return ""
+			}
+		}
+	}
+	return ""
+}
+
+// documentFormat returns the format of the document b, judging from its
+// content: "rss", "rdf" (RSS 1.0), "atom", "opml", "jsonfeed", "html", or
+// the name of its root element if it is another XML document. It returns
+// "" if b is none of these; filename, if not empty, then decides by its
+// extension.
+func documentFormat(b []byte, filename string) string {
+	switch root := rootElement(b); root {
+	case "":
+	case "feed":
+		return "atom"
+	default:
+		return root
+	}
+
+	if t := bytes.TrimSpace(b); len(t) > 0 && t[0] == '{' && bytes.Contains(t, []byte("jsonfeed.org/version")) {
+		return "jsonfeed"
+	}
+
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".opml":
+		return "opml"
+	case ".json":
+		return "jsonfeed"
+	case ".atom":
+		return "atom"
+	}
+	return ""
+}
+
+// newDecoder returns an xml.Decoder reading from r configured by opts.
+func newDecoder(r io.Reader, opts FeedOptions) *xml.Decoder {
+	d := xml.NewDecoder(r)
+	d.CharsetReader = charsetReader
+	d.Strict = opts.Strict
+	if !opts.Strict {
+		d.AutoClose = xml.HTMLAutoClose
+		d.Entity = xml.HTMLEntity
+	}
+	if opts.MaxFeedBytes <= 0 && opts.MaxDepth <= 0 && opts.MaxTokens <= 0 {
+		return d
+	}
+
+	guarded := xml.NewTokenDecoder(&tokenGuard{
+		d:         d,
+		maxBytes:  opts.MaxFeedBytes,
+		maxDepth:  opts.MaxDepth,
+		maxTokens: opts.MaxTokens,
+	})
+	guarded.Strict = opts.Strict
+	return guarded
+}
+
+// tokenGuard is an xml.TokenReader that passes tokens through from d,
+// failing once the decoded output grows past the configured limits. A
+// limit that isn't positive is not enforced.
+type tokenGuard struct {
+	d *xml.Decoder
+
+	maxBytes int64
+	nbytes   int64
+
+	maxDepth int
+	depth    int
+
+	maxTokens int
+	ntokens   int
+}
+
+func (g *tokenGuard) Token() (xml.Token, error) {
+	t, err := g.d.Token()
+	if t == nil {
+		return t, err
+	}
+
+	g.nbytes += tokenSize(t)
+	if g.maxBytes > 0 && g.nbytes > g.maxBytes {
+		return nil, ErrFeedTooLarge
+	}
+	g.ntokens++
+	if g.maxTokens > 0 && g.ntokens > g.maxTokens {
+		return nil, ErrFeedTooComplex
+	}
+	switch t.(type) {
+	case xml.StartElement:
+		g.depth++
+		if g.maxDepth > 0 && g.depth > g.maxDepth {
+			return nil, ErrFeedTooDeep
+		}
+	case xml.EndElement:
+		g.depth--
+	}
+
+	return t, err
+}
+
+// tokenSize reports the number of decoded bytes carried by t.
+func tokenSize(t xml.Token) int64 {
+	switch t := t.(type) {
+	case xml.StartElement:
+		n := len(t.Name.Local)
+		for _, a := range t.Attr {
+			n += len(a.Name.Local) + len(a.Value)
+		}
+		return int64(n)
+	case xml.CharData:
+		return int64(len(t))
+	case xml.Comment:
+		return int64(len(t))
+	case xml.ProcInst:
+		return int64(len(t.Target) + len(t.Inst))
+	case xml.Directive:
+		return int64(len(t))
+	}
+	return 0
+}
+
+// readAll reads r until EOF, failing with ErrFeedTooLarge once more than
+// max bytes have been read. A max of zero means no limit.
+func readAll(r io.Reader, max int64) ([]byte, error) {
+	if max <= 0 {
+		return ioutil.ReadAll(r)
+	}
+	b, err := ioutil.ReadAll(io.LimitReader(r, max+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(b)) > max {
+		return nil, ErrFeedTooLarge
+	}
+	return b, nil
+}
@@ -0,0 +1,332 @@
+// Copyright 2018 cotox. All rights reserved.
+// Use of this source code is governed by a GPLv3
+// license that can be found in the LICENSE file.
+
+package rssutil
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strings"
+)
+
+// FeedDiff describes how the items of two copies of a feed differ.
+type FeedDiff struct {
+	// Items present only in the newer copy.
+	Added []RSSItem
+
+	// Items present only in the older copy.
+	Removed []RSSItem
+
+	// Items present in both copies whose title, link, description or
+	// publication date changed. The newer version is reported.
+	Changed []RSSItem
+}
+
+// Empty reports whether the two copies have the same items.
+func (d FeedDiff) Empty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0 && len(d.Changed) == 0
+}
+
+// Diff compares the items of rss with those of other, which is usually a
+// newer copy of the same feed. Items are matched by GUID, falling back
+// to link, then to title and description, or by rss.DedupKey if set.
+func (rss *RSS) Diff(other *RSS) (d FeedDiff) {
+	old := make(map[string]*RSSItem)
+	for i := range rss.Channel.Items {
+		old[rss.itemKey(rss.Channel.Items[i])] = &rss.Channel.Items[i]
+	}
+
+	seen := make(map[string]bool)
+	for _, it := range other.Channel.Items {
+		key := rss.itemKey(it)
+		seen[key] = true
+		prev, ok := old[key]
+		switch {
+		case !ok:
+			d.Added = append(d.Added, it)
+		case !sameItem(*prev, it):
+			d.Changed = append(d.Changed, it)
+		}
+	}
+
+	for _, it := range rss.Channel.Items {
+		if !seen[rss.itemKey(it)] {
+			d.Removed = append(d.Removed, it)
+		}
+	}
+
+	return d
+}
+
+// DiffReport returns a printable summary of rss.Diff(other), suitable for
+// changelogs and emails. The counts line is followed by the title of
+// every affected item, prefixed with "+", "-" or "~" for added, removed
+// and changed items:
+//
+//	Added: 1 / Removed: 1 / Changed: 0
+//	+ Venice Film Festival Tries to Quit Sinking
+//	- Star City
+//
+// If the items are the same it returns "No changes".
+func (rss *RSS) DiffReport(other *RSS) string {
+	d := rss.Diff(other)
+	if d.Empty() {
+		return "No changes"
+	}
+
+	var a []string
+	a = append(a, fmt.Sprintf("Added: %d / Removed: %d / Changed: %d",
+		len(d.Added), len(d.Removed), len(d.Changed)))
+	for _, it := range d.Added {
+		a = append(a, "+ "+itemLabel(it))
+	}
+	for _, it := range d.Removed {
+		a = append(a, "- "+itemLabel(it))
+	}
+	for _, it := range d.Changed {
+		a = append(a, "~ "+itemLabel(it))
+	}
+
+	return strings.Join(a, "\n")
+}
+
+// itemKey returns the identity rss matches it by across copies of the
+// feed.
+func (rss *RSS) itemKey(it RSSItem) string {
+	if rss.DedupKey != nil {
+		return rss.DedupKey(it)
+	}
+	return itemKey(it)
+}
+
+// itemKey returns the identity used to match it across copies of a feed.
+func itemKey(it RSSItem) string {
+	if it.GUID.Value != "" {
+		return it.GUID.Value
+	}
+	if it.Link != "" {
+		return it.Link
+	}
+	return it.Title + "\x00" + it.Description
+}
+
+// contentKeyLen is the number of characters of the description of an
+// item ContentKey uses.
+const contentKeyLen = 200
+
+// ContentKey returns an identity of it that survives edits to its title
+// and changes of its GUID, meant to be used as RSS.DedupKey for feeds
+// that republish stories under new GUIDs. It is a hash of the host and
+// path of its link and of the beginning of the text of its description,
+// normalized so that case, markup, spacing, a "www." prefix, the scheme,
+// query and fragment don't matter. Items with neither link nor
+// description are known by their title.
+//
+// Distinct items with the same link and the same beginning, such as
+// episodes sharing a boilerplate description on a single page, are
+// taken to be the same.
+func ContentKey(it RSSItem) string {
+	var host, p string
+	if u, err := url.Parse(strings.TrimSpace(it.Link)); err == nil {
+		host = strings.TrimPrefix(strings.ToLower(u.Host), "www.")
+		p = strings.TrimRight(u.Path, "/")
+	}
+	text := []rune(strings.ToLower(plainText(it.Description)))
+	if len(text) > contentKeyLen {
+		text = text[:contentKeyLen]
+	}
+	if host == "" && p == "" && len(text) == 0 {
+		text = []rune(strings.ToLower(strings.Join(strings.Fields(it.Title), " ")))
+	}
+
+	sum := sha1.Sum([]byte(host + p + "\x00" + string(text)))
+	return hex.EncodeToString(sum[:])
+}
+
+// itemLabel returns a short human-readable name for it.
+func itemLabel(it RSSItem) string {
+	switch {
+	case it.Title != "":
+		return it.Title
+	case it.Link != "":
+		return it.Link
+	}
+	return it.GUID.Value
+}
+
+// sameItem reports whether a and b carry the same content.
+func sameItem(a, b RSSItem) bool {
+	return a.Title == b.Title &&
+		a.Link == b.Link &&
+		a.Description == b.Description &&
+		sameDate(a.PubDate, b.PubDate) &&
+		sameDate(a.UpdatedDate, b.UpdatedDate)
+}
+
+// sameDate reports whether a and b are both unset or the same instant.
+func sameDate(a, b *RFC822) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.Equal(*b)
+}
+
+// FeedsEqual reports whether a and b are the same feed, as tests of code
+// generating feeds see it: their versions and channels are equal, and so
+// are their items whatever their order. Items are paired by GUID, falling
+// back to link, then to title and description, as Diff does by default.
+//
+// Text is compared without regard to insignificant whitespace, that is
+// leading, trailing and repeated whitespace, dates as instants, and an
+// element that isn't set is the same as an empty one. The configuration
+// and source of the feeds don't matter.
+func FeedsEqual(a, b *RSS) bool {
+	if !equalText(a.Version, b.Version) || len(a.Channel.Items) != len(b.Channel.Items) {
+		return false
+	}
+	ca, cb := a.Channel, b.Channel
+	ca.Items, cb.Items = nil, nil
+	if !equalValues(reflect.ValueOf(ca), reflect.ValueOf(cb)) {
+		return false
+	}
+
+	unmatched := make(map[string][]RSSItem)
+	for _, it := range b.Channel.Items {
+		key := itemKey(it)
+		unmatched[key] = append(unmatched[key], it)
+	}
+items:
+	for _, it := range a.Channel.Items {
+		key := itemKey(it)
+		for i, other := range unmatched[key] {
+			if equalValues(reflect.ValueOf(it), reflect.ValueOf(other)) {
+				unmatched[key] = append(unmatched[key][:i], unmatched[key][i+1:]...)
+				continue items
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// rfc822Type is the type of dates, compared as instants by equalValues.
+var rfc822Type = reflect.TypeOf(RFC822{})
+
+// equalValues reports whether a and b, of the same type, are equal as
+// FeedsEqual compares them, looking at exported fields only.
+func equalValues(a, b reflect.Value) bool {
+	switch a.Kind() {
+	case reflect.String:
+		return equalText(a.String(), b.String())
+	case reflect.Ptr:
+		if a.IsNil() && b.IsNil() {
+			return true
+		}
+		return equalValues(elemOrZero(a), elemOrZero(b))
+	case reflect.Slice:
+		if a.Len() != b.Len() {
+			return false
+		}
+		for i := 0; i < a.Len(); i++ {
+			if !equalValues(a.Index(i), b.Index(i)) {
+				return false
+			}
+		}
+		return true
+	case reflect.Struct:
+		if a.Type() == rfc822Type {
+			return a.Interface().(RFC822).Equal(b.Interface().(RFC822))
+		}
+		for i := 0; i < a.NumField(); i++ {
+			if a.Type().Field(i).PkgPath == "" && !equalValues(a.Field(i), b.Field(i)) {
+				return false
+			}
+		}
+		return true
+	}
+	return reflect.DeepEqual(a.Interface(), b.Interface())
+}
+
+// elemOrZero returns what the pointer v points to, or the zero value if
+// v is nil.
+func elemOrZero(v reflect.Value) reflect.Value {
+	if v.IsNil() {
+		return reflect.Zero(v.Type().Elem())
+	}
+	return v.Elem()
+}
+
+// equalText reports whether a and b are the same text but for leading,
+// trailing and repeated whitespace.
+func equalText(a, b string) bool {
+	if a == b {
+		return true
+	}
+	return strings.Join(strings.Fields(a), " ") == strings.Join(strings.Fields(b), " ")
+}
+
+// CheckGUIDStability fetches the feed of rss twice in a row, with client
+// or, if nil, rss.Client, and reports whether its items kept their
+// identity, the GUID or, for items without one, the link Update matches
+// items by. Feeds that make up new GUIDs on every request are a common
+// cause of items being notified again and again.
+//
+// Items of the two copies are paired by link, then by title, and changed
+// lists the identities, in the first copy, of those whose identity
+// differs in the second. Items found in only one copy, as when something
+// is published in between, are ignored. Only feeds read from a URL or a
+// file can be checked; the content of rss itself isn't used.
+func (rss *RSS) CheckGUIDStability(client *http.Client) (stable bool, changed []string, err error) {
+	if client == nil {
+		client = rss.Client
+	}
+	fetch := func() (*RSS, error) {
+		switch rss.sourceKind {
+		case SourceURL:
+			return FetchFeed(context.Background(), rss.source, FetchOptions{Client: client})
+		case SourceFile:
+			return FeedFromFile(rss.source)
+		}
+		return nil, ErrNoReloadableSource
+	}
+
+	first, err := fetch()
+	if err != nil {
+		logErr(err)
+		return false, nil, err
+	}
+	second, err := fetch()
+	if err != nil {
+		logErr(err)
+		return false, nil, err
+	}
+
+	byLink := make(map[string]RSSItem)
+	byTitle := make(map[string]RSSItem)
+	for _, it := range second.Channel.Items {
+		if it.Link != "" {
+			byLink[it.Link] = it
+		}
+		if it.Title != "" {
+			byTitle[it.Title] = it
+		}
+	}
+	for _, it := range first.Channel.Items {
+		other, ok := byLink[it.Link]
+		if !ok || it.Link == "" {
+			if other, ok = byTitle[it.Title]; !ok || it.Title == "" {
+				continue
+			}
+		}
+		if itemKey(it) != itemKey(other) {
+			changed = append(changed, itemKey(it))
+		}
+	}
+	return len(changed) == 0, changed, nil
+}
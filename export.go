@@ -0,0 +1,544 @@
+// Copyright 2018 cotox. All rights reserved.
+// Use of this source code is governed by a GPLv3
+// license that can be found in the LICENSE file.
+
+package rssutil
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// XMLOptions controls how ToXMLWithOptions renders a feed.
+type XMLOptions struct {
+	// PreserveDates writes the channel's pubDate and lastBuildDate
+	// exactly as they are. By default, missing ones are set to the date
+	// of the newest item, or to the current time when no item is dated,
+	// so aggregators can tell how fresh the feed is.
+	PreserveDates bool
+
+	// Prefix and Indent, when either is set, pretty-print the output as
+	// xml.MarshalIndent does: every element on a new line beginning with
+	// Prefix, followed by one copy of Indent per level of nesting. Text,
+	// descriptions included, is written as it is. By default the output
+	// is compact, best for serving.
+	Prefix string
+	Indent string
+}
+
+// JSONOptions controls how ToJSONWithOptions renders a feed.
+//
+// The zero value is not the default; start from DefaultJSONOptions and
+// adjust the fields you care about.
+type JSONOptions struct {
+	// IncludeDescriptions keeps the descriptions and ContentEncoded of
+	// items, usually the bulk of a feed. Leave it unset to list items by
+	// title and link.
+	IncludeDescriptions bool
+
+	// MaxItems, when not zero, keeps only the first MaxItems items.
+	MaxItems int
+
+	// Indent pretty-prints the output.
+	Indent bool
+}
+
+// DefaultJSONOptions is used by ToJSON.
+var DefaultJSONOptions = JSONOptions{
+	IncludeDescriptions: true,
+	Indent:              true,
+}
+
+// ToJSONWithOptions returns rss as JSON rendered according to opts. rss
+// itself is never modified.
+func (rss *RSS) ToJSONWithOptions(opts JSONOptions) ([]byte, error) {
+	ch := rss.Channel
+	if opts.MaxItems > 0 && len(ch.Items) > opts.MaxItems {
+		ch.Items = ch.Items[:opts.MaxItems]
+	}
+	if !opts.IncludeDescriptions {
+		items := make([]RSSItem, len(ch.Items))
+		for i, it := range ch.Items {
+			it.Description, it.ContentEncoded = "", ""
+			items[i] = it
+		}
+		ch.Items = items
+	}
+
+	data := struct {
+		Source  string     `json:"source"`
+		Version string     `json:"version"`
+		Channel RSSChannel `json:"channel"`
+	}{rss.source, rss.Version, ch}
+
+	var b []byte
+	var err error
+	if opts.Indent {
+		b, err = json.MarshalIndent(data, "", "  ")
+	} else {
+		b, err = json.Marshal(data)
+	}
+	if err != nil {
+		logErr(err)
+		return nil, err
+	}
+	return b, nil
+}
+
+// NewFeedFromItems returns an RSS 2.0 feed holding items under a channel
+// with the given title, link and description, built now, ready for ToXML.
+// The items are used as they are, not copied.
+func NewFeedFromItems(title, link, description string, items []RSSItem) *RSS {
+	now := RFC822(time.Now())
+	return &RSS{
+		Version: "2.0",
+		Channel: RSSChannel{
+			Title:         title,
+			Link:          link,
+			Description:   description,
+			LastBuildDate: &now,
+			Items:         items,
+		},
+	}
+}
+
+// AsFeed returns a feed holding it alone, as NewFeedFromItems builds it,
+// under a channel named channelTitle at channelLink. The title defaults
+// to that of it, or else its link, and the link to that of it; the
+// channel is described as carrying the item. it is copied.
+func (it RSSItem) AsFeed(channelTitle, channelLink string) *RSS {
+	if channelTitle == "" {
+		channelTitle = itemLabel(it)
+	}
+	if channelLink == "" {
+		channelLink = it.Link
+	}
+	description := channelTitle
+	if it.Title != "" && it.Title != channelTitle {
+		description = channelTitle + ": " + it.Title
+	}
+	return NewFeedFromItems(channelTitle, channelLink, description, []RSSItem{it})
+}
+
+// rssOutput mirrors RSS for encoding.
+type rssOutput struct {
+	XMLName   xml.Name      `xml:"rss"`
+	Version   string        `xml:"version,attr"`
+	AtomNS    string        `xml:"xmlns:atom,attr,omitempty"`
+	ContentNS string        `xml:"xmlns:content,attr,omitempty"`
+	MediaNS   string        `xml:"xmlns:media,attr,omitempty"`
+	ITunesNS  string        `xml:"xmlns:itunes,attr,omitempty"`
+	Channel   channelOutput `xml:"channel"`
+}
+
+// channelOutput mirrors RSSChannel for encoding, writing atom links next
+// to the RSS link, <skipDays> as day names, and NewLocation and ITunes as
+// iTunes elements.
+type channelOutput struct {
+	RSSChannel
+	Link     linkOutput `xml:"link"`
+	SkipDays []string   `xml:"skipDays>day,omitempty"`
+
+	ITunesNewURL     string                 `xml:"itunes:new-feed-url,omitempty"`
+	ITunesAuthor     string                 `xml:"itunes:author,omitempty"`
+	ITunesSubtitle   string                 `xml:"itunes:subtitle,omitempty"`
+	ITunesSummary    string                 `xml:"itunes:summary,omitempty"`
+	ITunesImage      *itunesImageOutput     `xml:"itunes:image,omitempty"`
+	ITunesCategories []itunesCategoryOutput `xml:"itunes:category,omitempty"`
+	ITunesExplicit   string                 `xml:"itunes:explicit,omitempty"`
+	ITunesOwner      *itunesOwnerOutput     `xml:"itunes:owner,omitempty"`
+
+	Items []itemOutput `xml:"item,omitempty"`
+}
+
+// itemOutput mirrors RSSItem for encoding, writing alternate links next
+// to the RSS link, ContentEncoded as <content:encoded>, UpdatedDate as
+// <atom:updated>, the media objects besides the enclosure as
+// <media:content> and ITunes as iTunes elements.
+type itemOutput struct {
+	RSSItem
+	Link    linkOutput           `xml:"link"`
+	Encoded string               `xml:"content:encoded,omitempty"`
+	Updated string               `xml:"atom:updated,omitempty"`
+	Media   []mediaContentOutput `xml:"media:content,omitempty"`
+
+	ITunesAuthor      string             `xml:"itunes:author,omitempty"`
+	ITunesSubtitle    string             `xml:"itunes:subtitle,omitempty"`
+	ITunesSummary     string             `xml:"itunes:summary,omitempty"`
+	ITunesImage       *itunesImageOutput `xml:"itunes:image,omitempty"`
+	ITunesDuration    string             `xml:"itunes:duration,omitempty"`
+	ITunesEpisode     string             `xml:"itunes:episode,omitempty"`
+	ITunesSeason      string             `xml:"itunes:season,omitempty"`
+	ITunesEpisodeType string             `xml:"itunes:episodeType,omitempty"`
+	ITunesExplicit    string             `xml:"itunes:explicit,omitempty"`
+}
+
+// mediaContentOutput is a <media:content> for encoding.
+type mediaContentOutput struct {
+	URL      string `xml:"url,attr"`
+	FileSize int64  `xml:"fileSize,attr,omitempty"`
+	Type     string `xml:"type,attr,omitempty"`
+	Bitrate  int    `xml:"bitrate,attr,omitempty"`
+}
+
+// itunesImageOutput is an <itunes:image> for encoding.
+type itunesImageOutput struct {
+	Href string `xml:"href,attr"`
+}
+
+// itunesCategoryOutput is an <itunes:category> for encoding, with its
+// subcategories.
+type itunesCategoryOutput struct {
+	Text string                 `xml:"text,attr"`
+	Sub  []itunesCategoryOutput `xml:"itunes:category,omitempty"`
+}
+
+// itunesOwnerOutput is an <itunes:owner> for encoding.
+type itunesOwnerOutput struct {
+	Name  string `xml:"itunes:name,omitempty"`
+	Email string `xml:"itunes:email,omitempty"`
+}
+
+// setITunes fills in the iTunes elements of c from NewLocation and
+// ITunes, reporting whether there are any.
+func (c *channelOutput) setITunes() bool {
+	c.ITunesNewURL = c.NewLocation
+	ext := c.ITunes
+	if ext == nil {
+		return c.ITunesNewURL != ""
+	}
+	c.ITunesAuthor = ext.Author
+	c.ITunesSubtitle = ext.Subtitle
+	c.ITunesSummary = ext.Summary
+	if ext.Image != "" {
+		c.ITunesImage = &itunesImageOutput{ext.Image}
+	}
+	c.ITunesCategories = itunesCategories(ext.Categories)
+	c.ITunesExplicit = ext.Explicit
+	if ext.OwnerName != "" || ext.OwnerEmail != "" {
+		c.ITunesOwner = &itunesOwnerOutput{ext.OwnerName, ext.OwnerEmail}
+	}
+	return true
+}
+
+// itunesCategories nests the categories of ITunesChannel.Categories back,
+// a "Parent/Child" one going under Parent.
+func itunesCategories(names []string) []itunesCategoryOutput {
+	var out []itunesCategoryOutput
+	index := make(map[string]int)
+	for _, name := range names {
+		parent, sub := name, ""
+		if i := strings.IndexByte(name, '/'); i >= 0 {
+			parent, sub = name[:i], name[i+1:]
+		}
+		i, ok := index[parent]
+		if !ok {
+			i = len(out)
+			index[parent] = i
+			out = append(out, itunesCategoryOutput{Text: parent})
+		}
+		if sub != "" {
+			out[i].Sub = append(out[i].Sub, itunesCategoryOutput{Text: sub})
+		}
+	}
+	return out
+}
+
+// setMedia fills in the <media:content> of out: the media objects of its
+// item, but for the first when it is the enclosure, as decoding makes it.
+func (out *itemOutput) setMedia() {
+	media := out.RSSItem.Media
+	if e := out.Enclosure; e != nil && len(media) > 0 && media[0] == *e {
+		media = media[1:]
+	}
+	for _, m := range media {
+		out.Media = append(out.Media, mediaContentOutput{m.URL, m.Length, m.Type, m.Bitrate})
+	}
+}
+
+// setITunes fills in the iTunes elements of out from ITunes, reporting
+// whether there are any.
+func (out *itemOutput) setITunes() bool {
+	ext := out.ITunes
+	if ext == nil {
+		return false
+	}
+	out.ITunesAuthor = ext.Author
+	out.ITunesSubtitle = ext.Subtitle
+	out.ITunesSummary = ext.Summary
+	if ext.Image != "" {
+		out.ITunesImage = &itunesImageOutput{ext.Image}
+	}
+	if ext.Duration > 0 {
+		out.ITunesDuration = strconv.FormatFloat(ext.Duration.Seconds(), 'f', -1, 64)
+	}
+	out.ITunesEpisode = ext.Episode
+	out.ITunesSeason = ext.Season
+	out.ITunesEpisodeType = ext.EpisodeType
+	out.ITunesExplicit = ext.Explicit
+	return true
+}
+
+// linkOutput writes an RSS <link> followed by <atom:link> elements. The
+// atom prefix must be declared by the document. An empty link is left
+// out if optional is set.
+type linkOutput struct {
+	link     string
+	atom     []Link
+	optional bool
+}
+
+// MarshalXML implements the xml.Marshaler interface.
+func (l linkOutput) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	if l.link != "" || !l.optional {
+		if err := e.EncodeElement(l.link, start); err != nil {
+			return err
+		}
+	}
+	for _, a := range l.atom {
+		err := e.EncodeElement(a, xml.StartElement{Name: xml.Name{Local: "atom:link"}})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ToXML returns rss as an RSS 2.0 document, XML declaration included.
+// Dates are written in the RFC 822 form, see RFC822.MarshalXML. Text is
+// escaped rather than wrapped in CDATA sections, so descriptions holding
+// "]]>" re-parse as they were; characters XML can't carry, such as most
+// control characters, are written as U+FFFD.
+func (rss *RSS) ToXML() ([]byte, error) {
+	return rss.ToXMLWithOptions(XMLOptions{})
+}
+
+// ToXMLIndent is like ToXML but pretty-prints the document, for reading
+// it; see XMLOptions.Prefix and Indent.
+func (rss *RSS) ToXMLIndent(prefix, indent string) ([]byte, error) {
+	return rss.ToXMLWithOptions(XMLOptions{Prefix: prefix, Indent: indent})
+}
+
+// ToXMLWithOptions returns rss as an RSS 2.0 document rendered according
+// to opts. rss itself is never modified.
+func (rss *RSS) ToXMLWithOptions(opts XMLOptions) ([]byte, error) {
+	doc := rssOutput{Version: "2.0"}
+	doc.Channel.RSSChannel = rss.Channel
+	doc.Channel.Link = linkOutput{link: rss.Channel.Link, atom: rss.Channel.AtomLinks}
+	if len(rss.Channel.AtomLinks) > 0 {
+		doc.AtomNS = atomNS
+	}
+	for _, day := range rss.Channel.SkipDays {
+		doc.Channel.SkipDays = append(doc.Channel.SkipDays, day.String())
+	}
+	if !opts.PreserveDates {
+		stampDates(&doc.Channel.RSSChannel)
+	}
+	if doc.Channel.setITunes() {
+		doc.ITunesNS = itunesNS
+	}
+	for _, it := range rss.Channel.Items {
+		out := itemOutput{
+			RSSItem: it,
+			Link:    linkOutput{link: it.Link, atom: it.AltLinks, optional: true},
+			Encoded: it.ContentEncoded,
+		}
+		if out.Encoded != "" {
+			doc.ContentNS = contentNS
+		}
+		if out.setMedia(); out.Media != nil {
+			doc.MediaNS = mediaNS
+		}
+		if out.setITunes() {
+			doc.ITunesNS = itunesNS
+		}
+		if hasDate(it.UpdatedDate) {
+			out.Updated = it.UpdatedDate.String()
+		}
+		doc.Channel.Items = append(doc.Channel.Items, out)
+		if len(it.AltLinks) > 0 || out.Updated != "" {
+			doc.AtomNS = atomNS
+		}
+	}
+
+	var b []byte
+	var err error
+	if opts.Prefix != "" || opts.Indent != "" {
+		b, err = xml.MarshalIndent(doc, opts.Prefix, opts.Indent)
+	} else {
+		b, err = xml.Marshal(doc)
+	}
+	if err != nil {
+		logErr(err)
+		return nil, err
+	}
+	return append([]byte(xml.Header), b...), nil
+}
+
+// RoundTrip encodes rss as ToXML does, dates preserved, and decodes the
+// result with Feed, for tests checking that a generated feed reads back
+// as it was meant to. Media, ITunes and NewLocation survive, written as
+// <media:content>, iTunes elements and <itunes:new-feed-url>, but
+// elements of other vocabularies that RSS, RSSChannel and RSSItem have no
+// field for are dropped when rss is read, there being no catch-all for
+// them, and the source, validators and configuration of rss aren't part
+// of the document. Media is read back with Enclosure first, as decoding
+// gives it.
+func RoundTrip(rss *RSS) (*RSS, error) {
+	b, err := rss.ToXMLWithOptions(XMLOptions{PreserveDates: true})
+	if err != nil {
+		return nil, err
+	}
+	return Feed(b)
+}
+
+// stampDates fills in the pubDate and lastBuildDate of c when missing.
+func stampDates(c *RSSChannel) {
+	if hasDate(c.PubDate) && hasDate(c.LastBuildDate) {
+		return
+	}
+
+	var latest *RFC822
+	for i := range c.Items {
+		if newer(c.Items[i].PubDate, latest) {
+			latest = c.Items[i].PubDate
+		}
+	}
+	if latest == nil {
+		now := RFC822(time.Now())
+		latest = &now
+	}
+
+	if !hasDate(c.PubDate) {
+		c.PubDate = latest
+	}
+	if !hasDate(c.LastBuildDate) {
+		c.LastBuildDate = latest
+	}
+}
+
+// ToMap returns it as a map for templates and generic pipelines, under
+// the keys "title", "link", "description", "author", "guid" and
+// "comments", strings, "date", its EffectiveDate as a time.Time, and
+// "categories", their values as a []string. An item with an enclosure
+// also has an "enclosure" key, holding a map of its "url", "type" and
+// "length". Missing elements give zero values, never missing keys.
+func (it RSSItem) ToMap() map[string]interface{} {
+	m := map[string]interface{}{
+		"title":       it.Title,
+		"link":        it.Link,
+		"description": it.Description,
+		"author":      it.Author,
+		"guid":        it.GUID.Value,
+		"comments":    it.Comments,
+		"date":        it.EffectiveDate(),
+		"categories":  categoryValues(it.Categories),
+	}
+	if it.Enclosure != nil {
+		m["enclosure"] = map[string]interface{}{
+			"url":    it.Enclosure.URL,
+			"type":   it.Enclosure.Type,
+			"length": it.Enclosure.Length,
+		}
+	}
+	return m
+}
+
+// ToMap returns the header of c, everything but its items, as a map in
+// the manner of RSSItem.ToMap: "title", "link", "description",
+// "language", "copyright" and "image", the URL of its image, are strings,
+// "date" and "lastBuildDate" are time.Time values and "categories" is a
+// []string.
+func (c RSSChannel) ToMap() map[string]interface{} {
+	m := map[string]interface{}{
+		"title":         c.Title,
+		"link":          c.Link,
+		"description":   c.Description,
+		"language":      c.Language,
+		"copyright":     c.Copyright,
+		"image":         "",
+		"date":          time.Time{},
+		"lastBuildDate": time.Time{},
+		"categories":    categoryValues(c.Categories),
+	}
+	if c.Image != nil {
+		m["image"] = c.Image.URL
+	}
+	if hasDate(c.PubDate) {
+		m["date"] = time.Time(*c.PubDate)
+	}
+	if hasDate(c.LastBuildDate) {
+		m["lastBuildDate"] = time.Time(*c.LastBuildDate)
+	}
+	return m
+}
+
+// categoryValues returns the values of a.
+func categoryValues(a []RSSCategory) []string {
+	values := make([]string, len(a))
+	for i, ca := range a {
+		values[i] = ca.Value
+	}
+	return values
+}
+
+// digestTitleLen is the length in characters titles are cut to by Digest.
+const digestTitleLen = 80
+
+// Digest returns the n newest items of rss, or all of them if n is not
+// positive, as a plain text list under the channel title:
+//
+//	Liftoff News
+//	- [2003-06-03] Star City — http://liftoff.msfc.nasa.gov/news/2003/news-starcity.asp
+//	- [2003-05-30] Sky watchers in Europe, Asia, and parts of Alaska and Canada will experience a…
+//
+// Items are ordered by EffectiveDate, undated ones last. Titles are
+// stripped of markup and cut to a reasonable length; an item without a
+// title is listed by the beginning of its description. The date and link
+// are left out when the item doesn't have them.
+func (rss *RSS) Digest(n int) string {
+	items := make([]RSSItem, len(rss.Channel.Items))
+	copy(items, rss.Channel.Items)
+	sort.SliceStable(items, func(i, j int) bool {
+		return items[i].EffectiveDate().After(items[j].EffectiveDate())
+	})
+	if n > 0 && len(items) > n {
+		items = items[:n]
+	}
+
+	a := []string{plainText(rss.Channel.Title)}
+	for _, it := range items {
+		line := "- "
+		if d := it.EffectiveDate(); !d.IsZero() {
+			line += "[" + d.Format("2006-01-02") + "] "
+		}
+		title := plainText(it.Title)
+		if title == "" {
+			title = plainText(it.Description)
+		}
+		line += truncateText(title, digestTitleLen)
+		if it.Link != "" {
+			line += " — " + it.Link
+		}
+		a = append(a, line)
+	}
+	return strings.Join(a, "\n")
+}
+
+// truncateText cuts s to n characters, ending it with an ellipsis if it
+// was longer. It cuts at a space when there is one close enough.
+func truncateText(s string, n int) string {
+	r := []rune(s)
+	if len(r) <= n {
+		return s
+	}
+	r = r[:n-1]
+	if i := strings.LastIndex(string(r), " "); i > len(string(r))*3/4 {
+		return strings.TrimRight(string(r)[:i], " ,.;:") + "…"
+	}
+	return string(r) + "…"
+}
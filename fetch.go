@@ -0,0 +1,360 @@
+// Copyright 2018 cotox. All rights reserved.
+// Use of this source code is governed by a GPLv3
+// license that can be found in the LICENSE file.
+
+package rssutil
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultFetchTimeout bounds a FetchFeed call made without a Client.
+const DefaultFetchTimeout = 30 * time.Second
+
+// ErrNotModified is returned by FetchFeed when the server answers a
+// conditional request with 304 Not Modified.
+var ErrNotModified = errors.New("feed not modified")
+
+// FetchOptions configures FetchFeed.
+type FetchOptions struct {
+	// Client sends the request. If nil, a client with a timeout of
+	// DefaultFetchTimeout is used.
+	Client *http.Client
+
+	// UserAgent, if not empty, is sent as the User-Agent header.
+	UserAgent string
+
+	// MaxBytes limits the size of the decompressed response body. Zero
+	// means DefaultMaxFeedBytes.
+	MaxBytes int64
+
+	// ETag and LastModified are the validators of a previous fetch, see
+	// RSS.ETag and RSS.LastModified. When set they are sent as
+	// If-None-Match and If-Modified-Since, and an unchanged feed yields
+	// ErrNotModified.
+	ETag         string
+	LastModified string
+
+	// ProxyURL, if not empty, is the proxy the request goes through, such
+	// as "http://proxy:8080" or "socks5://proxy:1080", whatever the proxy
+	// of Client. The request then uses a transport of its own, cloned from
+	// that of Client, whose connections aren't reused by other calls.
+	ProxyURL string
+}
+
+var defaultFetchClient = &http.Client{Timeout: DefaultFetchTimeout}
+
+// proxyClient returns a copy of client sending its requests through the
+// proxy at proxyURL. The transport of client must be an *http.Transport,
+// or nil for http.DefaultTransport.
+func proxyClient(client *http.Client, proxyURL string) (*http.Client, error) {
+	u, err := url.Parse(proxyURL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme == "" || u.Host == "" {
+		return nil, fmt.Errorf("malformed proxy URL %q", proxyURL)
+	}
+	rt := client.Transport
+	if rt == nil {
+		rt = http.DefaultTransport
+	}
+	t, ok := rt.(*http.Transport)
+	if !ok {
+		return nil, fmt.Errorf("can't set a proxy on a %T transport", rt)
+	}
+	t = t.Clone()
+	t.Proxy = http.ProxyURL(u)
+	c := *client
+	c.Transport = t
+	return &c, nil
+}
+
+// FetchFeed fetches and decodes the feed at url. It sends a conditional
+// request when opts carries validators, accepts gzip and deflate
+// compressed responses (and brotli ones when built with the brotli tag),
+// honors a charset given by the Content-Type header when the document
+// doesn't declare its own, and limits the body to opts.MaxBytes.
+//
+// Atom feeds are decoded as AtomFeed does. Other documents, such as HTML
+// pages, OPML lists or JSON Feeds, fail with a *FormatError, unless a
+// registered Parser reads them.
+func FetchFeed(ctx context.Context, url string, opts FetchOptions) (*RSS, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		logErr(err)
+		return nil, err
+	}
+	req.Header.Set("Accept-Encoding", acceptEncoding)
+	if opts.UserAgent != "" {
+		req.Header.Set("User-Agent", opts.UserAgent)
+	}
+	if opts.ETag != "" {
+		req.Header.Set("If-None-Match", opts.ETag)
+	}
+	if opts.LastModified != "" {
+		req.Header.Set("If-Modified-Since", opts.LastModified)
+	}
+
+	client := opts.Client
+	if client == nil {
+		client = defaultFetchClient
+	}
+	if opts.ProxyURL != "" {
+		if client, err = proxyClient(client, opts.ProxyURL); err != nil {
+			logErr(err)
+			return nil, err
+		}
+		defer client.CloseIdleConnections()
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		logErr(err)
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusNotModified:
+		return nil, ErrNotModified
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusServiceUnavailable && resp.Header.Get("Retry-After") != "":
+		retryAfter, _ := parseRetryAfter(resp.Header.Get("Retry-After"))
+		err := &RateLimitError{URL: url, StatusCode: resp.StatusCode, Status: resp.Status, RetryAfter: retryAfter}
+		logErr(err)
+		return nil, err
+	case resp.StatusCode < 200 || resp.StatusCode > 299:
+		err := &statusError{url: url, code: resp.StatusCode, status: resp.Status}
+		logErr(err)
+		return nil, err
+	}
+
+	body, err := decodeBody(resp)
+	if err != nil {
+		logErr(err)
+		return nil, err
+	}
+	max := opts.MaxBytes
+	if max == 0 {
+		max = DefaultMaxFeedBytes
+	}
+	b, err := readAll(body, max)
+	if err != nil {
+		logErr(err)
+		return nil, err
+	}
+
+	if cs := contentCharset(resp.Header.Get("Content-Type")); cs != "" && prologEncoding(b) == "" {
+		r, err := charsetReader(cs, bytes.NewReader(b))
+		if err != nil {
+			logErr(err)
+			return nil, err
+		}
+		if b, err = ioutil.ReadAll(r); err != nil {
+			logErr(err)
+			return nil, err
+		}
+	}
+
+	// The Content-Type of feeds is often wrong, text/html included, so
+	// only the body tells what it is.
+	feedOpts := DefaultFeedOptions
+	feedOpts.MaxFeedBytes = max
+	var rss *RSS
+	switch format := documentFormat(b, ""); {
+	case format == "rss" || format == "rdf" || parserFor(b) != nil:
+		rss, err = FeedWithOptions(b, feedOpts)
+	case format == "atom":
+		rss, err = atomFeedWithOptions(b, feedOpts)
+	default:
+		err = &FormatError{Filename: url, Format: format}
+	}
+	if err != nil {
+		logErr(err)
+		return nil, err
+	}
+
+	rss.source = url
+	rss.sourceKind = SourceURL
+	rss.status = resp.StatusCode
+	rss.etag = resp.Header.Get("ETag")
+	rss.lastModified = resp.Header.Get("Last-Modified")
+	rss.movedTo = permanentRedirect(resp)
+	rss.maxAge, rss.hasMaxAge = freshnessLifetime(resp.Header)
+
+	return rss, nil
+}
+
+// statusError is returned by FetchFeed when the server answers with an
+// unexpected status.
+type statusError struct {
+	url    string
+	code   int
+	status string
+}
+
+func (e *statusError) Error() string { return "fetch " + e.url + ": " + e.status }
+
+// RateLimitError is returned by FetchFeed when the server throttles the
+// client: it answers 429 Too Many Requests, or 503 Service Unavailable
+// with a Retry-After header. RetryAfter is how long the server asks to
+// wait before the next request, zero if it doesn't say; Serve waits that
+// long instead of stopping.
+type RateLimitError struct {
+	URL        string
+	StatusCode int
+	Status     string
+	RetryAfter time.Duration
+}
+
+func (e *RateLimitError) Error() string {
+	if e.RetryAfter > 0 {
+		return "fetch " + e.URL + ": " + e.Status + ", retry after " + e.RetryAfter.String()
+	}
+	return "fetch " + e.URL + ": " + e.Status
+}
+
+// parseRetryAfter returns the delay a Retry-After header value v asks
+// for, given in seconds or as an HTTP date. It reports false if v is
+// neither; a date in the past is no delay.
+func parseRetryAfter(v string) (time.Duration, bool) {
+	v = strings.TrimSpace(v)
+	if secs, err := strconv.ParseInt(v, 10, 64); err == nil {
+		if secs < 0 {
+			return 0, false
+		}
+		return time.Duration(secs) * time.Second, true
+	}
+	t, err := http.ParseTime(v)
+	if err != nil {
+		return 0, false
+	}
+	if d := time.Until(t); d > 0 {
+		return d.Round(time.Second), true
+	}
+	return 0, true
+}
+
+// permanentRedirect returns the URL resp was finally fetched from if it
+// was only reached through permanent redirects, or "".
+func permanentRedirect(resp *http.Response) string {
+	req := resp.Request
+	if req == nil || req.Response == nil {
+		return ""
+	}
+	for r := req; r.Response != nil; r = r.Response.Request {
+		if c := r.Response.StatusCode; c != http.StatusMovedPermanently && c != http.StatusPermanentRedirect {
+			return ""
+		}
+	}
+	return req.URL.String()
+}
+
+// freshnessLifetime returns how long a response with header h stays
+// fresh, from its Cache-Control max-age, or else from its Expires and
+// Date, as RFC 7234 computes it. It reports false if h doesn't say.
+func freshnessLifetime(h http.Header) (time.Duration, bool) {
+	for _, v := range h["Cache-Control"] {
+		for _, d := range strings.Split(v, ",") {
+			d = strings.ToLower(strings.TrimSpace(d))
+			if !strings.HasPrefix(d, "max-age=") {
+				continue
+			}
+			secs, err := strconv.ParseInt(strings.Trim(d[len("max-age="):], `"`), 10, 64)
+			if err != nil || secs < 0 {
+				return 0, true
+			}
+			return time.Duration(secs) * time.Second, true
+		}
+	}
+
+	v := h.Get("Expires")
+	if v == "" {
+		return 0, false
+	}
+	expires, err := http.ParseTime(v)
+	if err != nil {
+		// An invalid date, such as "0", means already expired.
+		return 0, true
+	}
+	date, err := http.ParseTime(h.Get("Date"))
+	if err != nil {
+		date = time.Now()
+	}
+	if age := expires.Sub(date); age > 0 {
+		return age, true
+	}
+	return 0, true
+}
+
+// ETag returns the ETag the server sent with the feed, if any.
+func (rss *RSS) ETag() string { return rss.etag }
+
+// LastModified returns the Last-Modified date the server sent with the
+// feed, if any.
+func (rss *RSS) LastModified() string { return rss.lastModified }
+
+// decodeBody returns the body of resp with its content encoding removed.
+func decodeBody(resp *http.Response) (io.Reader, error) {
+	coding := strings.ToLower(strings.TrimSpace(resp.Header.Get("Content-Encoding")))
+	if coding == "" || coding == "identity" {
+		return resp.Body, nil
+	}
+	if f, ok := contentDecoders[coding]; ok {
+		return f(resp.Body)
+	}
+	return nil, fmt.Errorf("unsupported content encoding %q", resp.Header.Get("Content-Encoding"))
+}
+
+// contentDecoders maps the content codings FetchFeed understands to
+// functions undoing them. Brotli ("br") is added when the package is
+// built with the brotli tag.
+var contentDecoders = map[string]func(io.Reader) (io.Reader, error){
+	"gzip":    newGzipReader,
+	"x-gzip":  newGzipReader,
+	"deflate": newDeflateReader,
+}
+
+// acceptEncoding is the Accept-Encoding header sent by FetchFeed, listing
+// the codings of contentDecoders.
+var acceptEncoding = "gzip, deflate"
+
+func newGzipReader(r io.Reader) (io.Reader, error) { return gzip.NewReader(r) }
+
+// newDeflateReader undoes the "deflate" coding, which is meant to be
+// zlib-wrapped but is sent as a raw deflate stream by some servers.
+func newDeflateReader(r io.Reader) (io.Reader, error) {
+	br := bufio.NewReader(r)
+	h, err := br.Peek(2)
+	if err != nil {
+		return nil, err
+	}
+	if h[0]&0x0f == 8 && (uint(h[0])<<8|uint(h[1]))%31 == 0 {
+		return zlib.NewReader(br)
+	}
+	return flate.NewReader(br), nil
+}
+
+// contentCharset returns the charset parameter of a Content-Type header,
+// or "" if there is none.
+func contentCharset(contentType string) string {
+	_, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return ""
+	}
+	return params["charset"]
+}
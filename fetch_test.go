@@ -0,0 +1,825 @@
+// Copyright 2018 cotox. All rights reserved.
+// Use of this source code is governed by a GPLv3
+// license that can be found in the LICENSE file.
+
+package rssutil
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestFetchFeed(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("User-Agent") != "rssutil-test" {
+			t.Error("User-Agent not sent")
+		}
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", `"v1"`)
+		w.Header().Set("Last-Modified", "Fri, 11 May 2018 08:45:56 GMT")
+		w.Write([]byte(rss20Text))
+	}))
+	defer srv.Close()
+
+	opts := FetchOptions{UserAgent: "rssutil-test"}
+	rss, err := FetchFeed(context.Background(), srv.URL, opts)
+	if err != nil {
+		t.Fatal("fetch failed:", err)
+	}
+	if rss.Channel.Title != "最新更新 – Solidot" {
+		t.Error("rss.Channel.Title != \"最新更新 – Solidot\"")
+	}
+	if rss.ETag() != `"v1"` || rss.LastModified() != "Fri, 11 May 2018 08:45:56 GMT" {
+		t.Error("validators not recorded")
+	}
+	if rss.SourceKind() != SourceURL {
+		t.Error("SourceKind() != SourceURL,", rss.SourceKind())
+	}
+
+	opts.ETag = rss.ETag()
+	if _, err := FetchFeed(context.Background(), srv.URL, opts); err != ErrNotModified {
+		t.Error("err != ErrNotModified,", err)
+	}
+
+	opts = FetchOptions{UserAgent: "rssutil-test", MaxBytes: 100}
+	if _, err := FetchFeed(context.Background(), srv.URL, opts); err != ErrFeedTooLarge {
+		t.Error("err != ErrFeedTooLarge,", err)
+	}
+}
+
+func TestFetchFeedProxy(t *testing.T) {
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Host != "feeds.example.com" {
+			t.Error("proxy got a request for", r.URL)
+		}
+		w.Write([]byte(rss20Text))
+	}))
+	defer proxy.Close()
+
+	opts := FetchOptions{ProxyURL: proxy.URL}
+	rss, err := FetchFeed(context.Background(), "http://feeds.example.com/rss", opts)
+	if err != nil {
+		t.Fatal("fetch failed:", err)
+	}
+	if rss.Channel.Title != "最新更新 – Solidot" {
+		t.Error("rss.Channel.Title != \"最新更新 – Solidot\"")
+	}
+
+	opts.Client = &http.Client{Transport: roundTripperFunc(http.DefaultTransport.RoundTrip)}
+	if _, err := FetchFeed(context.Background(), "http://feeds.example.com/rss", opts); err == nil {
+		t.Error("ProxyURL with a custom transport didn't fail")
+	}
+	opts = FetchOptions{ProxyURL: "proxy:8080"}
+	if _, err := FetchFeed(context.Background(), "http://feeds.example.com/rss", opts); err == nil {
+		t.Error("a malformed ProxyURL didn't fail")
+	}
+}
+
+// roundTripperFunc is an http.RoundTripper that isn't an *http.Transport.
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(r *http.Request) (*http.Response, error) { return f(r) }
+
+func TestFetchFeedEncoding(t *testing.T) {
+	latin1 := []byte("<rss version=\"2.0\"><channel><title>Caf\xe9 \x93Cr\xe8me\x94</title></channel></rss>")
+	var gz bytes.Buffer
+	w := gzip.NewWriter(&gz)
+	w.Write(latin1)
+	w.Close()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Compressed although the client didn't ask for it.
+		w.Header().Set("Content-Type", "application/rss+xml; charset=ISO-8859-1")
+		w.Header().Set("Content-Encoding", "gzip")
+		w.Write(gz.Bytes())
+	}))
+	defer srv.Close()
+
+	client := &http.Client{Transport: &http.Transport{DisableCompression: true}}
+	rss, err := FetchFeed(context.Background(), srv.URL, FetchOptions{Client: client})
+	if err != nil {
+		t.Fatal("fetch failed:", err)
+	}
+	if rss.Channel.Title != "Café “Crème”" {
+		t.Errorf("rss.Channel.Title != \"Café “Crème”\", %#v", rss.Channel.Title)
+	}
+}
+
+func TestFetchFeedDeflate(t *testing.T) {
+	text := []byte(`<rss version="2.0"><channel><title>Deflated</title></channel></rss>`)
+	var zl, raw bytes.Buffer
+	zw := zlib.NewWriter(&zl)
+	zw.Write(text)
+	zw.Close()
+	fw, _ := flate.NewWriter(&raw, flate.DefaultCompression)
+	fw.Write(text)
+	fw.Close()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.Contains(r.Header.Get("Accept-Encoding"), "deflate") {
+			t.Errorf("Accept-Encoding doesn't list deflate, %#v", r.Header.Get("Accept-Encoding"))
+		}
+		switch r.URL.Path {
+		case "/zlib":
+			w.Header().Set("Content-Encoding", "deflate")
+			w.Write(zl.Bytes())
+		case "/raw":
+			w.Header().Set("Content-Encoding", "deflate")
+			w.Write(raw.Bytes())
+		default:
+			w.Header().Set("Content-Encoding", "compress")
+			w.Write(text)
+		}
+	}))
+	defer srv.Close()
+
+	for _, path := range []string{"/zlib", "/raw"} {
+		rss, err := FetchFeed(context.Background(), srv.URL+path, FetchOptions{})
+		if err != nil {
+			t.Fatalf("fetch %s failed: %v", path, err)
+		}
+		if rss.Channel.Title != "Deflated" {
+			t.Errorf("%s: rss.Channel.Title != \"Deflated\", %#v", path, rss.Channel.Title)
+		}
+	}
+	if _, err := FetchFeed(context.Background(), srv.URL+"/compress", FetchOptions{}); err == nil {
+		t.Error("fetch with an unsupported content encoding succeeded")
+	}
+}
+
+// cachingTransport answers like an HTTP cache holding a fresh copy of
+// one feed: revalidations get 304 Not Modified.
+type cachingTransport struct {
+	text     string
+	requests int
+}
+
+func (c *cachingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	c.requests++
+	resp := &http.Response{
+		Request:    req,
+		StatusCode: http.StatusOK,
+		Status:     "200 OK",
+		Header:     http.Header{"Etag": {`"v1"`}},
+		Body:       ioutil.NopCloser(strings.NewReader(c.text)),
+	}
+	if req.Method != http.MethodGet || req.Body != nil {
+		resp.StatusCode, resp.Status = http.StatusBadRequest, "400 Bad Request"
+	} else if req.Header.Get("If-None-Match") == `"v1"` {
+		resp.StatusCode, resp.Status = http.StatusNotModified, "304 Not Modified"
+		resp.Body = ioutil.NopCloser(strings.NewReader(""))
+	}
+	return resp, nil
+}
+
+func TestFeedFromURLWithClient(t *testing.T) {
+	transport := &cachingTransport{text: rss20Text}
+	rss, err := FeedFromURLWithClient("http://example.com/feed", &http.Client{Transport: transport})
+	if err != nil {
+		t.Fatal("fetch failed:", err)
+	}
+	if len(rss.Channel.Items) != 1 {
+		t.Fatal("len(rss.Channel.Items) != 1")
+	}
+
+	var metrics []FeedMetrics
+	rss.OnMetrics = func(m FeedMetrics) { metrics = append(metrics, m) }
+	newItems, err := rss.Update()
+	if err != nil {
+		t.Fatal("update failed:", err)
+	}
+	if len(newItems) != 0 {
+		t.Errorf("Update() after 304 != [], %v", newItems)
+	}
+	if len(metrics) != 1 || metrics[0].Status != http.StatusNotModified || metrics[0].Bytes != 0 {
+		t.Errorf("metrics != [{Status: 304}], %+v", metrics)
+	}
+	if len(rss.Channel.Items) != 1 {
+		t.Error("Update() after 304 dropped the items")
+	}
+	if transport.requests != 2 {
+		t.Errorf("requests != 2, %d", transport.requests)
+	}
+}
+
+func TestFeedFromURLWithClientTimeout(t *testing.T) {
+	release := make(chan bool)
+	requests := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if requests++; requests > 1 {
+			<-release
+		}
+		w.Write([]byte(rss20Text))
+	}))
+	defer srv.Close()
+	defer close(release) // before Close, which waits for the handler
+
+	client := &http.Client{Timeout: 50 * time.Millisecond}
+	rss, err := FeedFromURLWithClient(srv.URL, client)
+	if err != nil {
+		t.Fatal("fetch failed:", err)
+	}
+	if rss.Client != client {
+		t.Error("rss.Client isn't the client given")
+	}
+	_, err = rss.Update()
+	if e, ok := err.(net.Error); !ok || !e.Timeout() {
+		t.Errorf("Update() with a hanging server didn't time out, %v", err)
+	}
+}
+
+func TestFeedFromURLContext(t *testing.T) {
+	release := make(chan bool)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(rss20Text[:len(rss20Text)/2]))
+		w.(http.Flusher).Flush()
+		<-release
+	}))
+	defer srv.Close()
+	defer close(release) // before Close, which waits for the handler
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	done := make(chan error, 1)
+	go func() {
+		_, err := FeedFromURLContext(ctx, srv.URL)
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err == nil || ctx.Err() == nil {
+			t.Error("FeedFromURLContext didn't fail with ctx, err:", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("FeedFromURLContext went on reading the body after the deadline")
+	}
+}
+
+func TestUpdateConditional(t *testing.T) {
+	var mu sync.Mutex
+	version, full, notModified := 1, 0, 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		etag := `"v` + strconv.Itoa(version) + `"`
+		lastModified := time.Date(2018, 1, version, 0, 0, 0, 0, time.UTC).Format(http.TimeFormat)
+		if r.Header.Get("If-None-Match") == etag && r.Header.Get("If-Modified-Since") == lastModified {
+			notModified++
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		full++
+		w.Header().Set("ETag", etag)
+		w.Header().Set("Last-Modified", lastModified)
+		text := rss20Text
+		if version > 1 {
+			text = strings.Replace(text, "<item>", "<item><title>new</title><link>http://example.com/new</link></item><item>", 1)
+		}
+		w.Write([]byte(text))
+	}))
+	defer srv.Close()
+
+	rss, err := FeedFromURL(srv.URL)
+	if err != nil {
+		t.Fatal("fetch failed:", err)
+	}
+	for i := 0; i < 3; i++ {
+		if newItems, err := rss.Update(); err != nil || newItems != nil {
+			t.Errorf("Update() of an unchanged feed != [], %v, %v", newItems, err)
+		}
+	}
+	if full != 1 || notModified != 3 {
+		t.Errorf("full, 304 responses != 1, 3; %d, %d", full, notModified)
+	}
+
+	mu.Lock()
+	version = 2
+	mu.Unlock()
+	if newItems, err := rss.Update(); err != nil || len(newItems) != 1 || newItems[0].Title != "new" {
+		t.Errorf("Update() of a changed feed != [new], %v, %v", newItems, err)
+	}
+	if newItems, err := rss.Update(); err != nil || newItems != nil {
+		t.Errorf("Update() after a change != [], %v, %v", newItems, err)
+	}
+	if full != 2 || notModified != 4 {
+		t.Errorf("full, 304 responses != 2, 4; %d, %d", full, notModified)
+	}
+}
+
+func TestFetchFeedMislabeled(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		switch r.URL.Path {
+		case "/page":
+			w.Write([]byte("<!DOCTYPE html>\n<html><head><title>Not Found</title></head><body><p>Gone<br></body></html>"))
+		case "/atom":
+			w.Write([]byte(`<?xml version="1.0"?><feed xmlns="http://www.w3.org/2005/Atom"><title>Atom</title>
+<entry><id>urn:1</id><title>Entry</title><updated>2018-01-01T00:00:00Z</updated></entry></feed>`))
+		case "/opml":
+			w.Write([]byte(`<?xml version="1.0"?><opml version="2.0"><body><outline xmlUrl="http://example.com/rss"/></body></opml>`))
+		default:
+			w.Write([]byte(rss20Text))
+		}
+	}))
+	defer srv.Close()
+
+	rss, err := FetchFeed(context.Background(), srv.URL+"/feed", FetchOptions{})
+	if err != nil {
+		t.Fatal("fetch of an RSS feed served as text/html failed:", err)
+	}
+	if rss.Version != "2.0" || len(rss.Channel.Items) != 1 {
+		t.Error("feed served as text/html wasn't decoded")
+	}
+
+	_, err = FetchFeed(context.Background(), srv.URL+"/page", FetchOptions{})
+	if err == nil || !strings.Contains(err.Error(), "HTML page") {
+		t.Error("fetch of an HTML page didn't fail as such,", err)
+	}
+
+	rss, err = FetchFeed(context.Background(), srv.URL+"/atom", FetchOptions{})
+	if err != nil || rss.Version != AtomVersion || rss.Channel.Title != "Atom" || len(rss.Channel.Items) != 1 {
+		t.Errorf("fetch of an Atom feed = %v, %v", rss, err)
+	}
+
+	_, err = FetchFeed(context.Background(), srv.URL+"/opml", FetchOptions{})
+	if e, ok := err.(*FormatError); !ok || e.Format != "opml" || e.Filename != srv.URL+"/opml" {
+		t.Error("fetch of an OPML list didn't fail with a FormatError,", err)
+	}
+}
+
+func TestDeclaredFeedURL(t *testing.T) {
+	text := strings.Replace(rss20Text, `href="https://www.solidot.org/index.rss"`, `href="/index.rss"`, 1)
+	rss, err := FeedFromURLWithClient("http://www.solidot.org/feed", &http.Client{Transport: &cachingTransport{text: text}})
+	if err != nil {
+		t.Fatal("fetch failed:", err)
+	}
+	if u := rss.DeclaredFeedURL(); u != "http://www.solidot.org/index.rss" {
+		t.Errorf("DeclaredFeedURL() != \"http://www.solidot.org/index.rss\", %q", u)
+	}
+
+	rss, _ = Feed([]byte(rss20Text))
+	if u := rss.DeclaredFeedURL(); u != "https://www.solidot.org/index.rss" {
+		t.Errorf("DeclaredFeedURL() != \"https://www.solidot.org/index.rss\", %q", u)
+	}
+	rss, _ = Feed([]byte(testFeed("a")))
+	if u := rss.DeclaredFeedURL(); u != "" {
+		t.Errorf("DeclaredFeedURL() of a feed without self link != \"\", %q", u)
+	}
+}
+
+func TestMovedTo(t *testing.T) {
+	var srv *httptest.Server
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/old":
+			http.Redirect(w, r, "/older", http.StatusMovedPermanently)
+		case "/older":
+			http.Redirect(w, r, "/feed", http.StatusPermanentRedirect)
+		case "/temp":
+			http.Redirect(w, r, "/feed", http.StatusFound)
+		case "/announce":
+			w.Write([]byte(strings.Replace(testFeed("a"), "<channel>", "<channel><newLocation>"+srv.URL+"/feed</newLocation>", 1)))
+		default:
+			w.Write([]byte(testFeed("a")))
+		}
+	}))
+	defer srv.Close()
+
+	tests := []struct {
+		path, movedTo string
+	}{
+		{"/feed", ""},
+		{"/old", srv.URL + "/feed"},
+		{"/temp", ""},
+		{"/announce", srv.URL + "/feed"},
+	}
+	for _, tt := range tests {
+		rss, err := FeedFromURL(srv.URL + tt.path)
+		if err != nil {
+			t.Fatalf("fetch of %s failed: %v", tt.path, err)
+		}
+		if u := rss.MovedTo(); u != tt.movedTo {
+			t.Errorf("MovedTo() of %s != %q, %q", tt.path, tt.movedTo, u)
+		}
+		if _, err := rss.Update(); err != nil {
+			t.Fatalf("update of %s failed: %v", tt.path, err)
+		}
+		if u := rss.MovedTo(); u != tt.movedTo {
+			t.Errorf("MovedTo() of %s after Update != %q, %q", tt.path, tt.movedTo, u)
+		}
+	}
+
+	rss, _ := Feed([]byte(strings.Replace(testFeed("a"), "<channel>", "<channel><newLocation>http://example.com/</newLocation>", 1)))
+	if rss.Channel.NewLocation != "http://example.com/" {
+		t.Errorf("NewLocation != \"http://example.com/\", %q", rss.Channel.NewLocation)
+	}
+	if u := rss.MovedTo(); u != "" {
+		t.Errorf("MovedTo() of a feed not read from a URL != \"\", %q", u)
+	}
+}
+
+func TestExpiresAt(t *testing.T) {
+	var cacheControl, expires string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if cacheControl != "" {
+			w.Header().Set("Cache-Control", cacheControl)
+		}
+		if expires != "" {
+			w.Header().Set("Expires", expires)
+		}
+		w.Write([]byte(testFeed("a")))
+	}))
+	defer srv.Close()
+
+	tests := []struct {
+		cacheControl, expires string
+		age                   time.Duration
+	}{
+		{"", "", DefaultTTL},
+		{"public, max-age=60", "", time.Minute},
+		{"max-age=7200", "", DefaultTTL},
+		{"", time.Now().Add(5 * time.Minute).UTC().Format(http.TimeFormat), 5 * time.Minute},
+		{"", "0", 0},
+	}
+	for _, tt := range tests {
+		cacheControl, expires = tt.cacheControl, tt.expires
+		rss, err := FeedFromURL(srv.URL)
+		if err != nil {
+			t.Fatal("fetch failed:", err)
+		}
+		age := rss.ExpiresAt().Sub(rss.lastUpdateAt)
+		if d := age - tt.age; d < -2*time.Second || d > 2*time.Second {
+			t.Errorf("Cache-Control %q, Expires %q: ExpiresAt() is %v after the update, want %v",
+				tt.cacheControl, tt.expires, age, tt.age)
+		}
+	}
+
+	rss, _ := Feed([]byte(testFeed("a")))
+	rss.Channel.TTL = 5
+	if age := rss.ExpiresAt().Sub(rss.lastUpdateAt); age != 5*time.Minute {
+		t.Errorf("ExpiresAt() of a feed with a TTL of 5 is %v after the update, want 5m", age)
+	}
+	if !new(RSS).ExpiresAt().IsZero() {
+		t.Error("ExpiresAt() of an empty RSS isn't zero")
+	}
+}
+
+func TestCheckGUIDStability(t *testing.T) {
+	requests := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if r.URL.Path == "/stable" {
+			w.Write([]byte(testFeed("a", "b")))
+			return
+		}
+		// The GUID of b changes on every request, and c only shows up in
+		// the second copy.
+		text := `<rss version="2.0"><channel><title>Test</title>
+<item><title>a</title><guid>a</guid><link>http://example.com/a</link></item>
+<item><title>b</title><guid>b-` + strconv.Itoa(requests) + `</guid><link>http://example.com/b</link></item>`
+		if requests%2 == 0 {
+			text += `<item><title>c</title><guid>c</guid></item>`
+		}
+		w.Write([]byte(text + `</channel></rss>`))
+	}))
+	defer srv.Close()
+
+	rss, err := FeedFromURL(srv.URL + "/stable")
+	if err != nil {
+		t.Fatal("fetch failed:", err)
+	}
+	stable, changed, err := rss.CheckGUIDStability(nil)
+	if err != nil || !stable || changed != nil {
+		t.Errorf("CheckGUIDStability() of a stable feed = %v, %v, %v", stable, changed, err)
+	}
+
+	rss, err = FeedFromURL(srv.URL + "/flaky")
+	if err != nil {
+		t.Fatal("fetch failed:", err)
+	}
+	stable, changed, err = rss.CheckGUIDStability(nil)
+	if err != nil || stable || len(changed) != 1 || !strings.HasPrefix(changed[0], "b-") {
+		t.Errorf("CheckGUIDStability() of a flaky feed = %v, %v, %v", stable, changed, err)
+	}
+
+	if _, _, err := new(RSS).CheckGUIDStability(nil); err != ErrNoReloadableSource {
+		t.Errorf("CheckGUIDStability() of an RSS read from bytes error != ErrNoReloadableSource, %v", err)
+	}
+}
+
+func TestFeedHandler(t *testing.T) {
+	fail := false
+	srv := httptest.NewServer(FeedHandler(func() (*RSS, error) {
+		if fail {
+			return nil, errors.New("no feed")
+		}
+		return Feed([]byte(rss20Text))
+	}))
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	etag := resp.Header.Get("ETag")
+	if resp.StatusCode != http.StatusOK || etag == "" ||
+		resp.Header.Get("Content-Type") != "application/rss+xml; charset=utf-8" {
+		t.Errorf("response = %s, %v", resp.Status, resp.Header)
+	}
+
+	// FetchFeed reads the feed and revalidates it with the ETag.
+	rss, err := FetchFeed(context.Background(), srv.URL, FetchOptions{})
+	if err != nil || len(rss.Channel.Items) != 1 {
+		t.Fatal("fetch failed:", err)
+	}
+	if _, err := FetchFeed(context.Background(), srv.URL, FetchOptions{ETag: rss.ETag()}); err != ErrNotModified {
+		t.Errorf("conditional fetch error != ErrNotModified, %v", err)
+	}
+
+	req, _ := http.NewRequest(http.MethodGet, srv.URL, nil)
+	req.Header.Set("If-None-Match", `"other", W/`+etag)
+	if resp, err := http.DefaultClient.Do(req); err != nil || resp.StatusCode != http.StatusNotModified {
+		t.Errorf("If-None-Match with a weak match isn't 304, %v, %v", resp, err)
+	}
+
+	if resp, err := http.Post(srv.URL, "text/plain", strings.NewReader("")); err != nil || resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("POST isn't 405, %v, %v", resp, err)
+	}
+
+	fail = true
+	if resp, err := http.Get(srv.URL); err != nil || resp.StatusCode != http.StatusInternalServerError {
+		t.Errorf("provider error isn't 500, %v, %v", resp, err)
+	}
+}
+
+func TestFeedHandlerUndated(t *testing.T) {
+	srv := httptest.NewServer(FeedHandler(func() (*RSS, error) {
+		return Feed([]byte(`<rss version="2.0"><channel><title>Undated</title><item><title>a</title></item></channel></rss>`))
+	}))
+	defer srv.Close()
+
+	get := func(req *http.Request) (*http.Response, []byte) {
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		b, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp, b
+	}
+
+	for _, accept := range []string{"application/rss+xml", "application/atom+xml", "application/feed+json"} {
+		req, _ := http.NewRequest(http.MethodGet, srv.URL, nil)
+		req.Header.Set("Accept", accept)
+		resp, b := get(req)
+
+		// A date stamped in the document would differ from one second
+		// to the next; the body must not change under the same ETag.
+		time.Sleep(1100 * time.Millisecond)
+		resp2, b2 := get(req)
+		etag := resp.Header.Get("ETag")
+		if resp2.Header.Get("ETag") != etag {
+			t.Errorf("Accept %q: the ETag of an undated feed changed, %q, %q", accept, etag, resp2.Header.Get("ETag"))
+		} else if !bytes.Equal(b, b2) {
+			t.Errorf("Accept %q: ETag %s served two bodies:\n%s\n%s", accept, etag, b, b2)
+		}
+
+		req.Header.Set("If-None-Match", etag)
+		if resp, _ = get(req); resp.StatusCode != http.StatusNotModified {
+			t.Errorf("Accept %q: revalidating an undated feed isn't 304, %v", accept, resp)
+		}
+	}
+}
+
+func TestFeedHandlerETagMedia(t *testing.T) {
+	var mu sync.Mutex
+	second := "http://example.com/a.ogg"
+	srv := httptest.NewServer(FeedHandler(func() (*RSS, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		return Feed([]byte(`<rss version="2.0"><channel><title>Media</title><item><title>a</title>` +
+			`<enclosure url="http://example.com/a.mp3" length="1" type="audio/mpeg"/>` +
+			`<enclosure url="` + second + `" length="1" type="audio/ogg"/></item></channel></rss>`))
+	}))
+	defer srv.Close()
+
+	for _, accept := range []string{"application/rss+xml", "application/atom+xml", "application/feed+json"} {
+		mu.Lock()
+		second = "http://example.com/a.ogg"
+		mu.Unlock()
+		req, _ := http.NewRequest(http.MethodGet, srv.URL, nil)
+		req.Header.Set("Accept", accept)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+
+		// Only the second media object changes, which the served
+		// document carries.
+		mu.Lock()
+		second = "http://example.com/b.ogg"
+		mu.Unlock()
+		req.Header.Set("If-None-Match", resp.Header.Get("ETag"))
+		if resp, err = http.DefaultClient.Do(req); err != nil || resp.StatusCode != http.StatusOK {
+			t.Errorf("Accept %q: a changed Media[1] is answered %v, %v", accept, resp, err)
+			continue
+		}
+		resp.Body.Close()
+	}
+}
+
+func TestFeedHandlerNegotiation(t *testing.T) {
+	srv := httptest.NewServer(FeedHandler(func() (*RSS, error) { return Feed([]byte(rss20Text)) }))
+	defer srv.Close()
+
+	tests := []struct {
+		accept, contentType, root string
+	}{
+		{"", "application/rss+xml; charset=utf-8", "rss"},
+		{"*/*", "application/rss+xml; charset=utf-8", "rss"},
+		{"application/atom+xml", "application/atom+xml; charset=utf-8", "feed"},
+		{"application/feed+json", "application/feed+json; charset=utf-8", ""},
+		{"application/rss+xml;q=0.5, application/atom+xml;q=0.9", "application/atom+xml; charset=utf-8", "feed"},
+		{"application/atom+xml, application/rss+xml", "application/rss+xml; charset=utf-8", "rss"},
+		{"text/html", "application/rss+xml; charset=utf-8", "rss"},
+	}
+	for _, tt := range tests {
+		req, _ := http.NewRequest(http.MethodGet, srv.URL, nil)
+		if tt.accept != "" {
+			req.Header.Set("Accept", tt.accept)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if ct := resp.Header.Get("Content-Type"); ct != tt.contentType {
+			t.Errorf("Accept %q: Content-Type != %q, %q", tt.accept, tt.contentType, ct)
+		}
+		if root := rootElement(b); root != tt.root {
+			t.Errorf("Accept %q: root element != %q, %q", tt.accept, tt.root, root)
+		}
+	}
+}
+
+func TestFetchFeedWithIcon(t *testing.T) {
+	image := `<image><url>/logo.png</url><title>Example</title><link>http://example.com/</link></image>`
+	mux := http.NewServeMux()
+	mux.HandleFunc("/feed.xml", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(strings.Replace(rss20Text, "<item>", image+"<item>", 1)))
+	})
+	mux.HandleFunc("/plain.xml", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(rss20Text))
+	})
+	mux.HandleFunc("/logo.png", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		w.Write([]byte("logo"))
+	})
+	favicon := true
+	mux.HandleFunc("/favicon.ico", func(w http.ResponseWriter, r *http.Request) {
+		if !favicon {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "image/x-icon")
+		w.Write([]byte("favicon"))
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	rss, icon, err := FetchFeedWithIcon(context.Background(), srv.URL+"/feed.xml", nil)
+	if err != nil || len(rss.Channel.Items) != 1 {
+		t.Fatal("fetch failed:", err)
+	}
+	if string(icon) != "logo" {
+		t.Errorf("icon != \"logo\", %q", icon)
+	}
+
+	if _, icon, err = FetchFeedWithIcon(context.Background(), srv.URL+"/plain.xml", nil); err != nil || string(icon) != "favicon" {
+		t.Errorf("icon of a feed without image != \"favicon\", %q, %v", icon, err)
+	}
+
+	favicon = false
+	if _, icon, err = FetchFeedWithIcon(context.Background(), srv.URL+"/plain.xml", nil); err != nil || icon != nil {
+		t.Errorf("missing icon = %q, %v", icon, err)
+	}
+	if _, _, err = FetchFeedWithIcon(context.Background(), srv.URL+"/missing.xml", nil); err == nil {
+		t.Error("missing feed didn't fail")
+	}
+}
+
+func TestRateLimited(t *testing.T) {
+	retryAfter := "120"
+	status := http.StatusTooManyRequests
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if retryAfter != "" {
+			w.Header().Set("Retry-After", retryAfter)
+		}
+		w.WriteHeader(status)
+	}))
+	defer srv.Close()
+
+	_, err := FetchFeed(context.Background(), srv.URL, FetchOptions{})
+	if e, ok := err.(*RateLimitError); !ok || e.RetryAfter != 2*time.Minute || e.StatusCode != 429 {
+		t.Errorf("FetchFeed() error != RateLimitError{RetryAfter: 2m}, %#v", err)
+	}
+
+	status = http.StatusServiceUnavailable
+	retryAfter = time.Now().Add(time.Hour).UTC().Format(http.TimeFormat)
+	_, err = FetchFeed(context.Background(), srv.URL, FetchOptions{})
+	if e, ok := err.(*RateLimitError); !ok || e.RetryAfter < 59*time.Minute || e.RetryAfter > time.Hour {
+		t.Errorf("FetchFeed() error != RateLimitError{RetryAfter: 1h}, %#v", err)
+	}
+
+	// A 503 without Retry-After is an outage, not throttling.
+	retryAfter = ""
+	if _, err = FetchFeed(context.Background(), srv.URL, FetchOptions{}); err == nil {
+		t.Error("FetchFeed() of a 503 didn't fail")
+	} else if _, ok := err.(*RateLimitError); ok {
+		t.Errorf("FetchFeed() of a 503 without Retry-After = %v", err)
+	}
+
+	for v, want := range map[string]time.Duration{"0": 0, " 30 ": 30 * time.Second, "Wed, 21 Oct 2015 07:28:00 GMT": 0} {
+		if d, ok := parseRetryAfter(v); !ok || d != want {
+			t.Errorf("parseRetryAfter(%q) = %v, %v", v, d, ok)
+		}
+	}
+	if _, ok := parseRetryAfter("soon"); ok {
+		t.Error("parseRetryAfter(\"soon\") ok")
+	}
+}
+
+func TestServeContext(t *testing.T) {
+	release := make(chan bool)
+	requests := make(chan bool, 10)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests <- true
+		<-release
+	}))
+	defer srv.Close()
+	defer close(release) // before Close, which waits for the handler
+
+	rss, _ := Feed([]byte(rss20Text))
+	rss.source, rss.sourceKind = srv.URL, SourceURL
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- rss.ServeContext(ctx, 10*time.Millisecond) }()
+
+	<-requests // an update hangs in the request
+	cancel()
+	select {
+	case err := <-done:
+		if err != context.Canceled {
+			t.Errorf("ServeContext() != context.Canceled, %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("ServeContext didn't return when ctx was canceled")
+	}
+}
+
+func TestServeRateLimited(t *testing.T) {
+	rss, _ := Feed([]byte(rss20Text))
+	calls := make(chan time.Time, 10)
+	n := 0
+	update := func(context.Context) error {
+		calls <- time.Now()
+		if n++; n == 1 {
+			return &RateLimitError{Status: "429 Too Many Requests", RetryAfter: 300 * time.Millisecond}
+		}
+		return nil
+	}
+	done := make(chan error)
+	go func() { done <- rss.serve(context.Background(), 10*time.Millisecond, nil, update) }()
+
+	first := <-calls
+	second := <-calls
+	rss.Stop()
+	if err := <-done; err != nil {
+		t.Error("serve failed:", err)
+	}
+	if d := second.Sub(first); d < 250*time.Millisecond {
+		t.Errorf("update retried %v after being rate limited, want 300ms", d)
+	}
+}
@@ -0,0 +1,158 @@
+// Copyright 2018 cotox. All rights reserved.
+// Use of this source code is governed by a GPLv3
+// license that can be found in the LICENSE file.
+
+package rssutil
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// FeedHandler returns an http.Handler serving the feed provider returns,
+// called anew for every request, in the format the client asks for in
+// its Accept header: RSS 2.0 as ToXML writes it, Atom as ToAtom does or
+// JSON Feed as ToJSONFeed does, except that missing dates aren't stamped
+// with the current time. RSS is served when the client expresses no
+// preference among them. Responses carry an ETag derived from the
+// content of the feed, and requests whose If-None-Match lists it are
+// answered 304 Not Modified, so clients polling with conditional
+// requests, such as FetchFeed, only download changes.
+//
+// Only GET and HEAD are allowed. A provider error is logged and answered
+// with 500 Internal Server Error.
+func FeedHandler(provider func() (*RSS, error)) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Add("Vary", "Accept")
+
+		rss, err := provider()
+		if err == nil {
+			f := negotiateFormat(r.Header.Get("Accept"))
+			var b []byte
+			if b, err = f.encode(rss); err == nil {
+				serveFeed(w, r, b, f.contentType, feedETag(b, f))
+				return
+			}
+		}
+		logErr(err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+	})
+}
+
+// feedFormat is a format FeedHandler serves.
+type feedFormat struct {
+	mediaTypes  []string // the media types of Accept headers asking for it
+	contentType string
+	encode      func(rss *RSS) ([]byte, error)
+}
+
+// feedFormats are the formats FeedHandler serves, the default first.
+// Their encoders don't stamp the current time on undated feeds, so that
+// a feed encodes to the same document, and ETag, until it changes; an
+// Atom feed without any date is updated at the zero time.
+var feedFormats = []feedFormat{
+	{
+		[]string{"application/rss+xml", "application/xml", "text/xml"},
+		"application/rss+xml; charset=utf-8",
+		func(rss *RSS) ([]byte, error) {
+			return rss.ToXMLWithOptions(XMLOptions{PreserveDates: true})
+		},
+	},
+	{
+		[]string{"application/atom+xml"},
+		"application/atom+xml; charset=utf-8",
+		func(rss *RSS) ([]byte, error) {
+			return rss.toAtom(time.Time{})
+		},
+	},
+	{
+		[]string{"application/feed+json", "application/json"},
+		"application/feed+json; charset=utf-8",
+		(*RSS).ToJSONFeed,
+	},
+}
+
+// negotiateFormat returns the format of feedFormats the Accept header
+// value accept prefers: the one with the highest quality, earlier ones
+// winning ties.
+func negotiateFormat(accept string) feedFormat {
+	best, bestQ := feedFormats[0], 0.0
+	for _, f := range feedFormats {
+		q := 0.0
+		for _, r := range strings.Split(accept, ",") {
+			params := strings.Split(r, ";")
+			mediaType := strings.ToLower(strings.TrimSpace(params[0]))
+			if !containsString(f.mediaTypes, mediaType) {
+				continue
+			}
+			rq := 1.0
+			for _, p := range params[1:] {
+				if p = strings.TrimSpace(p); strings.HasPrefix(p, "q=") {
+					if v, err := strconv.ParseFloat(p[2:], 64); err == nil {
+						rq = v
+					}
+				}
+			}
+			if rq > q {
+				q = rq
+			}
+		}
+		if q > bestQ {
+			best, bestQ = f, q
+		}
+	}
+	return best
+}
+
+// containsString reports whether a contains s.
+func containsString(a []string, s string) bool {
+	for _, v := range a {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// feedETag returns the ETag of the document b served as f.
+func feedETag(b []byte, f feedFormat) string {
+	h := sha256.New()
+	h.Write([]byte(f.contentType))
+	h.Write(b)
+	return `"` + hex.EncodeToString(h.Sum(nil)[:16]) + `"`
+}
+
+// serveFeed writes the document b, of type contentType, as the response
+// to r with etag, or 304 Not Modified if r already has it.
+func serveFeed(w http.ResponseWriter, r *http.Request, b []byte, contentType, etag string) {
+	h := w.Header()
+	h.Set("ETag", etag)
+	if etagMatch(r.Header.Get("If-None-Match"), etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	h.Set("Content-Type", contentType)
+	h.Set("Content-Length", strconv.Itoa(len(b)))
+	w.Write(b)
+}
+
+// etagMatch reports whether the If-None-Match header value list matches
+// etag, comparing weakly as RFC 7232 requires.
+func etagMatch(list, etag string) bool {
+	for _, t := range strings.Split(list, ",") {
+		t = strings.TrimSpace(t)
+		if t == "*" || strings.TrimPrefix(t, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,118 @@
+// Copyright 2018 cotox. All rights reserved.
+// Use of this source code is governed by a GPLv3
+// license that can be found in the LICENSE file.
+
+package rssutil
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// maxIconBytes limits the size of the icons FetchFeedWithIcon downloads.
+const maxIconBytes = 1 << 20
+
+// FetchFeedWithIcon fetches the feed at url like FeedFromURLWithClient,
+// along with an icon to show it with: the image of its channel, or else
+// the favicon of the site serving it. The favicon is fetched while the
+// feed is, so that a new subscription can be shown with its logo without
+// waiting for another round-trip; the image, whose URL only the feed
+// gives, is fetched as soon as the feed is read.
+//
+// Failing to get an icon doesn't fail the call, it only makes icon nil.
+// A nil client means http.DefaultClient.
+func FetchFeedWithIcon(ctx context.Context, url string, client *http.Client) (rss *RSS, icon []byte, err error) {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	favicon := make(chan []byte, 1)
+	go func() {
+		var b []byte
+		if u := faviconURL(url); u != "" {
+			b = fetchIcon(ctx, client, u)
+		}
+		favicon <- b
+	}()
+
+	rss, err = FetchFeed(ctx, url, FetchOptions{Client: client})
+	if err != nil {
+		return nil, nil, err
+	}
+	rss.Client = client
+
+	if img := rss.Channel.Image; img != nil && strings.TrimSpace(img.URL) != "" {
+		if ec := (RSSEnclosure{URL: img.URL}); ec.IsDataURI() {
+			icon, _, _ = ec.DecodeData()
+		} else if u := resolveURL(url, img.URL); u != "" {
+			icon = fetchIcon(ctx, client, u)
+		}
+	}
+	if icon == nil {
+		icon = <-favicon
+	}
+	return rss, icon, nil
+}
+
+// faviconURL returns the URL of the favicon of the site serving the
+// page at ref, or "" if ref isn't an http or https URL.
+func faviconURL(ref string) string {
+	u := httpURL(ref)
+	if u == nil {
+		return ""
+	}
+	return (&url.URL{Scheme: u.Scheme, Host: u.Host, Path: "/favicon.ico"}).String()
+}
+
+// resolveURL returns ref resolved against base, or "" if either doesn't
+// parse.
+func resolveURL(base, ref string) string {
+	b, err := url.Parse(base)
+	if err != nil {
+		return ""
+	}
+	u, err := b.Parse(strings.TrimSpace(ref))
+	if err != nil {
+		return ""
+	}
+	return u.String()
+}
+
+// fetchIcon returns the image at url, or nil if it can't be had. Errors
+// are only logged, icons being optional.
+func fetchIcon(ctx context.Context, client *http.Client, url string) []byte {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		logWarnf("fetch icon %s: %v", url, err)
+		return nil
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		logWarnf("fetch icon %s: %v", url, err)
+		return nil
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		logWarnf("fetch icon %s: %s", url, resp.Status)
+		return nil
+	}
+	// Error pages are often served with 200 OK; icons are never text.
+	if ct := strings.ToLower(resp.Header.Get("Content-Type")); strings.HasPrefix(ct, "text/") {
+		logWarnf("fetch icon %s: got %s, not an image", url, ct)
+		return nil
+	}
+	b, err := readAll(resp.Body, maxIconBytes)
+	if err != nil {
+		logWarnf("fetch icon %s: %v", url, err)
+		return nil
+	}
+	if len(b) == 0 {
+		return nil
+	}
+	return b
+}
@@ -0,0 +1,383 @@
+// Copyright 2018 cotox. All rights reserved.
+// Use of this source code is governed by a GPLv3
+// license that can be found in the LICENSE file.
+
+package rssutil
+
+import (
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ItemsByDateDesc returns the items of c sorted newest first by their
+// EffectiveDate, leaving c.Items in its original order. Undated items
+// sort last, in their original relative order.
+func (c RSSChannel) ItemsByDateDesc() []RSSItem {
+	items := make([]RSSItem, len(c.Items))
+	copy(items, c.Items)
+	sort.SliceStable(items, func(i, j int) bool {
+		di, dj := items[i].EffectiveDate(), items[j].EffectiveDate()
+		if dj.IsZero() {
+			return !di.IsZero()
+		}
+		return di.After(dj)
+	})
+	return items
+}
+
+// ItemsByDay returns the items of c grouped by the day, in loc, of their
+// EffectiveDate, keyed as "2006-01-02". Undated items are under "". A nil
+// loc means UTC. Within a day items keep their order in c.
+func (c RSSChannel) ItemsByDay(loc *time.Location) map[string][]RSSItem {
+	if loc == nil {
+		loc = time.UTC
+	}
+	days := make(map[string][]RSSItem)
+	for _, it := range c.Items {
+		var day string
+		if d := it.EffectiveDate(); !d.IsZero() {
+			day = d.In(loc).Format("2006-01-02")
+		}
+		days[day] = append(days[day], it)
+	}
+	return days
+}
+
+// ItemsInCategory returns the items of c having a category named name,
+// compared without regard to case. With includeChannel set, the
+// categories of c count as those of every item, for feeds that only tag
+// at the channel level.
+func (c RSSChannel) ItemsInCategory(name string, includeChannel bool) []RSSItem {
+	if includeChannel && inCategory(c.Categories, name) {
+		items := make([]RSSItem, len(c.Items))
+		copy(items, c.Items)
+		return items
+	}
+
+	var items []RSSItem
+	for _, it := range c.Items {
+		if inCategory(it.Categories, name) {
+			items = append(items, it)
+		}
+	}
+	return items
+}
+
+// inCategory reports whether a has a category named name.
+func inCategory(a []RSSCategory, name string) bool {
+	name = strings.TrimSpace(name)
+	for _, ca := range a {
+		if strings.EqualFold(ca.Value, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// PageResponse returns the limit items of c starting at offset, in feed
+// order, along with the total number of items, for APIs that page through
+// a feed. A limit that isn't positive means no limit. An offset past the
+// end yields no items, not an error.
+func (c RSSChannel) PageResponse(offset, limit int) (items []RSSItem, total int) {
+	total = len(c.Items)
+	if offset < 0 {
+		offset = 0
+	}
+	if offset > total {
+		offset = total
+	}
+	end := total
+	if limit > 0 && offset+limit < total {
+		end = offset + limit
+	}
+	items = make([]RSSItem, end-offset)
+	copy(items, c.Items[offset:end])
+	return items, total
+}
+
+// ItemByGUID returns the first item of c with the given GUID, or nil if
+// there is none. See FeedOptions.DuplicateGUIDs for feeds that reuse
+// GUIDs.
+func (c RSSChannel) ItemByGUID(guid string) *RSSItem {
+	for i := range c.Items {
+		if c.Items[i].GUID.Value == guid {
+			return &c.Items[i]
+		}
+	}
+	return nil
+}
+
+// Latest returns the newest item of c by EffectiveDate, or nil if c has
+// no items. Ties, and channels with no dated item, go to the first in
+// feed order.
+func (c RSSChannel) Latest() *RSSItem {
+	var latest *RSSItem
+	var t time.Time
+	for i := range c.Items {
+		if d := c.Items[i].EffectiveDate(); latest == nil || d.After(t) {
+			latest, t = &c.Items[i], d
+		}
+	}
+	return latest
+}
+
+// RecentN returns the n newest items of c by EffectiveDate, or all of
+// them if c has fewer, leaving c.Items in its original order. Undated
+// items come last, and ties keep their order in c.
+func (c RSSChannel) RecentN(n int) []RSSItem {
+	if n <= 0 {
+		return nil
+	}
+	idx := make([]int, len(c.Items))
+	dates := make([]time.Time, len(c.Items))
+	for i := range c.Items {
+		idx[i], dates[i] = i, c.Items[i].EffectiveDate()
+	}
+	sort.SliceStable(idx, func(i, j int) bool { return dates[idx[i]].After(dates[idx[j]]) })
+	if n > len(idx) {
+		n = len(idx)
+	}
+	items := make([]RSSItem, n)
+	for i := range items {
+		items[i] = c.Items[idx[i]]
+	}
+	return items
+}
+
+// EffectiveDate returns the date it was published, or else the date it
+// was last updated, or the zero time if it isn't dated.
+func (it RSSItem) EffectiveDate() time.Time {
+	switch {
+	case hasDate(it.PubDate):
+		return time.Time(*it.PubDate)
+	case hasDate(it.UpdatedDate):
+		return time.Time(*it.UpdatedDate)
+	}
+	return time.Time{}
+}
+
+// AverageInterval estimates how often c publishes, as the mean gap
+// between the EffectiveDates of consecutive items. It returns 0 if
+// fewer than two items are dated.
+func (c RSSChannel) AverageInterval() time.Duration {
+	var first, last time.Time
+	n := 0
+	for _, it := range c.Items {
+		t := it.EffectiveDate()
+		if t.IsZero() {
+			continue
+		}
+		if n == 0 || t.Before(first) {
+			first = t
+		}
+		if n == 0 || t.After(last) {
+			last = t
+		}
+		n++
+	}
+	if n < 2 {
+		return 0
+	}
+	return last.Sub(first) / time.Duration(n-1)
+}
+
+// Cadence returns a label for how often c publishes, judging from its
+// AverageInterval: "realtime" under 15 minutes, "hourly" under 6 hours,
+// "daily" under 3 days, "weekly" up to 30 days and "dormant" beyond, as
+// it is too when its newest item is over 30 days old, however busy it
+// once was. It returns "unknown" if fewer than two items are dated.
+func (c RSSChannel) Cadence() string {
+	const month = 30 * 24 * time.Hour
+	d := c.AverageInterval()
+	if d == 0 {
+		return "unknown"
+	}
+	switch {
+	case d > month || time.Since(c.Latest().EffectiveDate()) > month:
+		return "dormant"
+	case d < 15*time.Minute:
+		return "realtime"
+	case d < 6*time.Hour:
+		return "hourly"
+	case d < 3*24*time.Hour:
+		return "daily"
+	}
+	return "weekly"
+}
+
+// InferredTimezone returns the zone the items of c appear to be dated in:
+// the UTC offset used by most of their pubDates, as a fixed zone named
+// after the abbreviation found in them, if any. Ties go to the offset seen
+// first. Only dates giving an offset or a zone name count, not those read
+// in FeedOptions.DefaultLocation for lack of one. It returns nil if no
+// item has such a date.
+func (c RSSChannel) InferredTimezone() *time.Location {
+	type zone struct {
+		name  string
+		count int
+	}
+	zones := make(map[int]*zone)
+	var best, bestOffset int
+	for _, it := range c.Items {
+		if !hasDate(it.PubDate) || it.localDate {
+			continue
+		}
+		name, offset := time.Time(*it.PubDate).Zone()
+		z := zones[offset]
+		if z == nil {
+			z = &zone{name: name}
+			zones[offset] = z
+		}
+		z.count++
+		if z.count > best {
+			best, bestOffset = z.count, offset
+		}
+	}
+	if best == 0 {
+		return nil
+	}
+	return time.FixedZone(zones[bestOffset].name, bestOffset)
+}
+
+// BestMedia returns the media object of it a player should pick, or nil
+// if it has none. The candidates are it.Media, or it.Enclosure for items
+// built without Media. Those whose type matches an earlier preferTypes
+// entry win; an entry may name a whole class of types, as "video/*" or
+// "audio/" do. Then the highest bitrate wins, then the largest length,
+// then the first in document order.
+func (it RSSItem) BestMedia(preferTypes ...string) *RSSEnclosure {
+	media := it.Media
+	if len(media) == 0 && it.Enclosure != nil {
+		media = []RSSEnclosure{*it.Enclosure}
+	}
+
+	var best *RSSEnclosure
+	bestRank := 0
+	for i := range media {
+		m := &media[i]
+		rank := mediaRank(m.Type, preferTypes)
+		switch {
+		case best == nil,
+			rank < bestRank,
+			rank == bestRank && m.Bitrate > best.Bitrate,
+			rank == bestRank && m.Bitrate == best.Bitrate && m.Length > best.Length:
+			best, bestRank = m, rank
+		}
+	}
+	return best
+}
+
+// TotalEnclosureBytes returns the sum of the lengths of the media objects
+// of the items of c, which is what downloading all of them takes. Media
+// of unknown length count as zero.
+func (c RSSChannel) TotalEnclosureBytes() int64 {
+	var total int64
+	for _, it := range c.Items {
+		media := it.Media
+		if len(media) == 0 && it.Enclosure != nil {
+			media = []RSSEnclosure{*it.Enclosure}
+		}
+		for _, m := range media {
+			if m.Length > 0 {
+				total += m.Length
+			}
+		}
+	}
+	return total
+}
+
+// mediaRank returns the index of the first of prefer that matches the
+// media type typ, or len(prefer) if none does.
+func mediaRank(typ string, prefer []string) int {
+	typ = strings.ToLower(strings.TrimSpace(typ))
+	for i, p := range prefer {
+		p = strings.ToLower(strings.TrimSuffix(p, "*"))
+		if typ == p || strings.HasSuffix(p, "/") && strings.HasPrefix(typ, p) {
+			return i
+		}
+	}
+	return len(prefer)
+}
+
+// ItemsMissingFields returns, for the items of c that lack fields a
+// reader relies on, keyed by index, the names of those fields: "title or
+// description" when an item has neither, which RSS forbids, then
+// "link", "guid" and "pubDate", which RSS recommends. Complete items are
+// left out, so an empty map means a feed of good quality.
+func (c RSSChannel) ItemsMissingFields() map[int][]string {
+	missing := make(map[int][]string)
+	for i, it := range c.Items {
+		var fields []string
+		if strings.TrimSpace(it.Title) == "" && strings.TrimSpace(it.Description) == "" {
+			fields = append(fields, "title or description")
+		}
+		if strings.TrimSpace(it.Link) == "" {
+			fields = append(fields, "link")
+		}
+		if strings.TrimSpace(it.GUID.Value) == "" {
+			fields = append(fields, "guid")
+		}
+		if !hasDate(it.PubDate) {
+			fields = append(fields, "pubDate")
+		}
+		if fields != nil {
+			missing[i] = fields
+		}
+	}
+	return missing
+}
+
+// RepairItems gives a title to the items of c that have neither a title
+// nor a description, which the specification forbids, so they can still
+// be displayed. The title is the last path segment of the item link, or
+// the file name of its enclosure. It returns the number of items
+// repaired; items with none of these are left alone.
+func (c *RSSChannel) RepairItems() (repaired int) {
+	for i := range c.Items {
+		it := &c.Items[i]
+		if it.Title != "" || it.Description != "" {
+			continue
+		}
+		title := lastPathSegment(it.Link)
+		if title == "" && it.Enclosure != nil {
+			title = lastPathSegment(it.Enclosure.URL)
+		}
+		if title != "" {
+			it.Title = title
+			repaired++
+		}
+	}
+	return repaired
+}
+
+// lastPathSegment returns the last non-empty segment of the path of
+// rawurl, unescaped, or "" if there is none.
+func lastPathSegment(rawurl string) string {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return ""
+	}
+	p := strings.TrimRight(u.Path, "/")
+	if p == "" {
+		return ""
+	}
+	return path.Base(p)
+}
+
+// newer reports whether a is a later date than b. A missing date is
+// older than any date.
+func newer(a, b *RFC822) bool {
+	switch {
+	case !hasDate(a):
+		return false
+	case !hasDate(b):
+		return true
+	}
+	return time.Time(*a).After(time.Time(*b))
+}
+
+// hasDate reports whether d is set.
+func hasDate(d *RFC822) bool { return d != nil && !d.IsZero() }
@@ -0,0 +1,117 @@
+// Copyright 2018 cotox. All rights reserved.
+// Use of this source code is governed by a GPLv3
+// license that can be found in the LICENSE file.
+
+package rssutil
+
+import (
+	"encoding/json"
+	"strconv"
+)
+
+// jsonFeedVersion is the version of JSON Feed ToJSONFeed writes.
+const jsonFeedVersion = "https://jsonfeed.org/version/1.1"
+
+// jsonFeed mirrors a JSON Feed document for encoding.
+type jsonFeed struct {
+	Version     string           `json:"version"`
+	Title       string           `json:"title"`
+	HomePageURL string           `json:"home_page_url,omitempty"`
+	FeedURL     string           `json:"feed_url,omitempty"`
+	Description string           `json:"description,omitempty"`
+	Icon        string           `json:"icon,omitempty"`
+	Language    string           `json:"language,omitempty"`
+	Authors     []jsonFeedAuthor `json:"authors,omitempty"`
+	Items       []jsonFeedItem   `json:"items"`
+}
+
+type jsonFeedItem struct {
+	ID            string               `json:"id"`
+	URL           string               `json:"url,omitempty"`
+	Title         string               `json:"title,omitempty"`
+	ContentHTML   string               `json:"content_html"`
+	DatePublished string               `json:"date_published,omitempty"`
+	DateModified  string               `json:"date_modified,omitempty"`
+	Authors       []jsonFeedAuthor     `json:"authors,omitempty"`
+	Tags          []string             `json:"tags,omitempty"`
+	Attachments   []jsonFeedAttachment `json:"attachments,omitempty"`
+}
+
+type jsonFeedAuthor struct {
+	Name string `json:"name"`
+}
+
+type jsonFeedAttachment struct {
+	URL         string `json:"url"`
+	MimeType    string `json:"mime_type"`
+	SizeInBytes int64  `json:"size_in_bytes,omitempty"`
+}
+
+// ToJSONFeed returns rss as a JSON Feed 1.1 document. Unlike ToJSON, which
+// mirrors RSS, it is meant for JSON Feed readers.
+//
+// The id of an item is its GUID or else its link, or its position in
+// the feed if it has neither, as JSON Feed requires one. The
+// ContentEncoded of items, or else their descriptions, become their HTML
+// content and media objects their attachments.
+func (rss *RSS) ToJSONFeed() ([]byte, error) {
+	ch := rss.Channel
+	doc := jsonFeed{
+		Version:     jsonFeedVersion,
+		Title:       ch.Title,
+		HomePageURL: ch.Link,
+		FeedURL:     ch.AtomLinkByRel("self"),
+		Description: ch.Description,
+		Language:    ch.Language,
+		Items:       []jsonFeedItem{},
+	}
+	if ch.Image != nil {
+		doc.Icon = ch.Image.URL
+	}
+	if ch.ManagingEditor != "" {
+		doc.Authors = []jsonFeedAuthor{{Name: ch.ManagingEditor}}
+	}
+
+	for i, it := range ch.Items {
+		item := jsonFeedItem{
+			ID:          it.GUID.Value,
+			URL:         it.Link,
+			Title:       it.Title,
+			ContentHTML: it.Description,
+			Tags:        categoryValues(it.Categories),
+		}
+		if it.ContentEncoded != "" {
+			item.ContentHTML = it.ContentEncoded
+		}
+		if item.ID == "" {
+			item.ID = it.Link
+		}
+		if item.ID == "" {
+			item.ID = strconv.Itoa(i + 1)
+		}
+		if hasDate(it.PubDate) {
+			item.DatePublished = it.PubDate.String()
+		}
+		if hasDate(it.UpdatedDate) {
+			item.DateModified = it.UpdatedDate.String()
+		}
+		if it.Author != "" {
+			item.Authors = []jsonFeedAuthor{{Name: it.Author}}
+		}
+		media := it.Media
+		if len(media) == 0 && it.Enclosure != nil {
+			media = []RSSEnclosure{*it.Enclosure}
+		}
+		for _, m := range media {
+			item.Attachments = append(item.Attachments, jsonFeedAttachment{URL: m.URL, MimeType: m.Type, SizeInBytes: m.Length})
+		}
+		doc.Items = append(doc.Items, item)
+	}
+
+	b, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		logErr(err)
+		return nil, err
+	}
+	return b, nil
+}
@@ -0,0 +1,162 @@
+// Copyright 2018 cotox. All rights reserved.
+// Use of this source code is governed by a GPLv3
+// license that can be found in the LICENSE file.
+
+package rssutil
+
+import (
+	"html"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// htmlLinkRE matches the href and src attributes of HTML markup.
+var htmlLinkRE = regexp.MustCompile(`(?i)\b(?:href|src)\s*=\s*(?:"([^"]*)"|'([^']*)'|([^\s"'>]+))`)
+
+// Links returns every URL it refers to: its link and alternate links,
+// comments page and media, then the href and src attributes found in its
+// description, in that order and without duplicates. Relative URLs are
+// resolved against the item link. Fragment-only and javascript:
+// references are left out.
+func (it RSSItem) Links() []string {
+	base, _ := url.Parse(it.Link)
+
+	var links []string
+	seen := make(map[string]bool)
+	add := func(ref string) {
+		ref = strings.TrimSpace(ref)
+		if ref == "" || ref[0] == '#' || strings.HasPrefix(strings.ToLower(ref), "javascript:") {
+			return
+		}
+		if base != nil {
+			if u, err := base.Parse(ref); err == nil {
+				ref = u.String()
+			}
+		}
+		if !seen[ref] {
+			seen[ref] = true
+			links = append(links, ref)
+		}
+	}
+
+	add(it.Link)
+	for _, l := range it.AltLinks {
+		add(l.Href)
+	}
+	add(it.Comments)
+	if it.Enclosure != nil {
+		add(it.Enclosure.URL)
+	}
+	for _, m := range it.Media {
+		add(m.URL)
+	}
+	for _, m := range htmlLinkRE.FindAllStringSubmatch(it.Description, -1) {
+		add(html.UnescapeString(m[1] + m[2] + m[3]))
+	}
+
+	return links
+}
+
+// CanonicalURL returns the URL that identifies it, or "" if it has none.
+// It is the first of these that is an absolute http or https URL: the
+// GUID if it is a permalink, the link, then the alternate links. Relative
+// URLs are skipped, as an item alone doesn't say what they are relative
+// to. The source URL names the feed the item came from, not the item, and
+// is never used.
+//
+// The URL is normalized so that equal URLs compare equal as strings: the
+// scheme and host are lowercased, default ports and the fragment are
+// dropped and an empty path becomes "/".
+func (it RSSItem) CanonicalURL() string {
+	var candidates []string
+	if it.GUID.IsPermaLink {
+		candidates = append(candidates, it.GUID.Value)
+	}
+	candidates = append(candidates, it.Link)
+	for _, l := range it.AltLinks {
+		if l.rel() == "alternate" {
+			candidates = append(candidates, l.Href)
+		}
+	}
+	for _, ref := range candidates {
+		if u := httpURL(ref); u != nil {
+			return normalizeURL(u)
+		}
+	}
+	return ""
+}
+
+// DeclaredFeedURL returns the URL the feed gives for itself, its
+// <atom:link rel="self">, or "" if it has none. It is resolved against
+// the URL the feed was fetched from, if any.
+//
+// It may differ from that URL, after a redirect or a move to https for
+// instance; subscriptions are best kept under the declared one.
+func (rss *RSS) DeclaredFeedURL() string {
+	self := strings.TrimSpace(rss.Channel.AtomLinkByRel("self"))
+	if self == "" {
+		return ""
+	}
+	if rss.sourceKind == SourceURL {
+		if base, err := url.Parse(rss.source); err == nil {
+			if u, err := base.Parse(self); err == nil {
+				return u.String()
+			}
+		}
+	}
+	return self
+}
+
+// MovedTo returns the URL the feed has moved to, or "" if it hasn't or
+// wasn't read from a URL. It is the first of these that is an http or
+// https URL other than the one rss is fetched from: the <newLocation> or
+// <itunes:new-feed-url> of the channel, the URL the last fetch ended at
+// when only permanent redirects led there, and the DeclaredFeedURL.
+//
+// Subscriptions are best moved to it, sparing the redirects and the
+// duplicates they cause.
+func (rss *RSS) MovedTo() string {
+	if rss.sourceKind != SourceURL {
+		return ""
+	}
+	source := httpURL(rss.source)
+	for _, ref := range []string{rss.Channel.NewLocation, rss.movedTo, rss.DeclaredFeedURL()} {
+		u := httpURL(ref)
+		if u != nil && (source == nil || normalizeURL(u) != normalizeURL(source)) {
+			return u.String()
+		}
+	}
+	return ""
+}
+
+// httpURL parses ref and returns it if it is an absolute http or https
+// URL.
+func httpURL(ref string) *url.URL {
+	u, err := url.Parse(strings.TrimSpace(ref))
+	if err != nil || u.Host == "" {
+		return nil
+	}
+	if s := strings.ToLower(u.Scheme); s != "http" && s != "https" {
+		return nil
+	}
+	return u
+}
+
+// normalizeURL returns u in the form described by CanonicalURL.
+func normalizeURL(u *url.URL) string {
+	n := *u
+	n.Scheme = strings.ToLower(n.Scheme)
+	n.Host = strings.ToLower(n.Host)
+	if n.Scheme == "http" {
+		n.Host = strings.TrimSuffix(n.Host, ":80")
+	} else {
+		n.Host = strings.TrimSuffix(n.Host, ":443")
+	}
+	if n.Path == "" {
+		n.Path = "/"
+	}
+	n.Fragment = ""
+	n.RawFragment = ""
+	return n.String()
+}
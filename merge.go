@@ -0,0 +1,96 @@
+// Copyright 2018 cotox. All rights reserved.
+// Use of this source code is governed by a GPLv3
+// license that can be found in the LICENSE file.
+
+package rssutil
+
+import (
+	"sort"
+	"time"
+)
+
+// MergeResult is what Merge returns.
+type MergeResult struct {
+	// Items are the items of the feeds, one per story, newest first by
+	// EffectiveDate.
+	Items []RSSItem
+
+	// Duplicates are the stories of which several items were found, in
+	// the order of their kept item in Items.
+	Duplicates []DuplicateGroup
+}
+
+// DuplicateGroup is a story Merge found several items of, such as one
+// reported by several sources.
+type DuplicateGroup struct {
+	// Key is the dedup key the items share.
+	Key string
+
+	// Items are the items of the story, the one kept in MergeResult.Items
+	// first, in the order of the feeds given to Merge.
+	Items []RSSItem
+
+	// Sources are the feeds Items came from, Sources[i] being that of
+	// Items[i]. A feed repeating a story appears once per item.
+	Sources []*RSS
+}
+
+// Merge aggregates the items of feeds into one list, keeping a single
+// item of each story: items with the same key are taken to be the same
+// story, of which the first in the order of feeds is kept. The stories
+// found more than once are reported with their sources, for "also
+// reported by" attributions.
+//
+// A nil key matches items by GUID, falling back to link, then to title
+// and description, as Diff does; ContentKey also catches stories
+// republished under other GUIDs. Nil feeds are skipped.
+func Merge(key func(RSSItem) string, feeds ...*RSS) MergeResult {
+	if key == nil {
+		key = itemKey
+	}
+
+	type story struct {
+		key     string
+		items   []RSSItem
+		sources []*RSS
+	}
+	var stories []*story
+	byKey := make(map[string]*story)
+	for _, rss := range feeds {
+		if rss == nil {
+			continue
+		}
+		for _, it := range rss.Channel.Items {
+			k := key(it)
+			s, ok := byKey[k]
+			if !ok {
+				s = &story{key: k}
+				byKey[k] = s
+				stories = append(stories, s)
+			}
+			s.items = append(s.items, it)
+			s.sources = append(s.sources, rss)
+		}
+	}
+
+	dates := make([]time.Time, len(stories))
+	for i, s := range stories {
+		dates[i] = s.items[0].EffectiveDate()
+	}
+	idx := make([]int, len(stories))
+	for i := range idx {
+		idx[i] = i
+	}
+	sort.SliceStable(idx, func(i, j int) bool { return dates[idx[i]].After(dates[idx[j]]) })
+
+	var r MergeResult
+	r.Items = make([]RSSItem, len(stories))
+	for i, j := range idx {
+		s := stories[j]
+		r.Items[i] = s.items[0]
+		if len(s.items) > 1 {
+			r.Duplicates = append(r.Duplicates, DuplicateGroup{Key: s.key, Items: s.items, Sources: s.sources})
+		}
+	}
+	return r
+}
@@ -0,0 +1,30 @@
+// Copyright 2018 cotox. All rights reserved.
+// Use of this source code is governed by a GPLv3
+// license that can be found in the LICENSE file.
+
+package rssutil
+
+import "time"
+
+// FeedMetrics describes an Update, see RSS.OnMetrics.
+type FeedMetrics struct {
+	// Duration is how long fetching and decoding the feed took.
+	Duration time.Duration
+
+	// Bytes is the size of the fetched document, after any content
+	// encoding was removed. It is zero when nothing was fetched.
+	Bytes int
+
+	// Status is the HTTP status code the server answered with, or zero
+	// for a feed read from a file or when no answer came.
+	Status int
+
+	// NewItems and UpdatedItems count the items returned by Refresh.
+	// RemovedItems counts the items that are no longer in the feed.
+	NewItems     int
+	UpdatedItems int
+	RemovedItems int
+
+	// Err is the error Update returned, if any.
+	Err error
+}
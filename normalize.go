@@ -0,0 +1,48 @@
+// Copyright 2018 cotox. All rights reserved.
+// Use of this source code is governed by a GPLv3
+// license that can be found in the LICENSE file.
+
+package rssutil
+
+import (
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// quoteReplacer turns typographic quotes into ASCII ones.
+var quoteReplacer = strings.NewReplacer(
+	"‘", "'", "’", "'", "‚", "'", "‛", "'",
+	"“", "\"", "”", "\"", "„", "\"", "‟", "\"",
+)
+
+// NormalizedTitle returns the title of it in a form fit for comparing and
+// sorting titles across feeds: typographic quotes become ASCII ones,
+// zero-width and control characters are removed and runs of whitespace of
+// any kind, non-breaking spaces included, become a single space. The
+// result is trimmed and in Unicode NFC, letters followed by combining
+// accents being composed into single characters.
+func (it RSSItem) NormalizedTitle() string {
+	s := quoteReplacer.Replace(it.Title)
+
+	out := make([]rune, 0, len(s))
+	space := false
+	for _, r := range s {
+		switch {
+		case unicode.IsSpace(r):
+			space = len(out) > 0
+			continue
+		case r == '\u200b', r == '\u200c', r == '\u200d', r == '\u2060', r == '\ufeff',
+			unicode.IsControl(r):
+			continue
+		}
+
+		if space {
+			out = append(out, ' ')
+			space = false
+		}
+		out = append(out, r)
+	}
+	return norm.NFC.String(string(out))
+}
@@ -0,0 +1,135 @@
+// Copyright 2018 cotox. All rights reserved.
+// Use of this source code is governed by a GPLv3
+// license that can be found in the LICENSE file.
+
+package rssutil
+
+import (
+	"errors"
+	"time"
+)
+
+// DefaultMaxFeedBytes is the MaxFeedBytes used by DefaultFeedOptions.
+const DefaultMaxFeedBytes = 10 << 20
+
+// DefaultMaxDepth and DefaultMaxTokens are the MaxDepth and MaxTokens
+// used by DefaultFeedOptions. Real feeds nest a handful of levels deep
+// and hold a few tokens per item.
+const (
+	DefaultMaxDepth  = 64
+	DefaultMaxTokens = 1 << 20
+)
+
+// ErrFeedTooLarge is returned when a feed document, or the text decoded
+// from it, exceeds FeedOptions.MaxFeedBytes.
+var ErrFeedTooLarge = errors.New("feed exceeds MaxFeedBytes")
+
+// ErrFeedTooDeep is returned when the elements of a feed document nest
+// deeper than FeedOptions.MaxDepth.
+var ErrFeedTooDeep = errors.New("feed exceeds MaxDepth")
+
+// ErrFeedTooComplex is returned when a feed document holds more than
+// FeedOptions.MaxTokens tokens.
+var ErrFeedTooComplex = errors.New("feed exceeds MaxTokens")
+
+// FeedOptions controls how Feed and friends decode a document.
+//
+// The zero value is not the default; start from DefaultFeedOptions and
+// adjust the fields you care about.
+type FeedOptions struct {
+	// Strict requires the document to be well-formed XML. When Strict is
+	// false the decoder closes unclosed HTML-style tags automatically and
+	// recognizes the HTML entity table (xml.HTMLEntity).
+	//
+	// The entity table is fixed. Entities declared in a DTD are never
+	// honored and replacement text is never re-parsed, so a document
+	// can't define recursive or self-expanding entities.
+	Strict bool
+
+	// MaxFeedBytes limits the size of the raw document and, separately,
+	// the total size of the text decoded from it (character data,
+	// attribute values, comments and directives), so entity replacement
+	// can't inflate a small document into a huge one. Zero means no
+	// limit.
+	MaxFeedBytes int64
+
+	// MaxDepth limits how deep elements may nest, and MaxTokens the
+	// number of tokens (elements, text runs, comments...) of the
+	// document, bounding the work spent on a hostile document that stays
+	// within MaxFeedBytes. Zero means no limit.
+	MaxDepth  int
+	MaxTokens int
+
+	// ItemTransform, if not nil, is called with every item as soon as it
+	// has been decoded and trimmed, before the next one is read. It may
+	// rewrite the item in place; returning false drops the item.
+	ItemTransform func(*RSSItem) bool
+
+	// DefaultLocation is the location of dates that carry no zone, such
+	// as "Mon, 02 Jan 2006 15:04:05". Nil means UTC.
+	DefaultLocation *time.Location
+
+	// DuplicateGUIDs says what to do with an item whose GUID was already
+	// used by an earlier item of the same document. A warning is logged
+	// whichever policy is used.
+	DuplicateGUIDs DuplicateGUIDPolicy
+
+	// Trim says which text elements have the spaces, tabs and newlines
+	// around them trimmed. Keep TrimTitle but leave TrimDescription out
+	// for feeds whose descriptions hold preformatted text, such as code
+	// snippets, that must stay byte-exact. URLs are always trimmed.
+	Trim TrimFields
+
+	// MaxItems, when not zero, keeps only the first MaxItems items of the
+	// document, which are the newest in the usual newest-first feed; the
+	// later <item> elements are skipped without being decoded. Items
+	// dropped by ItemTransform or DuplicateGUIDs don't count.
+	MaxItems int
+}
+
+// DuplicateGUIDPolicy is how Feed handles items sharing a GUID, which
+// some generators emit although GUIDs are meant to be unique.
+type DuplicateGUIDPolicy int
+
+const (
+	// KeepDuplicates keeps every item.
+	KeepDuplicates DuplicateGUIDPolicy = iota
+
+	// KeepFirstGUID keeps the first item with a given GUID and drops
+	// the later ones.
+	KeepFirstGUID
+
+	// KeepNewestGUID keeps, in the place of the first item with a given
+	// GUID, the one with the most recent pubDate. Among equally dated
+	// items the first wins.
+	KeepNewestGUID
+)
+
+// TrimFields is a set of the text elements Feed trims.
+type TrimFields uint
+
+const (
+	// TrimTitle trims the titles of the channel, its items, image and
+	// text input.
+	TrimTitle TrimFields = 1 << iota
+
+	// TrimDescription trims the descriptions of the channel, its items,
+	// image and text input, and the <content:encoded> of items.
+	TrimDescription
+
+	// TrimOther trims every other text element: authors, categories,
+	// GUIDs, copyright and the like.
+	TrimOther
+
+	// TrimAll trims every text element.
+	TrimAll = TrimTitle | TrimDescription | TrimOther
+)
+
+// DefaultFeedOptions is used by Feed, FeedFromFile and FeedFromURL.
+var DefaultFeedOptions = FeedOptions{
+	Strict:       true,
+	MaxFeedBytes: DefaultMaxFeedBytes,
+	MaxDepth:     DefaultMaxDepth,
+	MaxTokens:    DefaultMaxTokens,
+	Trim:         TrimAll,
+}
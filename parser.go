@@ -0,0 +1,46 @@
+// Copyright 2018 cotox. All rights reserved.
+// Use of this source code is governed by a GPLv3
+// license that can be found in the LICENSE file.
+
+package rssutil
+
+import "sync"
+
+// Parser decodes a feed format other than RSS into an RSS value.
+// Register one with RegisterParser to teach Feed a new format.
+type Parser interface {
+	// CanParse reports whether b looks like a document in the parser's
+	// format. It should sniff cheaply rather than parse b.
+	CanParse(b []byte) bool
+
+	// Parse decodes b.
+	Parse(b []byte) (*RSS, error)
+}
+
+var (
+	parsersMu sync.RWMutex
+	parsers   []Parser
+)
+
+// RegisterParser adds p to the parsers consulted by Feed and the other
+// Feed functions, after those registered before it. The first parser
+// whose CanParse accepts a document decodes it; documents no parser
+// accepts are decoded as RSS.
+func RegisterParser(p Parser) {
+	parsersMu.Lock()
+	parsers = append(parsers, p)
+	parsersMu.Unlock()
+}
+
+// parserFor returns the registered parser for b, or nil if b should be
+// decoded as RSS.
+func parserFor(b []byte) Parser {
+	parsersMu.RLock()
+	defer parsersMu.RUnlock()
+	for _, p := range parsers {
+		if p.CanParse(b) {
+			return p
+		}
+	}
+	return nil
+}
@@ -0,0 +1,304 @@
+// Copyright 2018 cotox. All rights reserved.
+// Use of this source code is governed by a GPLv3
+// license that can be found in the LICENSE file.
+
+package rssutil
+
+import (
+	"math"
+	"net/mail"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ITunesChannel holds the iTunes podcast elements of a channel, as they
+// appear in the feed.
+type ITunesChannel struct {
+	// Author is the <itunes:author> of the channel.
+	Author string `json:"author,omitempty"`
+
+	// Subtitle and Summary are the <itunes:subtitle> and <itunes:summary>
+	// of the channel, a short and a long description of the show.
+	Subtitle string `json:"subtitle,omitempty"`
+	Summary  string `json:"summary,omitempty"`
+
+	// Image is the URL of the <itunes:image> of the channel, its artwork.
+	Image string `json:"image,omitempty"`
+
+	// Categories are the <itunes:category> names of the channel, a
+	// subcategory being given as "Parent/Child" after its parent.
+	Categories []string `json:"categories,omitempty"`
+
+	// Explicit is the <itunes:explicit> of the channel, such as "yes",
+	// "no", "true" or "clean".
+	Explicit string `json:"explicit,omitempty"`
+
+	// OwnerName and OwnerEmail are the <itunes:name> and <itunes:email>
+	// of the <itunes:owner> of the channel, who the directories contact.
+	OwnerName  string `json:"ownerName,omitempty"`
+	OwnerEmail string `json:"ownerEmail,omitempty"`
+}
+
+func (c ITunesChannel) String() string {
+	var a []string
+	if c.Author != "" {
+		a = append(a, "Author: \""+c.Author+"\"")
+	}
+	if c.Subtitle != "" {
+		a = append(a, "Subtitle: \""+c.Subtitle+"\"")
+	}
+	if c.Summary != "" {
+		a = append(a, "Summary: \""+c.Summary+"\"")
+	}
+	if c.Image != "" {
+		a = append(a, "Image: \""+c.Image+"\"")
+	}
+	if c.Categories != nil {
+		a = append(a, "Categories: [\""+strings.Join(c.Categories, "\", \"")+"\"]")
+	}
+	if c.Explicit != "" {
+		a = append(a, "Explicit: \""+c.Explicit+"\"")
+	}
+	if c.OwnerName != "" {
+		a = append(a, "OwnerName: \""+c.OwnerName+"\"")
+	}
+	if c.OwnerEmail != "" {
+		a = append(a, "OwnerEmail: \""+c.OwnerEmail+"\"")
+	}
+	return strings.Join(a, ", ")
+}
+
+// ITunesItem holds the iTunes podcast elements of an item, as they appear
+// in the feed.
+type ITunesItem struct {
+	// Author is the <itunes:author> of the episode.
+	Author string `json:"author,omitempty"`
+
+	// Subtitle and Summary are the <itunes:subtitle> and <itunes:summary>
+	// of the episode.
+	Subtitle string `json:"subtitle,omitempty"`
+	Summary  string `json:"summary,omitempty"`
+
+	// Image is the URL of the <itunes:image> of the episode.
+	Image string `json:"image,omitempty"`
+
+	// Duration is the <itunes:duration> of the episode, given in seconds
+	// or as "HH:MM:SS" or "MM:SS"; it is 0 when missing or malformed.
+	Duration time.Duration `json:"duration,omitempty"`
+
+	// Episode and Season are the numbers of the episode.
+	Episode string `json:"episode,omitempty"`
+	Season  string `json:"season,omitempty"`
+
+	// EpisodeType is "full", "trailer" or "bonus".
+	EpisodeType string `json:"episodeType,omitempty"`
+
+	// Explicit is the <itunes:explicit> of the episode.
+	Explicit string `json:"explicit,omitempty"`
+}
+
+func (it ITunesItem) String() string {
+	var a []string
+	if it.Author != "" {
+		a = append(a, "Author: \""+it.Author+"\"")
+	}
+	if it.Subtitle != "" {
+		a = append(a, "Subtitle: \""+it.Subtitle+"\"")
+	}
+	if it.Summary != "" {
+		a = append(a, "Summary: \""+it.Summary+"\"")
+	}
+	if it.Image != "" {
+		a = append(a, "Image: \""+it.Image+"\"")
+	}
+	if it.Duration != 0 {
+		a = append(a, "Duration: "+it.Duration.String())
+	}
+	if it.Episode != "" {
+		a = append(a, "Episode: \""+it.Episode+"\"")
+	}
+	if it.Season != "" {
+		a = append(a, "Season: \""+it.Season+"\"")
+	}
+	if it.EpisodeType != "" {
+		a = append(a, "EpisodeType: \""+it.EpisodeType+"\"")
+	}
+	if it.Explicit != "" {
+		a = append(a, "Explicit: \""+it.Explicit+"\"")
+	}
+	return strings.Join(a, ", ")
+}
+
+// Podcast is the podcast view of a feed returned by RSS.Podcast, with the
+// iTunes elements parsed.
+type Podcast struct {
+	Author     string
+	Categories []string
+	Explicit   bool
+	Episodes   []Episode
+}
+
+// Episode is an item of a Podcast.
+type Episode struct {
+	// Item is the item of the feed.
+	Item *RSSItem
+
+	// Audio is the audio file of the episode.
+	Audio *RSSEnclosure
+
+	// Duration is 0 when the feed doesn't give it.
+	Duration time.Duration
+
+	// Number and Season are 0 when the feed doesn't give them.
+	Number int
+	Season int
+
+	// Explicit is that of the channel unless the item says otherwise.
+	Explicit bool
+}
+
+// audioExts are the extensions of audio files, for media objects that
+// don't give their type.
+var audioExts = map[string]bool{
+	".mp3": true, ".m4a": true, ".aac": true, ".ogg": true,
+	".oga": true, ".opus": true, ".wav": true, ".flac": true,
+}
+
+// IsPodcast reports whether rss is a podcast, that is whether some of its
+// items carry audio. The iTunes elements alone don't make a podcast, as
+// blog platforms add them to every feed.
+func (rss *RSS) IsPodcast() bool {
+	for i := range rss.Channel.Items {
+		if audio(rss.Channel.Items[i]) != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// Podcast returns rss as a podcast, or nil if it isn't one. Its episodes
+// are the items that carry audio, in feed order; they refer to the items
+// of rss.Channel. The author is that of the iTunes elements, or else the
+// managing editor.
+func (rss *RSS) Podcast() *Podcast {
+	if !rss.IsPodcast() {
+		return nil
+	}
+
+	ch := rss.Channel
+	p := &Podcast{Author: ch.ManagingEditor}
+	if ch.ITunes != nil {
+		if ch.ITunes.Author != "" {
+			p.Author = ch.ITunes.Author
+		}
+		p.Categories = ch.ITunes.Categories
+		p.Explicit = isExplicit(ch.ITunes.Explicit)
+	}
+
+	for i := range rss.Channel.Items {
+		it := &rss.Channel.Items[i]
+		m := audio(*it)
+		if m == nil {
+			continue
+		}
+		ep := Episode{Item: it, Audio: m, Explicit: p.Explicit}
+		if it.ITunes != nil {
+			ep.Duration = it.ITunes.Duration
+			ep.Number, _ = strconv.Atoi(it.ITunes.Episode)
+			ep.Season, _ = strconv.Atoi(it.ITunes.Season)
+			if it.ITunes.Explicit != "" {
+				ep.Explicit = isExplicit(it.ITunes.Explicit)
+			}
+		}
+		p.Episodes = append(p.Episodes, ep)
+	}
+	return p
+}
+
+// Owner returns who owns rss: the <itunes:owner> of the channel, or else
+// its managing editor, or else its webmaster. Those are written as
+// "email (Name)" by convention, and sometimes as "Name <email>", a bare
+// address or a bare name; either result is "" when not given, both when
+// nothing is found.
+func (rss *RSS) Owner() (name, email string) {
+	ch := rss.Channel
+	if ch.ITunes != nil && (ch.ITunes.OwnerName != "" || ch.ITunes.OwnerEmail != "") {
+		return ch.ITunes.OwnerName, ch.ITunes.OwnerEmail
+	}
+	for _, v := range []string{ch.ManagingEditor, ch.WebMaster} {
+		if name, email = parseContact(v); name != "" || email != "" {
+			return name, email
+		}
+	}
+	return "", ""
+}
+
+// rssContactRE matches the "email (Name)" form of RSS contacts.
+var rssContactRE = regexp.MustCompile(`^(\S+@\S+)\s*\((.*)\)$`)
+
+// parseContact splits a contact written as Owner describes into a name
+// and an email address.
+func parseContact(v string) (name, email string) {
+	v = strings.TrimSpace(v)
+	if m := rssContactRE.FindStringSubmatch(v); m != nil {
+		return strings.TrimSpace(m[2]), m[1]
+	}
+	if a, err := mail.ParseAddress(v); err == nil {
+		return a.Name, a.Address
+	}
+	if strings.Contains(v, "@") && !strings.ContainsAny(v, " \t") {
+		return "", v
+	}
+	return v, ""
+}
+
+// audio returns the best audio media object of it, or nil if it has none.
+func audio(it RSSItem) *RSSEnclosure {
+	m := it.BestMedia("audio/")
+	if m == nil {
+		return nil
+	}
+	if t := strings.TrimSpace(m.Type); t != "" {
+		if mediaRank(t, []string{"audio/"}) != 0 {
+			return nil
+		}
+	} else if !audioExts[strings.ToLower(path.Ext(lastPathSegment(m.URL)))] {
+		return nil
+	}
+	return m
+}
+
+// isExplicit reports whether an <itunes:explicit> value marks explicit
+// content.
+func isExplicit(v string) bool {
+	switch strings.ToLower(strings.TrimSpace(v)) {
+	case "yes", "true", "explicit":
+		return true
+	}
+	return false
+}
+
+// parseDuration parses an <itunes:duration>, given in seconds or as
+// "HH:MM:SS" or "MM:SS", returning 0 if it is malformed or too long for
+// a time.Duration.
+func parseDuration(v string) time.Duration {
+	fields := strings.Split(strings.TrimSpace(v), ":")
+	if len(fields) > 3 {
+		return 0
+	}
+	var secs float64
+	for _, f := range fields {
+		n, err := strconv.ParseFloat(f, 64)
+		if err != nil || n < 0 || math.IsNaN(n) || math.IsInf(n, 0) {
+			return 0
+		}
+		secs = secs*60 + n
+	}
+	if secs >= math.MaxInt64/float64(time.Second) {
+		return 0
+	}
+	return time.Duration(secs * float64(time.Second))
+}
@@ -0,0 +1,141 @@
+// Copyright 2018 cotox. All rights reserved.
+// Use of this source code is governed by a GPLv3
+// license that can be found in the LICENSE file.
+
+package rssutil
+
+import (
+	"html"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// previewSummaryLen is the length in characters summaries are cut to by
+// Preview.
+const previewSummaryLen = 280
+
+// htmlImageRE matches the src attribute of HTML <img> tags.
+var htmlImageRE = regexp.MustCompile(`(?i)<img\s[^>]*?\bsrc\s*=\s*(?:"([^"]*)"|'([^']*)'|([^\s"'>]+))`)
+
+// FeedPreview is a feed reduced to what a user interface shows, made safe
+// to display even when the feed comes from an untrusted source. See
+// RSS.Preview.
+type FeedPreview struct {
+	Title       string        `json:"title"`
+	Description string        `json:"description,omitempty"`
+	Link        string        `json:"link,omitempty"`
+	Image       string        `json:"image,omitempty"`
+	Items       []ItemPreview `json:"items"`
+}
+
+// ItemPreview is an item of a FeedPreview.
+type ItemPreview struct {
+	Title     string     `json:"title"`
+	Summary   string     `json:"summary,omitempty"`
+	Link      string     `json:"link,omitempty"`
+	Thumbnail string     `json:"thumbnail,omitempty"`
+	Date      *time.Time `json:"date,omitempty"`
+}
+
+// Preview returns the maxItems newest items of rss, or all of them if
+// maxItems is not positive, in a form ready to be rendered:
+//
+//   - titles and descriptions are plain text, stripped of markup and
+//     entities, so they can't inject HTML; descriptions are cut to a
+//     summary, and an item without a title gets the beginning of it;
+//   - links and images are absolute http or https URLs, relative ones
+//     being resolved against the channel link; any other URL, such as a
+//     javascript: one, is left out;
+//   - the thumbnail of an item is its first image media, or else the first
+//     image of its description;
+//   - dates are the EffectiveDate of items, nil when they aren't dated.
+//
+// Items are ordered by EffectiveDate, undated ones last. rss itself is
+// never modified.
+func (rss *RSS) Preview(maxItems int) FeedPreview {
+	ch := rss.Channel
+	base := httpURL(ch.Link)
+
+	p := FeedPreview{
+		Title:       plainText(ch.Title),
+		Description: plainText(ch.Description),
+		Link:        previewURL(base, ch.Link),
+		Items:       []ItemPreview{},
+	}
+	if ch.Image != nil {
+		p.Image = previewURL(base, ch.Image.URL)
+	}
+
+	items := make([]RSSItem, len(ch.Items))
+	copy(items, ch.Items)
+	sort.SliceStable(items, func(i, j int) bool {
+		return items[i].EffectiveDate().After(items[j].EffectiveDate())
+	})
+	if maxItems > 0 && len(items) > maxItems {
+		items = items[:maxItems]
+	}
+	for _, it := range items {
+		ip := ItemPreview{
+			Title:     plainText(it.Title),
+			Summary:   truncateText(plainText(it.Description), previewSummaryLen),
+			Link:      previewURL(base, it.Link),
+			Thumbnail: thumbnail(base, it),
+		}
+		if ip.Title == "" {
+			ip.Title = truncateText(ip.Summary, digestTitleLen)
+		}
+		if ip.Link == "" {
+			ip.Link = it.CanonicalURL()
+		}
+		if d := it.EffectiveDate(); !d.IsZero() {
+			ip.Date = &d
+		}
+		p.Items = append(p.Items, ip)
+	}
+	return p
+}
+
+// thumbnail returns the URL of the image to show next to it, or "".
+func thumbnail(base *url.URL, it RSSItem) string {
+	media := it.Media
+	if len(media) == 0 && it.Enclosure != nil {
+		media = []RSSEnclosure{*it.Enclosure}
+	}
+	for _, m := range media {
+		if mediaRank(m.Type, []string{"image/"}) == 0 {
+			if u := previewURL(base, m.URL); u != "" {
+				return u
+			}
+		}
+	}
+
+	for _, m := range htmlImageRE.FindAllStringSubmatch(it.Description, -1) {
+		if u := previewURL(base, html.UnescapeString(m[1]+m[2]+m[3])); u != "" {
+			return u
+		}
+	}
+	return ""
+}
+
+// previewURL returns ref resolved against base if it is then an absolute
+// http or https URL, or "" otherwise. base may be nil.
+func previewURL(base *url.URL, ref string) string {
+	ref = strings.TrimSpace(ref)
+	if ref == "" {
+		return ""
+	}
+	if base != nil {
+		u, err := base.Parse(ref)
+		if err != nil {
+			return ""
+		}
+		ref = u.String()
+	}
+	if u := httpURL(ref); u != nil {
+		return u.String()
+	}
+	return ""
+}
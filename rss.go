@@ -6,55 +6,212 @@ package rssutil
 
 import (
 	"bytes"
-	"encoding/xml"
-	"fmt"
+	"context"
+	"errors"
 	"io/ioutil"
 	"net/http"
+	"os"
 	"strings"
+	"sync"
 	"time"
 )
 
 const DefaultTTL = 20 * time.Minute
 
-var stopServe = make(chan struct{})
+// ErrServing is returned by Serve and the other serving methods when rss
+// is already being served.
+var ErrServing = errors.New("rss is already being served")
+
+// ErrFeedIdentityChanged is returned by Update when the refetched feed has
+// both another title and another link than rss, which happens when its URL
+// has been taken over by an unrelated feed. rss is left unchanged; read the
+// feed anew to accept it.
+var ErrFeedIdentityChanged = errors.New("feed title and link changed")
+
+// ErrNoReloadableSource is returned by Update when rss was decoded from
+// bytes rather than read from a file or URL.
+var ErrNoReloadableSource = errors.New("rss has no file or URL to reload from")
 
 // Feed creates RSS implementation from binary and return.
+//
+// Documents that are something else than RSS, such as Atom feeds or OPML
+// lists, fail with a *FormatError, unless a registered Parser reads them.
+// Atom feeds can be read with AtomFeed instead.
 func Feed(b []byte) (rss *RSS, err error) {
-	logTrace("feed()")
+	return FeedWithOptions(b, DefaultFeedOptions)
+}
 
+// FeedWithOptions creates RSS implementation from binary decoded
+// according to opts and return.
+func FeedWithOptions(b []byte, opts FeedOptions) (rss *RSS, err error) {
 	rss = new(RSS)
-	decoder := xml.NewDecoder(bytes.NewBuffer(b))
-	if err := decoder.Decode(rss); err != nil {
-		logErr(err)
+	if err := feedInto(b, rss, opts); err != nil {
 		return nil, err
 	}
+	return rss, nil
+}
 
-	// Trim elements in string type.
-	const cutset = " \t\n"
-	rss.Channel.Title = strings.Trim(rss.Channel.Title, cutset)
-	rss.Channel.Description = strings.Trim(rss.Channel.Description, cutset)
-	rss.Channel.Copyright = strings.Trim(rss.Channel.Copyright, cutset)
-	for i := range rss.Channel.Items {
-		item := &rss.Channel.Items[i]
-		item.Title = strings.Trim(item.Title, cutset)
-		item.Description = strings.Trim(item.Description, cutset)
+// FeedLenient is like Feed but, should b fail to decode or not start as
+// a feed, tries again more leniently: with Strict unset, so HTML entities
+// and unclosed tags are accepted, and with the junk some servers put
+// around the document, such as PHP warnings printed before it, cut off.
+// lenient reports whether the second attempt was needed, which tells the
+// feeds to report as malformed. The error is that of the second attempt.
+//
+// Documents that are something else than RSS, such as HTML pages, Atom
+// feeds or OPML lists, fail with a *FormatError rather than decode as
+// empty feeds, unless a registered Parser reads them.
+func FeedLenient(b []byte) (rss *RSS, lenient bool, err error) {
+	if f := documentFormat(b, ""); f == "rss" || f == "rdf" || parserFor(b) != nil {
+		if rss, err = Feed(b); err == nil {
+			return rss, false, nil
+		}
+	} else if f := documentFormat(trimJunk(b), ""); f != "rss" && f != "rdf" && f != "" {
+		// A format that can't be told is left to the lenient attempt.
+		err := &FormatError{Format: f}
+		logErr(err)
+		return nil, false, err
+	}
+	opts := DefaultFeedOptions
+	opts.Strict = false
+	if rss, err = FeedWithOptions(trimJunk(b), opts); err != nil {
+		return nil, true, err
+	}
+	return rss, true, nil
+}
+
+// trimJunk returns b without what comes before the XML declaration, or
+// before the first tag if there is none, and after the last tag.
+func trimJunk(b []byte) []byte {
+	if i := bytes.Index(b, []byte("<?xml")); i > 0 {
+		b = b[i:]
+	} else if i := bytes.IndexByte(b, '<'); i > 0 {
+		b = b[i:]
+	}
+	if i := bytes.LastIndexByte(b, '>'); i >= 0 {
+		b = b[:i+1]
+	}
+	return b
+}
+
+// FeedInto is like Feed but decodes b into an existing RSS, reusing the
+// memory of its item list once emptied by Reset. It replaces the version
+// and channel of rss; call Reset first to also clear its source and
+// notifiers. On error rss is left unchanged.
+func FeedInto(b []byte, rss *RSS) error {
+	return feedInto(b, rss, DefaultFeedOptions)
+}
+
+func feedInto(b []byte, rss *RSS, opts FeedOptions) error {
+	logTrace("feed()")
+
+	if opts.MaxFeedBytes > 0 && int64(len(b)) > opts.MaxFeedBytes {
+		logErr(ErrFeedTooLarge)
+		return ErrFeedTooLarge
 	}
 
+	if p := parserFor(b); p != nil {
+		parsed, err := p.Parse(b)
+		if err != nil {
+			logErr(err)
+			return err
+		}
+		rss.Version = parsed.Version
+		rss.Channel = parsed.Channel
+		rss.origin = b
+		rss.lastUpdateAt = time.Now()
+		return nil
+	}
+	if f := documentFormat(b, ""); f != "rss" && f != "rdf" {
+		err := &FormatError{Format: f}
+		logErr(err)
+		return err
+	}
+
+	var doc rssDocument
+	doc.Channel.setOptions(&opts)
+	// Decode into the spare capacity of the item list, which is all of it
+	// after Reset, so the current items survive a failed decode.
+	items := rss.Channel.Items
+	doc.Channel.Items.items = items[len(items):len(items)]
+	decoder := newDecoder(bytes.NewBuffer(b), opts)
+	if err := decoder.Decode(&doc); err != nil {
+		logErr(err)
+		return err
+	}
+
+	rss.Version = doc.version()
+	rss.Channel = doc.Channel.channel()
+
 	rss.origin = b
 
 	rss.lastUpdateAt = time.Now()
 
-	return rss, nil
+	return nil
+}
+
+// FormatError is returned by FeedFromFile and FeedLenient for a document
+// that isn't an RSS feed, and by FetchFeed for one that is neither RSS
+// nor Atom.
+type FormatError struct {
+	// Filename is the file or URL the document was read from, if any.
+	Filename string
+
+	// Format is what the file is instead: "atom", "opml", "jsonfeed",
+	// "html", the root element of another XML document, or "" if it
+	// can't be told.
+	Format string
+}
+
+func (e *FormatError) Error() string {
+	name := e.Filename
+	if name == "" {
+		name = "document"
+	}
+	switch e.Format {
+	case "":
+		return name + ": not a feed"
+	case "opml":
+		return name + ": an OPML subscription list, not a feed"
+	case "atom":
+		return name + ": an Atom feed, not RSS"
+	case "jsonfeed":
+		return name + ": a JSON Feed, not RSS"
+	case "html":
+		return name + ": an HTML page, not a feed"
+	}
+	return name + ": a <" + e.Format + "> document, not a feed"
 }
 
 // FeedFromFile creates RSS implementation from specific file and return.
+//
+// Files that are not RSS, such as OPML lists or Atom and JSON feeds, fail
+// with a *FormatError, unless a registered Parser reads them. Atom feeds
+// can be read with AtomFeed instead.
 func FeedFromFile(filename string) (rss *RSS, err error) {
+	// Stat first so that a change made while reading is seen by the next
+	// update.
+	fi, err := os.Stat(filename)
+	if err != nil {
+		logErr(err)
+		return nil, err
+	}
 	b, err := ioutil.ReadFile(filename)
 	if err != nil {
 		logErr(err)
 		return nil, err
 	}
 
+	if parserFor(b) == nil {
+		switch format := documentFormat(b, filename); format {
+		case "rss", "rdf":
+		default:
+			err := &FormatError{Filename: filename, Format: format}
+			logErr(err)
+			return nil, err
+		}
+	}
+
 	rss, err = Feed(b)
 	if err != nil {
 		logErr(err)
@@ -62,122 +219,475 @@ func FeedFromFile(filename string) (rss *RSS, err error) {
 	}
 
 	rss.source = filename
+	rss.sourceKind = SourceFile
+	rss.modTime = fi.ModTime()
 
 	return rss, nil
 }
 
 // FeedFromURL creates RSS implementation from specific URL and return.
 func FeedFromURL(url string) (rss *RSS, err error) {
-	resp, err := http.Get(url)
-	if resp != nil {
-		defer resp.Body.Close()
-	}
+	return FeedFromURLContext(context.Background(), url)
+}
+
+// FeedFromURLContext is like FeedFromURL but fetches the feed with ctx:
+// canceling ctx, or its deadline passing, aborts the request, reading the
+// response body included, and FeedFromURLContext fails with the error of
+// ctx. Later updates aren't bound to ctx.
+func FeedFromURLContext(ctx context.Context, url string) (rss *RSS, err error) {
+	return feedFromURL(ctx, url, http.DefaultClient)
+}
+
+// FeedFromURLWithClient is like FeedFromURL but sends the request, and
+// those of later updates, with client. Give it a client whose Transport
+// caches responses to add HTTP caching: the request is a plain GET
+// without a body, which RFC 7234 caches store and revalidate.
+func FeedFromURLWithClient(url string, client *http.Client) (rss *RSS, err error) {
+	return feedFromURL(context.Background(), url, client)
+}
+
+// feedFromURL fetches the feed at url with ctx and client, which it keeps
+// for later updates.
+func feedFromURL(ctx context.Context, url string, client *http.Client) (rss *RSS, err error) {
+	rss, err = FetchFeed(ctx, url, FetchOptions{Client: client})
 	if err != nil {
-		logErr(err)
 		return nil, err
 	}
+	rss.Client = client
+	return rss, nil
+}
 
-	b, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		logErr(err)
-		return nil, err
+// Update updates RSS content and returns the newer RSSItem list.
+//
+// A feed read from a URL is refetched with a conditional request; if the
+// server answers 304 Not Modified there are no new items. Likewise a file
+// whose modification time didn't change isn't read again.
+//
+// An item is new when no item with the same GUID, or link if it has no
+// GUID, was there before, unless rss.DedupKey says otherwise. Known
+// items whose pubDate moved, as happens when a feed re-dates edited
+// stories, are not reported; use Refresh to get them too. With
+// rss.TrustLastBuildDate set, items aren't compared at all while the
+// lastBuildDate of the channel stays the same.
+func (rss *RSS) Update() (newItems []RSSItem, err error) {
+	newItems, _, err = rss.Refresh()
+	return newItems, err
+}
+
+// Refresh updates RSS content like Update, returning the new items and,
+// separately, the updated ones: items that were there before but whose
+// pubDate changed.
+func (rss *RSS) Refresh() (newItems, updatedItems []RSSItem, err error) {
+	return rss.refresh(context.Background())
+}
+
+// refresh is Refresh, fetching a feed read from a URL with ctx.
+func (rss *RSS) refresh(ctx context.Context) (newItems, updatedItems []RSSItem, err error) {
+	logTrace("rss.Refresh()")
+
+	var m FeedMetrics
+	if rss.OnMetrics != nil {
+		defer func() {
+			m.NewItems, m.UpdatedItems, m.Err = len(newItems), len(updatedItems), err
+			rss.OnMetrics(m)
+		}()
 	}
 
-	rss, err = Feed(b)
+	start := time.Now()
+	rss2, err := rss.fetch(ctx)
+	m.Duration = time.Since(start)
+	if err == ErrNotModified {
+		m.Status = http.StatusNotModified
+		rss.lastUpdateAt = time.Now()
+		return nil, nil, nil
+	}
+	switch e := err.(type) {
+	case *statusError:
+		m.Status = e.code
+	case *RateLimitError:
+		m.Status = e.StatusCode
+	}
 	if err != nil {
-		logErr(err)
-		return nil, err
+		return nil, nil, err
+	}
+	m.Status, m.Bytes = rss2.status, len(rss2.origin)
+	if !rss.sameFeed(rss2) {
+		logErr(ErrFeedIdentityChanged)
+		return nil, nil, ErrFeedIdentityChanged
 	}
 
-	rss.source = url
+	if !rss.sameBuild(rss2) {
+		newItems, updatedItems = rss.compare(rss2.Channel.Items)
+		m.RemovedItems = len(rss.Diff(rss2).Removed)
+		rss.Channel.Items = rss2.Channel.Items
+	}
 
-	return rss, nil
+	rss.Channel.LastBuildDate = rss2.Channel.LastBuildDate
+	rss.etag = rss2.etag
+	rss.lastModified = rss2.lastModified
+	rss.modTime = rss2.modTime
+	rss.movedTo = rss2.MovedTo()
+	rss.maxAge, rss.hasMaxAge = rss2.maxAge, rss2.hasMaxAge
+	rss.lastUpdateAt = time.Now()
+
+	return newItems, updatedItems, nil
 }
 
-// Update updates RSS content and returns the newer RSSItem list.
-func (rss *RSS) Update() (newItems []RSSItem, err error) {
-	logTrace("rss.Update()")
+// Use appends transforms to those run, in order, on every fresh copy of
+// the feed Update and PreviewUpdate fetch, before it is compared with
+// rss. They are how per-feed fixups, such as repairing dates or resolving
+// links, are attached to a feed. An error fails the update.
+//
+// The content rss already holds isn't transformed; run the transforms on
+// rss first if it needs to be, or its items may all look updated.
+func (rss *RSS) Use(transforms ...func(*RSS) error) {
+	rss.transforms = append(rss.transforms, transforms...)
+}
 
-	latestItem := rss.latestItem()
+// PreviewUpdate fetches a fresh copy of the feed and returns the items
+// Update would report as new, without changing rss.
+func (rss *RSS) PreviewUpdate() (newItems []RSSItem, err error) {
+	logTrace("rss.PreviewUpdate()")
 
-	if rss.source == "" {
-		return nil, fmt.Errorf("empty rss.source")
+	rss2, err := rss.fetch(context.Background())
+	if err == ErrNotModified {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !rss.sameFeed(rss2) {
+		return nil, ErrFeedIdentityChanged
+	}
+	if rss.sameBuild(rss2) {
+		return nil, nil
 	}
 
-	var rss2 *RSS
-	if rss.source[:4] == "http" {
-		rss2, err = FeedFromURL(rss.source)
-		if err != nil {
-			logErr(err)
+	newItems, _ = rss.compare(rss2.Channel.Items)
+	return newItems, nil
+}
+
+// sameBuild reports whether rss2 may be taken for the same content as
+// rss without comparing items, as rss.TrustLastBuildDate asks, its
+// channel having the same lastBuildDate.
+func (rss *RSS) sameBuild(rss2 *RSS) bool {
+	return rss.TrustLastBuildDate && hasDate(rss2.Channel.LastBuildDate) &&
+		sameDate(rss.Channel.LastBuildDate, rss2.Channel.LastBuildDate)
+}
+
+// fetch reads a fresh copy of the feed from its source and runs the
+// transforms of rss on it. A feed read from a URL is fetched with ctx,
+// rss.Client and the validators of the last fetch, and a file is only
+// read if its modification time changed, so it fails with ErrNotModified
+// when unchanged.
+func (rss *RSS) fetch(ctx context.Context) (rss2 *RSS, err error) {
+	switch rss.sourceKind {
+	case SourceURL:
+		client := rss.Client
+		if client == nil {
+			client = http.DefaultClient
+		}
+		rss2, err = FetchFeed(ctx, rss.source, FetchOptions{
+			Client:       client,
+			ETag:         rss.etag,
+			LastModified: rss.lastModified,
+		})
+		if err == ErrNotModified {
 			return nil, err
 		}
-	} else {
+	case SourceFile:
+		fi, statErr := os.Stat(rss.source)
+		if statErr == nil && !rss.modTime.IsZero() && fi.ModTime().Equal(rss.modTime) {
+			return nil, ErrNotModified
+		}
 		rss2, err = FeedFromFile(rss.source)
-		if err != nil {
+	default:
+		err = ErrNoReloadableSource
+	}
+	if err != nil {
+		logErr(err)
+		return nil, err
+	}
+	for _, f := range rss.transforms {
+		if err := f(rss2); err != nil {
 			logErr(err)
 			return nil, err
 		}
 	}
-	rss.Channel.Items = rss2.Channel.Items
-	rss.lastUpdateAt = time.Now()
+	return rss2, nil
+}
 
-	if latestItem == nil {
-		return nil, nil
+// sameFeed reports whether rss2 can be a newer copy of rss: it keeps
+// either the title or the link of rss. Titles are compared without regard
+// to case, links regardless of scheme and normalization. A feed with
+// neither a title nor a link matches anything.
+func (rss *RSS) sameFeed(rss2 *RSS) bool {
+	a, b := rss.Channel, rss2.Channel
+	if a.Title == "" && a.Link == "" {
+		return true
 	}
+	return strings.EqualFold(a.Title, b.Title) || feedLinkKey(a.Link) == feedLinkKey(b.Link)
+}
 
-	items := rss.Channel.Items
-	for i := range items {
-		if items[i].PubDate.After(latestItem.PubDate) {
-			newItems = append(newItems, items[i])
-		}
+// feedLinkKey returns link normalized for sameFeed.
+func feedLinkKey(link string) string {
+	u := httpURL(link)
+	if u == nil {
+		return strings.TrimSpace(link)
 	}
+	return strings.TrimPrefix(strings.TrimPrefix(normalizeURL(u), "http:"), "https:")
+}
 
-	return newItems, nil
+// compare returns the items of items that are new to rss and those that
+// are known but were re-dated.
+func (rss *RSS) compare(items []RSSItem) (newItems, updatedItems []RSSItem) {
+	known := make(map[string]*RSSItem)
+	for i := range rss.Channel.Items {
+		known[rss.itemKey(rss.Channel.Items[i])] = &rss.Channel.Items[i]
+	}
+
+	for _, it := range items {
+		prev, ok := known[rss.itemKey(it)]
+		switch {
+		case !ok:
+			newItems = append(newItems, it)
+		case !sameDate(prev.PubDate, it.PubDate):
+			updatedItems = append(updatedItems, it)
+		}
+	}
+	return newItems, updatedItems
 }
 
 // Serve updated RSS content in background automatically.
 // And calls registered RSSUpdateNotifiers when new RSSItems come.
 //
 // The RSS content will update every ttl minutes. If ttl is 0, it tries
-// to use TTL specified in RSSChannel, then DefaultTTL if RSSChannel.TTL
-// is not specified.
+// to follow the feed's own publishing rate if rss.AdaptiveTTL is set,
+// then to use TTL specified in RSSChannel, in rss.TTLUnit, then
+// DefaultTTL if RSSChannel.TTL is not specified. The result is bounded
+// by rss.MinTTL and rss.MaxTTL, then rss.TTLSkew is added to it. When
+// the server throttles updates with a RateLimitError, the next update
+// waits for as long as it asks instead, and serving goes on.
 func (rss *RSS) Serve(ttl time.Duration) error {
-	if ttl == 0 {
-		if rss.Channel.TTL > 0 {
-			ttl = time.Duration(rss.Channel.TTL) * time.Minute
-		} else {
-			ttl = DefaultTTL
+	return rss.ServeWithOptions(ttl, ServeOptions{})
+}
+
+// ServeContext is like Serve but also returns when ctx is done, with the
+// error of ctx. The updates are made with ctx, so canceling it aborts one
+// in progress too.
+func (rss *RSS) ServeContext(ctx context.Context, ttl time.Duration) error {
+	return rss.serve(ctx, ttl, nil, rss.updateAndNotify)
+}
+
+// ServeOptions controls how ServeWithOptions starts serving.
+type ServeOptions struct {
+	// NotifyExisting calls the notifiers with the items the feed already
+	// has before waiting for the first update. By default they only hear
+	// of items that come later.
+	NotifyExisting bool
+}
+
+// ServeWithOptions is like Serve, starting according to opts.
+func (rss *RSS) ServeWithOptions(ttl time.Duration, opts ServeOptions) error {
+	return rss.serve(context.Background(), ttl, rss.startFunc(opts), rss.updateAndNotify)
+}
+
+// startFunc returns what ServeWithOptions calls as it starts serving
+// according to opts, or nil if nothing.
+func (rss *RSS) startFunc(opts ServeOptions) func() {
+	if !opts.NotifyExisting || rss.Channel.Items == nil {
+		return nil
+	}
+	return func() {
+		for _, f := range rss.rssUpdateNotifiers {
+			go f(rss.Channel.Items)
+		}
+	}
+}
+
+// ServeWithSeen serves rss like Serve, but tells of items by identity
+// rather than by date: onNew is called with the items whose GUIDs aren't
+// in seen, then with seen holding them too, so the caller can persist it
+// and pass it back on the next run. Items without a GUID are known by
+// their link, or else by their title and description, unless
+// rss.DedupKey is set. Feeds without
+// dates or that re-date their items are thus followed reliably.
+//
+// seen may be nil. onNew is called right away with the items rss already
+// has that it lists, then after every update that brings some; the next
+// update waits for it to return. The notifiers registered with
+// RegisterRSSUpdateNotifier are not called.
+func (rss *RSS) ServeWithSeen(ttl time.Duration, seen map[string]bool, onNew func([]RSSItem, map[string]bool)) error {
+	if seen == nil {
+		seen = make(map[string]bool)
+	}
+	notify := func() {
+		var items []RSSItem
+		for _, it := range rss.Channel.Items {
+			if key := rss.itemKey(it); !seen[key] {
+				seen[key] = true
+				items = append(items, it)
+			}
 		}
+		if items != nil {
+			onNew(items, seen)
+		}
+	}
+
+	return rss.serve(context.Background(), ttl, notify, func(ctx context.Context) error {
+		if _, _, err := rss.refresh(ctx); err != nil {
+			logErr(err)
+			return err
+		}
+		notify()
+		return nil
+	})
+}
+
+// serve calls start, unless nil, then update with ctx every interval, as
+// computed from ttl, until stopped, ctx is done or update fails. When the
+// server throttles updates, the next one waits for as long as it asks
+// rather than failing.
+func (rss *RSS) serve(ctx context.Context, ttl time.Duration, start func(), update func(context.Context) error) error {
+	stop, err := rss.startServing()
+	if err != nil {
+		return err
+	}
+	defer rss.stopServing(stop)
+	return rss.serveUntil(ctx, stop, ttl, start, update)
+}
+
+// serveUntil is serve for rss already marked as served by startServing,
+// which returned stop.
+func (rss *RSS) serveUntil(ctx context.Context, stop <-chan struct{}, ttl time.Duration, start func(), update func(context.Context) error) error {
+	if start != nil {
+		start()
 	}
 
+	interval := rss.interval(ttl)
+
 	// time.Sleep(ttl - time.Now().Sub(rss.lastUpdateAt))
-	ticker := time.NewTicker(ttl)
+	ticker := time.NewTicker(interval)
 	defer ticker.Stop()
 
 serveLoop:
 	for {
 		select {
-		case <-stopServe:
+		case <-stop:
 			break serveLoop
+		case <-ctx.Done():
+			return ctx.Err()
 		case <-ticker.C:
-			newItems, err := rss.Update()
-			if err != nil {
-				logErr(err)
-				return err
-			}
-			if newItems != nil {
-				for _, f := range rss.rssUpdateNotifiers {
-					go f(newItems)
+			next := rss.interval(ttl)
+			if err := update(ctx); err != nil {
+				if ctx.Err() != nil {
+					return ctx.Err()
+				}
+				e, ok := err.(*RateLimitError)
+				if !ok {
+					return err
+				}
+				if e.RetryAfter > next {
+					next = e.RetryAfter
 				}
 			}
+			if next != interval {
+				interval = next
+				ticker.Reset(interval)
+			}
 		}
 	}
 
 	return nil
 }
 
-// Stop to serve.
-func (rss *RSS) Stop() { stopServe <- struct{}{} }
+// updateAndNotify updates rss with ctx and calls its notifiers with the
+// new items.
+func (rss *RSS) updateAndNotify(ctx context.Context) error {
+	newItems, _, err := rss.refresh(ctx)
+	if err != nil {
+		logErr(err)
+		return err
+	}
+	if newItems != nil {
+		for _, f := range rss.rssUpdateNotifiers {
+			go f(newItems)
+		}
+	}
+	return nil
+}
+
+// Reset clears rss, its configuration included, so the value can be
+// reused with FeedInto. The memory of its item list is kept.
+func (rss *RSS) Reset() {
+	rss.mu.Lock()
+	defer rss.mu.Unlock()
+
+	items := rss.Channel.Items
+	for i := range items {
+		items[i] = RSSItem{}
+	}
+
+	rss.Version = ""
+	rss.Channel = RSSChannel{Items: items[:0]}
+	rss.TTLSkew = 0
+	rss.AdaptiveTTL = false
+	rss.MinTTL = 0
+	rss.MaxTTL = 0
+	rss.TTLUnit = 0
+	rss.TrustLastBuildDate = false
+	rss.Client = nil
+	rss.OnMetrics = nil
+	rss.DedupKey = nil
+	rss.origin = nil
+	rss.source = ""
+	rss.sourceKind = SourceBytes
+	rss.status = 0
+	rss.etag = ""
+	rss.lastModified = ""
+	rss.modTime = time.Time{}
+	rss.lastUpdateAt = time.Time{}
+	rss.movedTo = ""
+	rss.maxAge = 0
+	rss.hasMaxAge = false
+	rss.transforms = nil
+	rss.rssUpdateNotifiers = nil
+	rss.itemStates = nil
+}
+
+// Stop stops serving rss, making Serve, or the other serving methods
+// such as Watch, return. It does nothing if rss isn't being served.
+func (rss *RSS) Stop() {
+	rss.mu.Lock()
+	defer rss.mu.Unlock()
+	if rss.stop != nil {
+		close(rss.stop)
+		rss.stop = nil
+	}
+}
+
+// startServing marks rss as being served, returning the channel Stop
+// closes, or ErrServing if it is served already.
+func (rss *RSS) startServing() (<-chan struct{}, error) {
+	rss.mu.Lock()
+	defer rss.mu.Unlock()
+	if rss.stop != nil {
+		return nil, ErrServing
+	}
+	rss.stop = make(chan struct{})
+	return rss.stop, nil
+}
+
+// stopServing marks rss as no longer being served, as serving with stop
+// ends, Stop having been called or not.
+func (rss *RSS) stopServing(stop <-chan struct{}) {
+	rss.mu.Lock()
+	defer rss.mu.Unlock()
+	if rss.stop == stop {
+		close(rss.stop)
+		rss.stop = nil
+	}
+}
 
 func (rss *RSS) RegisterRSSUpdateNotifier(f func([]RSSItem)) {
 	rss.mu.Lock()
@@ -191,10 +701,20 @@ func (rss *RSS) RegisterRSSUpdateNotifier(f func([]RSSItem)) {
 // The RSS content will update every ttl minutes. If ttl is 0, it tries
 // to use TTL specified in RSSChannel, then DefaultTTL if RSSChannel.TTL
 // is not specified.
+//
+// f is called with the items that come after the feed was first read;
+// use ServeWithOptions to also have it called with the existing ones.
 func Serve(source string, f RSSUpdateNotifier, ttl time.Duration) error {
+	return ServeWithOptions(source, f, ttl, ServeOptions{})
+}
+
+// ServeWithOptions is like the package-level Serve, starting according to
+// opts. A source that is an http or https URL is fetched, any other is
+// read as a file.
+func ServeWithOptions(source string, f RSSUpdateNotifier, ttl time.Duration, opts ServeOptions) error {
 	var rss *RSS
 	var err error
-	if source[:4] == "http" {
+	if httpURL(source) != nil {
 		rss, err = FeedFromURL(source)
 		if err != nil {
 			logDebugln("ERROR:", err)
@@ -210,26 +730,87 @@ func Serve(source string, f RSSUpdateNotifier, ttl time.Duration) error {
 
 	rss.RegisterRSSUpdateNotifier(f)
 
-	if rss.Channel.Items != nil {
-		go f(rss.Channel.Items)
+	// rss is marked as served before Stop can find it, so that a Stop in
+	// between isn't lost.
+	stop, err := rss.startServing()
+	if err != nil {
+		return err
 	}
+	defer rss.stopServing(stop)
+	servedMu.Lock()
+	served[rss] = true
+	servedMu.Unlock()
+	defer func() {
+		servedMu.Lock()
+		delete(served, rss)
+		servedMu.Unlock()
+	}()
+
+	return rss.serveUntil(context.Background(), stop, ttl, rss.startFunc(opts), rss.updateAndNotify)
+}
 
-	return rss.Serve(ttl)
+// served holds the feeds being served by the package-level Serve, for
+// the package-level Stop.
+var (
+	servedMu sync.Mutex
+	served   = make(map[*RSS]bool)
+)
+
+// Stop stops serving the feeds served by the package-level Serve and
+// ServeWithOptions, leaving alone those served by the RSS methods.
+func Stop() {
+	servedMu.Lock()
+	defer servedMu.Unlock()
+	for rss := range served {
+		rss.Stop()
+	}
 }
 
-// Stop to serve.
-func Stop() { stopServe <- struct{}{} }
+// interval returns how long Serve waits between updates when asked to
+// serve with ttl.
+func (rss *RSS) interval(ttl time.Duration) time.Duration {
+	if ttl == 0 && rss.AdaptiveTTL {
+		ttl = rss.Channel.AverageInterval()
+	}
+	if ttl == 0 {
+		if rss.Channel.TTL > 0 {
+			unit := rss.TTLUnit
+			if unit <= 0 {
+				unit = time.Minute
+			}
+			ttl = time.Duration(rss.Channel.TTL) * unit
+		} else {
+			ttl = DefaultTTL
+		}
+	}
+	if rss.MinTTL > 0 && ttl < rss.MinTTL {
+		ttl = rss.MinTTL
+	}
+	if rss.MaxTTL > 0 && ttl > rss.MaxTTL {
+		ttl = rss.MaxTTL
+	}
+	return ttl + rss.TTLSkew
+}
 
-func (rss *RSS) latestItem() (latestItem *RSSItem) {
-	items := rss.Channel.Items
-	if len(items) < 1 {
-		return nil
+// ExpiresAt returns when the feed is next due for an update: when the
+// last update, or the first read, is older than the interval Serve would
+// wait, or than the freshness lifetime the server gave the feed in its
+// Cache-Control max-age or Expires header, whichever comes first. The
+// result is never sooner than rss.MinTTL after the last update. It is
+// meant for schedulers that update many feeds from a single queue.
+//
+// The zero time is returned, the feed being due right away, if rss was
+// never read.
+func (rss *RSS) ExpiresAt() time.Time {
+	if rss.lastUpdateAt.IsZero() {
+		return time.Time{}
 	}
-	latestItem = &items[0]
-	for i := 1; i < len(items); i++ {
-		if items[i].PubDate.After(latestItem.PubDate) {
-			latestItem = &items[i]
+	ttl := rss.interval(0)
+	if rss.hasMaxAge && rss.maxAge < ttl {
+		ttl = rss.maxAge
+		if ttl < rss.MinTTL {
+			ttl = rss.MinTTL
 		}
 	}
-	return latestItem
+	return rss.lastUpdateAt.Add(ttl)
 }
@@ -5,7 +5,16 @@
 package rssutil
 
 import (
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"strings"
 	"testing"
+	"text/template"
 	"time"
 )
 
@@ -49,7 +58,6 @@ var rss20Text = `
 		</channel>
 	</rss>`
 
-//
 func TestRSS20Feed(t *testing.T) {
 	rss, err := Feed([]byte(rss20Text))
 	if err != nil {
@@ -112,7 +120,7 @@ func TestRSS20Channel(t *testing.T) {
 
 	// if ch.PubDate != ""        { t.Error("ch.PubDate != \"\"") }
 
-	if !ch.LastBuildDate.Equal(time.Date(2018, 5, 11, 8, 45, 56, 0, time.UTC)) {
+	if !ch.LastBuildDate.Equal(RFC822(time.Date(2018, 5, 11, 8, 45, 56, 0, time.UTC))) {
 		t.Error("ch.LastBuildDate != \"Fri, 11 May 2018 16:45:56 +0800\"")
 	}
 
@@ -138,12 +146,12 @@ func TestRSS20Channel(t *testing.T) {
 
 	// if ch.TextInput != ""      { t.Error("ch.TextInput != \"\"") }
 
-	if ch.SkipHours != 0 {
-		t.Error("ch.SkipHours != 0")
+	if len(ch.SkipHours) != 0 {
+		t.Error("len(ch.SkipHours) != 0")
 	}
 
-	if ch.SkipDays != 0 {
-		t.Error("ch.SkipDays != 0")
+	if len(ch.SkipDays) != 0 {
+		t.Error("len(ch.SkipDays) != 0")
 	}
 }
 
@@ -179,12 +187,12 @@ func TestRSS20Items(t *testing.T) {
 
 	// if it0.Enclosure != ""   { t.Error("it0.Enclosure != \"\"") }
 
-	g := GUID{"http://liftoff.msfc.nasa.gov/2003/06/03.html#item573", false}
+	g := GUID{"http://liftoff.msfc.nasa.gov/2003/06/03.html#item573", true}
 	if it0.GUID != g {
 		t.Error("it0.GUID != \"http://liftoff.msfc.nasa.gov/2003/06/03.html#item573\"")
 	}
 
-	if !it0.PubDate.Equal(time.Date(2018, 5, 11, 8, 28, 39, 0, time.UTC)) {
+	if !it0.PubDate.Equal(RFC822(time.Date(2018, 5, 11, 8, 28, 39, 0, time.UTC))) {
 		t.Error("it0.PubDate != \"2018-05-11T08:28:39Z\"")
 	}
 
@@ -229,3 +237,2912 @@ func TestOptionalChannelElements(t *testing.T) {
 	// 14. skipHours
 	// 15. skipDays
 }
+
+func TestFeedLenientEntities(t *testing.T) {
+	text := `<?xml version="1.0"?>
+	<!DOCTYPE rss [
+		<!ENTITY lol "lollollollollollollollollollol">
+		<!ENTITY lol2 "&lol;&lol;&lol;&lol;&lol;&lol;&lol;&lol;&lol;&lol;">
+	]>
+	<rss version="2.0"><channel>
+		<title>Caf&eacute; &lol2;</title>
+		<link>http://example.com/</link>
+		<description>&nbsp;</description>
+	</channel></rss>`
+
+	if _, err := Feed([]byte(text)); err == nil {
+		t.Error("strict Feed accepted undefined entities")
+	}
+
+	opts := DefaultFeedOptions
+	opts.Strict = false
+	rss, err := FeedWithOptions([]byte(text), opts)
+	if err != nil {
+		t.Fatal("lenient decode failed:", err)
+	}
+	if rss.Channel.Title != "Café &lol2;" {
+		t.Errorf("rss.Channel.Title != \"Café &lol2;\", %#v", rss.Channel.Title)
+	}
+}
+
+func TestFeedLenient(t *testing.T) {
+	rss, lenient, err := FeedLenient([]byte(rss20Text))
+	if err != nil || lenient || len(rss.Channel.Items) != 1 {
+		t.Errorf("FeedLenient(valid) = %v, %v, %v", rss, lenient, err)
+	}
+
+	text := "<br />\n<b>Warning</b>: session_start() failed<br />\n" +
+		`<?xml version="1.0"?><rss version="2.0"><channel>
+		<title>Caf&eacute;</title>
+		<link>http://example.com/</link>
+		<description>Broken<br></description>
+	</channel></rss>` + "\n<!-- 0.02s -->\x00"
+	rss, lenient, err = FeedLenient([]byte(text))
+	if err != nil || !lenient {
+		t.Fatalf("FeedLenient(malformed) = %v, %v, %v", rss, lenient, err)
+	}
+	if rss.Channel.Title != "Café" {
+		t.Errorf("rss.Channel.Title != \"Café\", %#v", rss.Channel.Title)
+	}
+
+	if _, lenient, err = FeedLenient([]byte("not a feed")); err == nil || !lenient {
+		t.Errorf("FeedLenient(garbage) = %v, %v", lenient, err)
+	}
+	for _, tt := range []struct {
+		text, format string
+	}{
+		{"<!DOCTYPE html>\n<html><head><title>Blog</title></head><body><p>Hello</p></body></html>", "html"},
+		{`<?xml version="1.0"?><feed xmlns="http://www.w3.org/2005/Atom"><title>Atom</title></feed>`, "atom"},
+		{`<?xml version="1.0"?><opml version="2.0"><body><outline xmlUrl="http://example.com/rss"/></body></opml>`, "opml"},
+	} {
+		rss, lenient, err := FeedLenient([]byte(tt.text))
+		if e, ok := err.(*FormatError); !ok || e.Format != tt.format || rss != nil || lenient {
+			t.Errorf("FeedLenient(%s) = %v, %v, %v, want a %q *FormatError", tt.format, rss, lenient, err, tt.format)
+		}
+	}
+}
+
+func TestFeedEncoding(t *testing.T) {
+	tests := []struct {
+		text, title string
+	}{
+		{"<?xml version=\"1.0\" encoding=\"ISO-8859-1\"?><rss version=\"2.0\"><channel><title>Caf\xe9 cr\xe8me br\xfbl\xe9e</title></channel></rss>", "Café crème brûlée"},
+		{"<?xml version=\"1.0\" encoding=\"GBK\"?><rss version=\"2.0\"><channel><title>\xd6\xd0\xce\xc4\xd0\xc2\xce\xc5</title></channel></rss>", "中文新闻"},
+		{"<?xml version=\"1.0\" encoding=\"gb18030\"?><rss version=\"2.0\"><channel><title>\xd6\xd0\xce\xc4</title></channel></rss>", "中文"},
+		{"<?xml version=\"1.0\"?><rss version=\"2.0\"><channel><title>Café</title></channel></rss>", "Café"},
+	}
+	for _, tt := range tests {
+		rss, err := Feed([]byte(tt.text))
+		if err != nil {
+			t.Errorf("Feed(%q) failed: %v", tt.text, err)
+			continue
+		}
+		if rss.Channel.Title != tt.title {
+			t.Errorf("title of %q != %q, %q", tt.text, tt.title, rss.Channel.Title)
+		}
+	}
+
+	if _, err := Feed([]byte(`<?xml version="1.0" encoding="x-unknown"?><rss version="2.0"><channel/></rss>`)); err == nil {
+		t.Error("Feed() of an unknown encoding succeeded")
+	}
+}
+
+func TestFeedMaxFeedBytes(t *testing.T) {
+	opts := DefaultFeedOptions
+	opts.MaxFeedBytes = int64(len(rss20Text))
+	if _, err := FeedWithOptions([]byte(rss20Text), opts); err != nil {
+		t.Error("decode failed:", err)
+	}
+
+	opts.MaxFeedBytes = int64(len(rss20Text)) - 1
+	if _, err := FeedWithOptions([]byte(rss20Text), opts); err != ErrFeedTooLarge {
+		t.Error("err != ErrFeedTooLarge for oversized input:", err)
+	}
+
+	// The limit applies to the decoded text too, not only to the input.
+	text := `<rss version="2.0"><channel><title>` +
+		strings.Repeat("x", 100) + `</title></channel></rss>`
+	opts.MaxFeedBytes = 64
+	decoder := newDecoder(strings.NewReader(text), opts)
+	if err := decoder.Decode(new(RSS)); err != ErrFeedTooLarge {
+		t.Error("err != ErrFeedTooLarge for oversized output:", err)
+	}
+}
+
+func TestFeedMaxDepthAndTokens(t *testing.T) {
+	if _, err := Feed([]byte(rss20Text)); err != nil {
+		t.Fatal("decode failed:", err)
+	}
+
+	deep := `<rss version="2.0"><channel><title>` + strings.Repeat("<b>", 100) +
+		strings.Repeat("</b>", 100) + `</title></channel></rss>`
+	if _, err := Feed([]byte(deep)); err != ErrFeedTooDeep {
+		t.Error("err != ErrFeedTooDeep for deeply nested input:", err)
+	}
+	opts := DefaultFeedOptions
+	opts.MaxDepth = 0
+	if _, err := FeedWithOptions([]byte(deep), opts); err != nil {
+		t.Error("decode without MaxDepth failed:", err)
+	}
+
+	opts = DefaultFeedOptions
+	opts.MaxTokens = 30
+	if _, err := FeedWithOptions([]byte(testFeed("a")), opts); err != nil {
+		t.Error("decode within MaxTokens failed:", err)
+	}
+	if _, err := FeedWithOptions([]byte(testFeed("a", "b", "c")), opts); err != ErrFeedTooComplex {
+		t.Error("err != ErrFeedTooComplex for input with many tokens:", err)
+	}
+}
+
+func TestDiffReport(t *testing.T) {
+	old, _ := Feed([]byte(rss20Text))
+	cur, _ := Feed([]byte(rss20Text))
+
+	if r := old.DiffReport(cur); r != "No changes" {
+		t.Errorf("DiffReport of identical feeds != \"No changes\", %#v", r)
+	}
+
+	cur.Channel.Items[0].Title = "Edited"
+	cur.Channel.Items = append(cur.Channel.Items, RSSItem{Title: "Fresh", Link: "http://example.com/fresh"})
+	want := "Added: 1 / Removed: 0 / Changed: 1\n+ Fresh\n~ Edited"
+	if r := old.DiffReport(cur); r != want {
+		t.Errorf("DiffReport != %#v, %#v", want, r)
+	}
+
+	if r := cur.DiffReport(old); r != "Added: 0 / Removed: 1 / Changed: 1\n- Fresh\n~ 中国年轻一代不愿意长时间工作" {
+		t.Errorf("reverse DiffReport mismatch, %#v", r)
+	}
+}
+
+func TestServeSourceKind(t *testing.T) {
+	// Short paths, and paths starting like a URL, are files.
+	for _, source := range []string{"", "a", "./", "httpd.xml"} {
+		err := ServeWithOptions(source, nil, 0, ServeOptions{})
+		if _, ok := err.(*os.PathError); !ok {
+			t.Errorf("ServeWithOptions(%q) error isn't that of reading a file, %v", source, err)
+		}
+	}
+}
+
+func TestServeInterval(t *testing.T) {
+	rss, _ := Feed([]byte(rss20Text))
+
+	if d := rss.interval(0); d != 20*time.Minute {
+		t.Error("rss.interval(0) != 20m,", d)
+	}
+
+	rss.TTLSkew = 30 * time.Second
+	if d := rss.interval(0); d != 20*time.Minute+30*time.Second {
+		t.Error("rss.interval(0) != 20m30s,", d)
+	}
+	if d := rss.interval(time.Minute); d != time.Minute+30*time.Second {
+		t.Error("rss.interval(1m) != 1m30s,", d)
+	}
+
+	rss.TTLUnit = time.Second
+	if d := rss.interval(0); d != 20*time.Second+30*time.Second {
+		t.Error("rss.interval(0) != 50s with TTLUnit 1s,", d)
+	}
+	rss.MinTTL = time.Minute
+	if d := rss.interval(0); d != time.Minute+30*time.Second {
+		t.Error("rss.interval(0) isn't bounded by MinTTL with TTLUnit 1s,", d)
+	}
+	rss.MinTTL = 0
+
+	rss.Channel.TTL = 0
+	if d := rss.interval(0); d != DefaultTTL+30*time.Second {
+		t.Error("rss.interval(0) != DefaultTTL+30s,", d)
+	}
+}
+
+func TestServeNotifyExisting(t *testing.T) {
+	rss, _ := Feed([]byte(rss20Text))
+	notified := make(chan []RSSItem, 1)
+	rss.RegisterRSSUpdateNotifier(func(items []RSSItem) { notified <- items })
+
+	done := make(chan error)
+	go func() { done <- rss.ServeWithOptions(time.Hour, ServeOptions{NotifyExisting: true}) }()
+	select {
+	case items := <-notified:
+		if len(items) != 1 {
+			t.Errorf("notified of %d items, want 1", len(items))
+		}
+	case <-time.After(time.Second):
+		t.Error("existing items weren't notified")
+	}
+	rss.Stop()
+	if err := <-done; err != nil {
+		t.Error("serve failed:", err)
+	}
+
+	go func() { done <- rss.Serve(time.Hour) }()
+	select {
+	case <-notified:
+		t.Error("Serve() notified existing items")
+	case <-time.After(50 * time.Millisecond):
+	}
+	rss.Stop()
+	<-done
+}
+
+func TestStop(t *testing.T) {
+	a, _ := Feed([]byte(rss20Text))
+	b, _ := Feed([]byte(rss20Text))
+	a.Stop() // not served, does nothing
+	Stop()
+
+	started := make(chan bool, 2)
+	doneA, doneB := make(chan error, 1), make(chan error, 1)
+	start := func() { started <- true }
+	update := func(context.Context) error { return nil }
+	go func() { doneA <- a.serve(context.Background(), time.Hour, start, update) }()
+	go func() { doneB <- b.serve(context.Background(), time.Hour, start, update) }()
+	<-started
+	<-started
+
+	if err := a.Serve(time.Hour); err != ErrServing {
+		t.Error("serving a served feed didn't fail with ErrServing,", err)
+	}
+	a.Stop()
+	if err := <-doneA; err != nil {
+		t.Error("serve failed:", err)
+	}
+	select {
+	case <-doneB:
+		t.Error("stopping a also stopped b")
+	case <-time.After(50 * time.Millisecond):
+	}
+	a.Stop()
+
+	b.Stop()
+	<-doneB
+}
+
+func TestStopPackageServe(t *testing.T) {
+	done := make(chan error, 1)
+	go func() { done <- Serve("sample_rss/rss2sample.rss", func([]RSSItem) {}, time.Hour) }()
+
+	// Stop as soon as Stop can see the feed.
+	for {
+		servedMu.Lock()
+		n := len(served)
+		servedMu.Unlock()
+		if n > 0 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	Stop()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Error("Serve failed:", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Serve didn't return after Stop")
+	}
+}
+
+func TestServeWithSeen(t *testing.T) {
+	filename := t.TempDir() + "/feed.rss"
+	writeFile(t, filename, testFeed("a", "b"))
+	rss, err := FeedFromFile(filename)
+	if err != nil {
+		t.Fatal("decode failed:", err)
+	}
+
+	notified := make(chan string, 2)
+	onNew := func(items []RSSItem, seen map[string]bool) {
+		var guids []string
+		for _, it := range items {
+			guids = append(guids, it.GUID.Value)
+		}
+		notified <- strings.Join(guids, " ")
+	}
+	seen := map[string]bool{"a": true}
+	done := make(chan error)
+	go func() { done <- rss.ServeWithSeen(10*time.Millisecond, seen, onNew) }()
+	if s := <-notified; s != "b" {
+		t.Errorf("notified of [%s], want [b]", s)
+	}
+
+	// Undated items put before the known ones are still told of.
+	writeFile(t, filename, testFeed("c", "a", "b"))
+	select {
+	case s := <-notified:
+		if s != "c" {
+			t.Errorf("notified of [%s], want [c]", s)
+		}
+	case <-time.After(time.Second):
+		t.Error("new item wasn't notified")
+	}
+	rss.Stop()
+	if err := <-done; err != nil {
+		t.Error("serve failed:", err)
+	}
+	if len(seen) != 3 || !seen["c"] {
+		t.Errorf("seen != {a b c}, %v", seen)
+	}
+}
+
+func TestItemsByDateDesc(t *testing.T) {
+	rss, err := FeedFromFile("sample_rss/rss2sample.rss")
+	if err != nil {
+		t.Fatal("decode failed")
+	}
+	ch := rss.Channel
+	updated := RFC822(time.Date(2003, 6, 10, 0, 0, 0, 0, time.UTC))
+	ch.Items = append(ch.Items, RSSItem{Title: "Undated"}, RSSItem{Title: "Updated", UpdatedDate: &updated})
+	orig := make([]RSSItem, len(ch.Items))
+	copy(orig, ch.Items)
+
+	items := ch.ItemsByDateDesc()
+	if len(items) != len(orig) {
+		t.Fatal("len(items) != len(ch.Items)")
+	}
+	if items[0].Title != "Updated" {
+		t.Error("item with only an UpdatedDate doesn't sort by it")
+	}
+	for i := 1; i < len(items)-1; i++ {
+		if items[i].EffectiveDate().After(items[i-1].EffectiveDate()) {
+			t.Errorf("items[%d] is newer than items[%d]", i, i-1)
+		}
+	}
+	if items[len(items)-1].Title != "Undated" {
+		t.Error("undated item doesn't sort last")
+	}
+	for i := range orig {
+		if ch.Items[i].Title != orig[i].Title {
+			t.Fatal("ItemsByDateDesc reordered ch.Items")
+		}
+	}
+}
+
+func TestFeedItemTransform(t *testing.T) {
+	var seen []string
+	opts := DefaultFeedOptions
+	opts.ItemTransform = func(it *RSSItem) bool {
+		seen = append(seen, it.Title)
+		if strings.Contains(it.Title, "Astronauts") {
+			return false
+		}
+		it.Link = strings.Replace(it.Link, "http://", "https://", 1)
+		return true
+	}
+
+	b, _ := ioutil.ReadFile("sample_rss/rss2sample.rss")
+	rss, err := FeedWithOptions(b, opts)
+	if err != nil {
+		t.Fatal("decode failed")
+	}
+	if len(seen) != 4 {
+		t.Error("ItemTransform wasn't called for every item,", len(seen))
+	}
+	if len(rss.Channel.Items) != 3 {
+		t.Error("len(rss.Channel.Items) != 3,", len(rss.Channel.Items))
+	}
+	for _, it := range rss.Channel.Items {
+		if strings.HasPrefix(it.Link, "http://") {
+			t.Errorf("item link not rewritten, %#v", it.Link)
+		}
+	}
+}
+
+func TestToXML(t *testing.T) {
+	rss, err := FeedFromFile("sample_rss/rss2sample.rss")
+	if err != nil {
+		t.Fatal("decode failed")
+	}
+	rss.Channel.PubDate = nil
+	rss.Channel.LastBuildDate = nil
+	rss.Channel.SkipDays = []time.Weekday{time.Saturday, time.Sunday}
+
+	b, err := rss.ToXML()
+	if err != nil {
+		t.Fatal("encode failed:", err)
+	}
+	if !strings.HasPrefix(string(b), `<?xml version="1.0" encoding="UTF-8"?>`) {
+		t.Error("missing XML declaration")
+	}
+	if !strings.Contains(string(b), "<lastBuildDate>Tue, 03 Jun 2003 09:39:21 +0000</lastBuildDate>") {
+		t.Error("lastBuildDate isn't stamped with the newest item date")
+	}
+	if rss.Channel.LastBuildDate != nil {
+		t.Error("ToXML modified rss")
+	}
+
+	rss2, err := Feed(b)
+	if err != nil {
+		t.Fatal("re-decode failed:", err)
+	}
+	if rss2.Version != "2.0" {
+		t.Error("rss2.Version != \"2.0\"")
+	}
+	if len(rss2.Channel.Items) != len(rss.Channel.Items) {
+		t.Error("len(rss2.Channel.Items) != len(rss.Channel.Items)")
+	}
+	for i, it := range rss2.Channel.Items {
+		if it.Description != rss.Channel.Items[i].Description {
+			t.Errorf("items[%d].Description didn't round-trip", i)
+		}
+		if !time.Time(*it.PubDate).Equal(time.Time(*rss.Channel.Items[i].PubDate)) {
+			t.Errorf("items[%d].PubDate didn't round-trip", i)
+		}
+	}
+	if len(rss2.Channel.SkipDays) != 2 || rss2.Channel.SkipDays[1] != time.Sunday {
+		t.Errorf("SkipDays didn't round-trip, %v", rss2.Channel.SkipDays)
+	}
+
+	desc := rss.Channel.Items[0].Description
+	rss.Channel.Items[0].Description = "<p>Tom & Jerry]]> <![CDATA[x]]></p>\x01"
+	b, err = rss.ToXML()
+	if err != nil {
+		t.Fatal("encode failed:", err)
+	}
+	if rss2, err = Feed(b); err != nil {
+		t.Fatal("re-decode of markup failed:", err)
+	}
+	if got := rss2.Channel.Items[0].Description; got != "<p>Tom & Jerry]]> <![CDATA[x]]></p>\uFFFD" {
+		t.Errorf("markup didn't round-trip, %q", got)
+	}
+	rss.Channel.Items[0].Description = desc
+
+	b, _ = rss.ToXMLWithOptions(XMLOptions{PreserveDates: true})
+	if strings.Contains(string(b), "<lastBuildDate>") {
+		t.Error("PreserveDates still stamped lastBuildDate")
+	}
+
+	if b, _ = rss.ToXML(); strings.Contains(string(b), "\n<channel>") {
+		t.Error("ToXML() isn't compact")
+	}
+	b, err = rss.ToXMLIndent("", "  ")
+	if err != nil {
+		t.Fatal("ToXMLIndent failed:", err)
+	}
+	if !strings.Contains(string(b), "\n  <channel>\n    <title>") {
+		t.Errorf("ToXMLIndent() isn't indented, %s", b)
+	}
+	if rss2, err = Feed(b); err != nil {
+		t.Fatal("decode of indented output failed:", err)
+	}
+	for i, it := range rss2.Channel.Items {
+		if it.Description != rss.Channel.Items[i].Description {
+			t.Errorf("items[%d].Description changed by indenting, %q", i, it.Description)
+		}
+	}
+}
+
+func TestAtomLinkByRel(t *testing.T) {
+	rss, _ := Feed([]byte(rss20Text))
+	ch := rss.Channel
+
+	if len(ch.AtomLinks) != 1 {
+		t.Fatal("len(ch.AtomLinks) != 1")
+	}
+	if h := ch.AtomLinkByRel("self"); h != "https://www.solidot.org/index.rss" {
+		t.Errorf("ch.AtomLinkByRel(\"self\") != \"https://www.solidot.org/index.rss\", %#v", h)
+	}
+	if h := ch.AtomLinkByRel("hub"); h != "" {
+		t.Errorf("ch.AtomLinkByRel(\"hub\") != \"\", %#v", h)
+	}
+
+	ch.AtomLinks = append(ch.AtomLinks, Link{Href: "https://example.com/"})
+	if h := ch.AtomLinkByRel("alternate"); h != "https://example.com/" {
+		t.Errorf("link without rel isn't \"alternate\", %#v", h)
+	}
+
+	b, err := rss.ToXML()
+	if err != nil {
+		t.Fatal("encode failed:", err)
+	}
+	rss2, err := Feed(b)
+	if err != nil {
+		t.Fatal("re-decode failed:", err)
+	}
+	if rss2.Channel.Link != "https://www.solidot.org" {
+		t.Errorf("rss2.Channel.Link != \"https://www.solidot.org\", %#v", rss2.Channel.Link)
+	}
+	if rss2.Channel.AtomLinkByRel("self") != "https://www.solidot.org/index.rss" {
+		t.Error("atom:link didn't round-trip")
+	}
+}
+
+func TestItemAltLinks(t *testing.T) {
+	text := `<?xml version="1.0"?>
+	<rss version="2.0" xmlns:atom="http://www.w3.org/2005/Atom">
+		<channel>
+			<title>Example</title>
+			<link>http://example.com/</link>
+			<description>Alternate links</description>
+			<item>
+				<title>Linked</title>
+				<link>http://example.com/story</link>
+				<atom:link rel="amphtml" type="text/html" href="http://example.com/story.amp"/>
+				<link rel="alternate" type="application/pdf" href="http://example.com/story.pdf"/>
+			</item>
+		</channel>
+	</rss>`
+
+	rss, err := Feed([]byte(text))
+	if err != nil {
+		t.Fatal("decode failed:", err)
+	}
+	it := rss.Channel.Items[0]
+	if it.Link != "http://example.com/story" {
+		t.Errorf("it.Link != \"http://example.com/story\", %#v", it.Link)
+	}
+	if len(it.AltLinks) != 2 {
+		t.Fatalf("len(it.AltLinks) != 2, %v", it.AltLinks)
+	}
+	if h := it.LinkByType("application/pdf"); h != "http://example.com/story.pdf" {
+		t.Errorf("it.LinkByType(\"application/pdf\") != \"http://example.com/story.pdf\", %#v", h)
+	}
+	if h := it.LinkByType("audio/mpeg"); h != "" {
+		t.Errorf("it.LinkByType(\"audio/mpeg\") != \"\", %#v", h)
+	}
+
+	b, err := rss.ToXML()
+	if err != nil {
+		t.Fatal("encode failed:", err)
+	}
+	rss2, err := Feed(b)
+	if err != nil {
+		t.Fatal("re-decode failed:", err)
+	}
+	it2 := rss2.Channel.Items[0]
+	if it2.Link != it.Link || len(it2.AltLinks) != 2 || it2.AltLinks[0] != it.AltLinks[0] {
+		t.Errorf("links didn't round-trip, %v", it2)
+	}
+}
+
+func TestAverageInterval(t *testing.T) {
+	rss, err := FeedFromFile("sample_rss/rss2sample.rss")
+	if err != nil {
+		t.Fatal("decode failed")
+	}
+
+	// The four items span Tue, 20 May 2003 08:56:02 to Tue, 03 Jun 2003
+	// 09:39:21 GMT.
+	want := (14*24*time.Hour + 43*time.Minute + 19*time.Second) / 3
+	if d := rss.Channel.AverageInterval(); d != want {
+		t.Errorf("AverageInterval() != %v, %v", want, d)
+	}
+
+	rss.AdaptiveTTL = true
+	if d := rss.interval(0); d != want {
+		t.Errorf("adaptive rss.interval(0) != %v, %v", want, d)
+	}
+	rss.MaxTTL = 48 * time.Hour
+	if d := rss.interval(0); d != 48*time.Hour {
+		t.Error("rss.interval(0) isn't bounded by MaxTTL,", d)
+	}
+	rss.MinTTL = time.Hour
+	if d := rss.interval(time.Minute); d != time.Hour {
+		t.Error("rss.interval(1m) isn't bounded by MinTTL,", d)
+	}
+
+	if d := (RSSChannel{Items: rss.Channel.Items[:1]}).AverageInterval(); d != 0 {
+		t.Error("AverageInterval() of a single item != 0,", d)
+	}
+}
+
+func TestLatest(t *testing.T) {
+	date := func(day int) *RFC822 {
+		d := RFC822(time.Date(2018, 1, day, 0, 0, 0, 0, time.UTC))
+		return &d
+	}
+	c := RSSChannel{Items: []RSSItem{
+		{Title: "undated"},
+		{Title: "old", PubDate: date(1)},
+		{Title: "updated", UpdatedDate: date(3)},
+		{Title: "new", PubDate: date(2)},
+	}}
+
+	if it := c.Latest(); it == nil || it.Title != "updated" {
+		t.Errorf("Latest() = %v, want updated", it)
+	}
+	if it := (RSSChannel{}).Latest(); it != nil {
+		t.Errorf("Latest() of no items = %v, want nil", it)
+	}
+	if it := (RSSChannel{Items: c.Items[:1]}).Latest(); it == nil || it.Title != "undated" {
+		t.Errorf("Latest() of undated items = %v, want undated", it)
+	}
+
+	var titles []string
+	for _, it := range c.RecentN(3) {
+		titles = append(titles, it.Title)
+	}
+	if got, want := strings.Join(titles, ","), "updated,new,old"; got != want {
+		t.Errorf("RecentN(3) = %s, want %s", got, want)
+	}
+	if got := len(c.RecentN(10)); got != 4 {
+		t.Errorf("len(RecentN(10)) = %d, want 4", got)
+	}
+	if got := c.RecentN(0); got != nil {
+		t.Errorf("RecentN(0) = %v, want nil", got)
+	}
+	if c.Items[0].Title != "undated" {
+		t.Error("RecentN reordered the items")
+	}
+}
+
+func TestCadence(t *testing.T) {
+	rss, err := FeedFromFile("sample_rss/rss2sample.rss")
+	if err != nil {
+		t.Fatal("decode failed")
+	}
+	// Weekly in 2003, silent since.
+	if c := rss.Channel.Cadence(); c != "dormant" {
+		t.Errorf("Cadence() of the sample != \"dormant\", %q", c)
+	}
+	if c := (RSSChannel{Items: rss.Channel.Items[:1]}).Cadence(); c != "unknown" {
+		t.Errorf("Cadence() of a single item != \"unknown\", %q", c)
+	}
+
+	for _, tt := range []struct {
+		gap  time.Duration
+		want string
+	}{
+		{5 * time.Minute, "realtime"},
+		{time.Hour, "hourly"},
+		{24 * time.Hour, "daily"},
+		{7 * 24 * time.Hour, "weekly"},
+		{60 * 24 * time.Hour, "dormant"},
+	} {
+		now := time.Now()
+		a, b := RFC822(now), RFC822(now.Add(-tt.gap))
+		ch := RSSChannel{Items: []RSSItem{{PubDate: &a}, {PubDate: &b}}}
+		if c := ch.Cadence(); c != tt.want {
+			t.Errorf("Cadence() with items %v apart != %q, %q", tt.gap, tt.want, c)
+		}
+	}
+}
+
+func TestChannelEntries(t *testing.T) {
+	rss, err := FeedFromFile("sample_rss/rss2entries.rss")
+	if err != nil {
+		t.Fatal("decode failed:", err)
+	}
+	items := rss.Channel.Items
+	if len(items) != 3 || items[0].Title != "Star City" {
+		t.Fatalf("items != [Star City, The Engine That Does More, Astronauts' Dirty Laundry], %v", items)
+	}
+
+	it := items[1]
+	if it.Title != "The Engine That Does More" || it.GUID.Value != "http://liftoff.msfc.nasa.gov/2003/05/30.html#item572" ||
+		it.Link != "http://liftoff.msfc.nasa.gov/news/2003/news-VASIMR.asp" || it.Author != "NASA" {
+		t.Errorf("items[1] = %v", it)
+	}
+	if !strings.HasPrefix(it.Description, "Before man travels") || it.ContentEncoded != "<p>The proposed <b>VASIMR</b> engine would do that.</p>" {
+		t.Errorf("items[1] description = %q, content = %q", it.Description, it.ContentEncoded)
+	}
+	if it.Enclosure == nil || it.Enclosure.Length != 12216320 || it.Enclosure.Type != "audio/mpeg" {
+		t.Errorf("items[1].Enclosure = %v", it.Enclosure)
+	}
+	if it.PubDate.String() != "2003-05-30T11:06:42Z" || it.UpdatedDate.String() != "2003-06-01T08:00:00Z" {
+		t.Errorf("items[1] dates = %v, %v", it.PubDate, it.UpdatedDate)
+	}
+	if len(it.Categories) != 1 || it.Categories[0].Value != "Propulsion" {
+		t.Errorf("items[1].Categories != [Propulsion], %v", it.Categories)
+	}
+
+	it = items[2]
+	if !strings.HasPrefix(it.Description, "Compared to earlier spacecraft") || it.ContentEncoded != "" {
+		t.Errorf("items[2] description = %q, content = %q", it.Description, it.ContentEncoded)
+	}
+	if !hasDate(it.PubDate) || it.PubDate.String() != "2003-05-27T08:37:32Z" {
+		t.Errorf("items[2].PubDate != updated, %v", it.PubDate)
+	}
+
+	opts := DefaultFeedOptions
+	opts.MaxItems = 2
+	b, _ := ioutil.ReadFile("sample_rss/rss2entries.rss")
+	if rss, err = FeedWithOptions(b, opts); err != nil || len(rss.Channel.Items) != 2 {
+		t.Errorf("MaxItems didn't apply to entries, %v", err)
+	}
+}
+
+func TestDCSubject(t *testing.T) {
+	text := `<?xml version="1.0"?>
+	<rss version="2.0" xmlns:dc="http://purl.org/dc/elements/1.1/">
+		<channel>
+			<title>Example</title>
+			<link>http://example.com/</link>
+			<description>Dublin Core</description>
+			<item>
+				<title>Tagged</title>
+				<category>Go</category>
+				<dc:subject>Go</dc:subject>
+				<dc:subject> XML </dc:subject>
+				<subject>Not Dublin Core</subject>
+			</item>
+		</channel>
+	</rss>`
+
+	rss, err := Feed([]byte(text))
+	if err != nil {
+		t.Fatal("decode failed:", err)
+	}
+	ca := rss.Channel.Items[0].Categories
+	if len(ca) != 2 {
+		t.Fatalf("len(Categories) != 2, %v", ca)
+	}
+	if ca[0].Value != "Go" || ca[1].Value != "XML" || ca[1].Domain != "" {
+		t.Errorf("Categories != [\"Go\", \"XML\"], %v", ca)
+	}
+}
+
+func TestFeedInto(t *testing.T) {
+	rss, err := FeedFromFile("sample_rss/rss2sample.rss")
+	if err != nil {
+		t.Fatal("decode failed")
+	}
+	rss.TTLSkew = time.Minute
+	rss.RegisterRSSUpdateNotifier(func([]RSSItem) {})
+	items := rss.Channel.Items
+
+	rss.Reset()
+	if rss.Channel.Title != "" || len(rss.Channel.Items) != 0 || rss.source != "" ||
+		rss.TTLSkew != 0 || rss.rssUpdateNotifiers != nil {
+		t.Error("Reset left state behind")
+	}
+
+	if err := FeedInto([]byte(rss20Text), rss); err != nil {
+		t.Fatal("FeedInto failed:", err)
+	}
+	if rss.Channel.Title != "最新更新 – Solidot" || len(rss.Channel.Items) != 1 {
+		t.Error("FeedInto didn't decode the feed")
+	}
+	if &rss.Channel.Items[0] != &items[0] {
+		t.Error("FeedInto didn't reuse the item list")
+	}
+
+	if err := FeedInto([]byte("<rss"), rss); err == nil {
+		t.Error("FeedInto accepted a broken document")
+	}
+	if rss.Channel.Title != "最新更新 – Solidot" {
+		t.Error("failed FeedInto modified rss")
+	}
+}
+
+func TestRSSString(t *testing.T) {
+	rss, _ := Feed([]byte(rss20Text))
+	rss.lastUpdateAt = time.Date(2018, 5, 11, 9, 0, 0, 0, time.UTC)
+
+	want := `Version: "2.0", Title: "最新更新 – Solidot", Link: "https://www.solidot.org", Items: 1, LastUpdate: 2018-05-11T09:00:00Z`
+	if s := rss.String(); s != want {
+		t.Errorf("rss.String() != %#v, %#v", want, s)
+	}
+	if s := rss.Dump(); !strings.Contains(s, "TTL: 20") || !strings.Contains(s, "996 工作制") {
+		t.Errorf("rss.Dump() misses content, %#v", s)
+	}
+}
+
+func TestItemLinks(t *testing.T) {
+	it := RSSItem{
+		Link:      "http://example.com/posts/1",
+		Comments:  "http://example.com/posts/1#comments",
+		Enclosure: &RSSEnclosure{URL: "http://cdn.example.com/a.mp3", Length: 1, Type: "audio/mpeg"},
+		Description: `<p><a href="/posts/2?a=1&amp;b=2">next</a> <img src='img/x.png'>
+			<a href=http://example.com/posts/1>self</a> <a href="#top">top</a>
+			<a href="javascript:void(0)">js</a></p>`,
+	}
+	want := []string{
+		"http://example.com/posts/1",
+		"http://example.com/posts/1#comments",
+		"http://cdn.example.com/a.mp3",
+		"http://example.com/posts/2?a=1&b=2",
+		"http://example.com/posts/img/x.png",
+	}
+
+	links := it.Links()
+	if strings.Join(links, " ") != strings.Join(want, " ") {
+		t.Errorf("it.Links() != %v, %v", want, links)
+	}
+}
+
+// lineParser parses a toy format: "lines" on the first line, then one
+// item title per line.
+type lineParser struct{}
+
+func (lineParser) CanParse(b []byte) bool { return strings.HasPrefix(string(b), "lines\n") }
+
+func (lineParser) Parse(b []byte) (*RSS, error) {
+	rss := &RSS{Version: "lines"}
+	for _, title := range strings.Split(string(b), "\n")[1:] {
+		rss.Channel.Items = append(rss.Channel.Items, RSSItem{Title: title})
+	}
+	return rss, nil
+}
+
+func TestRegisterParser(t *testing.T) {
+	RegisterParser(lineParser{})
+	defer func() {
+		parsersMu.Lock()
+		parsers = parsers[:len(parsers)-1]
+		parsersMu.Unlock()
+	}()
+
+	rss, err := Feed([]byte("lines\nfirst\nsecond"))
+	if err != nil {
+		t.Fatal("decode failed:", err)
+	}
+	if rss.Version != "lines" || len(rss.Channel.Items) != 2 || rss.Channel.Items[1].Title != "second" {
+		t.Error("registered parser wasn't used")
+	}
+
+	rss, err = Feed([]byte(rss20Text))
+	if err != nil || rss.Version != "2.0" {
+		t.Error("RSS isn't decoded when no parser accepts it")
+	}
+}
+
+// testFeed returns an RSS 2.0 document with one item per guid=pubDate
+// pair.
+func testFeed(items ...string) string {
+	var b strings.Builder
+	b.WriteString(`<rss version="2.0"><channel><title>Test</title><link>http://example.com/</link><description>Test</description>`)
+	for _, it := range items {
+		kv := strings.SplitN(it, "=", 2)
+		b.WriteString("<item><title>" + kv[0] + "</title><guid>" + kv[0] + "</guid>")
+		if len(kv) == 2 {
+			b.WriteString("<pubDate>" + kv[1] + "</pubDate>")
+		}
+		b.WriteString("</item>")
+	}
+	b.WriteString("</channel></rss>")
+	return b.String()
+}
+
+// writeFile writes text to filename, making sure its modification time
+// changes even on file systems with a coarse clock.
+func writeFile(t *testing.T, filename, text string) {
+	mtime := time.Now()
+	if fi, err := os.Stat(filename); err == nil && !fi.ModTime().Before(mtime) {
+		mtime = fi.ModTime().Add(time.Second)
+	}
+	if err := ioutil.WriteFile(filename, []byte(text), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(filename, mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestUpdateUnchangedFile(t *testing.T) {
+	filename := t.TempDir() + "/feed.rss"
+	writeFile(t, filename, testFeed("a"))
+	rss, err := FeedFromFile(filename)
+	if err != nil {
+		t.Fatal("decode failed:", err)
+	}
+	fi, err := os.Stat(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Same modification time, so the new content isn't read.
+	if err := ioutil.WriteFile(filename, []byte(testFeed("a", "b")), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(filename, fi.ModTime(), fi.ModTime()); err != nil {
+		t.Fatal(err)
+	}
+	newItems, err := rss.Update()
+	if err != nil || newItems != nil {
+		t.Errorf("Update() of an unchanged file != (nil, nil), (%v, %v)", newItems, err)
+	}
+
+	mtime := fi.ModTime().Add(time.Second)
+	if err := os.Chtimes(filename, mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+	newItems, err = rss.Update()
+	if err != nil {
+		t.Fatal("update failed:", err)
+	}
+	if len(newItems) != 1 || newItems[0].Title != "b" {
+		t.Errorf("Update() of a touched file != [b], %v", newItems)
+	}
+}
+
+func TestRefresh(t *testing.T) {
+	filename := t.TempDir() + "/feed.rss"
+	write := func(text string) { writeFile(t, filename, text) }
+
+	write(testFeed("a=Mon, 07 May 2018 10:00:00 GMT", "b=Tue, 08 May 2018 10:00:00 GMT", "undated"))
+	rss, err := FeedFromFile(filename)
+	if err != nil {
+		t.Fatal("decode failed:", err)
+	}
+
+	// b is re-dated, c is new although older than b.
+	write(testFeed("a=Mon, 07 May 2018 10:00:00 GMT", "b=Fri, 11 May 2018 10:00:00 GMT",
+		"c=Mon, 07 May 2018 12:00:00 GMT", "undated"))
+	newItems, updatedItems, err := rss.Refresh()
+	if err != nil {
+		t.Fatal("refresh failed:", err)
+	}
+	if len(newItems) != 1 || newItems[0].Title != "c" {
+		t.Errorf("newItems != [c], %v", newItems)
+	}
+	if len(updatedItems) != 1 || updatedItems[0].Title != "b" {
+		t.Errorf("updatedItems != [b], %v", updatedItems)
+	}
+
+	write(testFeed("a=Mon, 07 May 2018 10:00:00 GMT", "b=Sat, 12 May 2018 10:00:00 GMT",
+		"c=Mon, 07 May 2018 12:00:00 GMT", "d", "undated"))
+	lastUpdateAt := rss.lastUpdateAt
+	newItems, err = rss.PreviewUpdate()
+	if err != nil {
+		t.Fatal("preview failed:", err)
+	}
+	if len(newItems) != 1 || newItems[0].Title != "d" {
+		t.Errorf("PreviewUpdate() != [d], %v", newItems)
+	}
+	if len(rss.Channel.Items) != 4 || !rss.lastUpdateAt.Equal(lastUpdateAt) {
+		t.Error("PreviewUpdate() changed rss")
+	}
+
+	var metrics FeedMetrics
+	rss.OnMetrics = func(m FeedMetrics) { metrics = m }
+	newItems, err = rss.Update()
+	if err != nil {
+		t.Fatal("update failed:", err)
+	}
+	if len(newItems) != 1 || newItems[0].Title != "d" {
+		t.Errorf("Update() != [d], %v", newItems)
+	}
+	if metrics.NewItems != 1 || metrics.UpdatedItems != 1 || metrics.RemovedItems != 0 ||
+		metrics.Bytes == 0 || metrics.Status != 0 || metrics.Err != nil {
+		t.Errorf("metrics != {NewItems: 1, UpdatedItems: 1}, %+v", metrics)
+	}
+
+	write(testFeed("d"))
+	if _, err := rss.Update(); err != nil {
+		t.Fatal("update failed:", err)
+	}
+	if metrics.RemovedItems != 4 {
+		t.Errorf("metrics.RemovedItems != 4, %d", metrics.RemovedItems)
+	}
+}
+
+func TestTrustLastBuildDate(t *testing.T) {
+	filename := t.TempDir() + "/feed.rss"
+	built := func(date string, items ...string) string {
+		return strings.Replace(testFeed(items...), "<item>", "<lastBuildDate>"+date+"</lastBuildDate><item>", 1)
+	}
+
+	writeFile(t, filename, built("Mon, 07 May 2018 10:00:00 GMT", "a", "b"))
+	rss, err := FeedFromFile(filename)
+	if err != nil {
+		t.Fatal("decode failed:", err)
+	}
+	rss.TrustLastBuildDate = true
+
+	// The items changed but the feed says it didn't.
+	writeFile(t, filename, built("Mon, 07 May 2018 10:00:00 GMT", "c", "a", "b"))
+	if newItems, err := rss.Update(); err != nil || len(newItems) != 0 {
+		t.Errorf("Update() with the same lastBuildDate = %v, %v", newItems, err)
+	}
+	if len(rss.Channel.Items) != 2 {
+		t.Errorf("items replaced although lastBuildDate didn't move, %v", rss.Channel.Items)
+	}
+
+	writeFile(t, filename, built("Tue, 08 May 2018 10:00:00 GMT", "c", "a", "b"))
+	if newItems, err := rss.PreviewUpdate(); err != nil || len(newItems) != 1 {
+		t.Errorf("PreviewUpdate() with a new lastBuildDate = %v, %v", newItems, err)
+	}
+	if newItems, err := rss.Update(); err != nil || len(newItems) != 1 || newItems[0].Title != "c" {
+		t.Errorf("Update() with a new lastBuildDate != [c], %v, %v", newItems, err)
+	}
+	if d := rss.Channel.LastBuildDate; !hasDate(d) || d.String() != "2018-05-08T10:00:00Z" {
+		t.Errorf("rss.Channel.LastBuildDate != 2018-05-08T10:00:00Z, %v", d)
+	}
+
+	// Without a lastBuildDate items are compared.
+	writeFile(t, filename, testFeed("d", "c", "a", "b"))
+	if newItems, err := rss.Update(); err != nil || len(newItems) != 1 || newItems[0].Title != "d" {
+		t.Errorf("Update() without lastBuildDate != [d], %v, %v", newItems, err)
+	}
+}
+
+func TestUpdateFeedIdentityChanged(t *testing.T) {
+	filename := t.TempDir() + "/feed.rss"
+	write := func(title, link string) {
+		text := "<rss version=\"2.0\"><channel><title>" + title + "</title><link>" + link +
+			"</link><description>Test</description><item><guid>" + title + "</guid></item></channel></rss>"
+		writeFile(t, filename, text)
+	}
+
+	write("Test", "http://example.com/")
+	rss, err := FeedFromFile(filename)
+	if err != nil {
+		t.Fatal("decode failed:", err)
+	}
+
+	write("Test Renamed", "https://EXAMPLE.com")
+	if _, err := rss.Update(); err != nil {
+		t.Error("update of a renamed feed failed:", err)
+	}
+
+	write("Cheap Pills", "http://spam.example.net/")
+	if _, err := rss.Update(); err != ErrFeedIdentityChanged {
+		t.Error("Update() error != ErrFeedIdentityChanged,", err)
+	}
+	if rss.Channel.Items[0].GUID.Value != "Test Renamed" {
+		t.Error("Update() replaced the items of a changed feed")
+	}
+}
+
+func TestUpdateSourceKind(t *testing.T) {
+	rss, err := FeedFromFile("sample_rss/rss2sample.rss")
+	if err != nil {
+		t.Fatal("decode failed:", err)
+	}
+	if rss.SourceKind() != SourceFile {
+		t.Error("SourceKind() != SourceFile,", rss.SourceKind())
+	}
+
+	rss, err = Feed([]byte(rss20Text))
+	if err != nil {
+		t.Fatal("decode failed:", err)
+	}
+	if rss.SourceKind() != SourceBytes {
+		t.Error("SourceKind() != SourceBytes,", rss.SourceKind())
+	}
+	if _, err := rss.Update(); err != ErrNoReloadableSource {
+		t.Error("Update() error != ErrNoReloadableSource,", err)
+	}
+}
+
+func TestRepairItems(t *testing.T) {
+	ch := RSSChannel{Items: []RSSItem{
+		{Title: "Titled"},
+		{Link: "http://example.com/2018/06/my%20post/"},
+		{Enclosure: &RSSEnclosure{URL: "http://cdn.example.com/ep12.mp3?x=1"}},
+		{Link: "http://example.com/"},
+	}}
+
+	if n := ch.RepairItems(); n != 2 {
+		t.Error("RepairItems() != 2,", n)
+	}
+	for i, want := range []string{"Titled", "my post", "ep12.mp3", ""} {
+		if ch.Items[i].Title != want {
+			t.Errorf("Items[%d].Title != %#v, %#v", i, want, ch.Items[i].Title)
+		}
+	}
+}
+
+func TestFeedDefaultLocation(t *testing.T) {
+	text := `<?xml version="1.0"?>
+	<rss version="2.0">
+		<channel>
+			<title>Example</title>
+			<link>http://example.com/</link>
+			<description>Local dates</description>
+			<lastBuildDate>Tue, 10 Jun 2003 09:41:01</lastBuildDate>
+			<item>
+				<title>Local</title>
+				<pubDate>Tue, 03 Jun 2003 09:39:21</pubDate>
+			</item>
+			<item>
+				<title>Zoned</title>
+				<pubDate>Tue, 03 Jun 2003 09:39:21 GMT</pubDate>
+			</item>
+		</channel>
+	</rss>`
+
+	rss, err := Feed([]byte(text))
+	if err != nil {
+		t.Fatal("decode failed:", err)
+	}
+	want := time.Date(2003, 6, 3, 9, 39, 21, 0, time.UTC)
+	if got := time.Time(*rss.Channel.Items[0].PubDate); !got.Equal(want) {
+		t.Errorf("PubDate != %v, %v", want, got)
+	}
+
+	loc := time.FixedZone("EDT", -4*60*60)
+	opts := DefaultFeedOptions
+	opts.DefaultLocation = loc
+	rss, err = FeedWithOptions([]byte(text), opts)
+	if err != nil {
+		t.Fatal("decode failed:", err)
+	}
+	want = time.Date(2003, 6, 3, 9, 39, 21, 0, loc)
+	if got := time.Time(*rss.Channel.Items[0].PubDate); !got.Equal(want) {
+		t.Errorf("PubDate != %v, %v", want, got)
+	}
+	want = time.Date(2003, 6, 10, 9, 41, 1, 0, loc)
+	if got := time.Time(*rss.Channel.LastBuildDate); !got.Equal(want) {
+		t.Errorf("LastBuildDate != %v, %v", want, got)
+	}
+	want = time.Date(2003, 6, 3, 9, 39, 21, 0, time.UTC)
+	if got := time.Time(*rss.Channel.Items[1].PubDate); !got.Equal(want) {
+		t.Errorf("PubDate != %v, %v", want, got)
+	}
+}
+
+func TestSaveState(t *testing.T) {
+	rss, err := FeedFromFile("sample_rss/rss2sample.rss")
+	if err != nil {
+		t.Fatal("decode failed:", err)
+	}
+	rss.etag = `"v1"`
+
+	var b strings.Builder
+	if err := rss.SaveState(&b); err != nil {
+		t.Fatal("save failed:", err)
+	}
+	rss2, err := LoadState(strings.NewReader(b.String()))
+	if err != nil {
+		t.Fatal("load failed:", err)
+	}
+
+	if rss2.SourceKind() != SourceFile || rss2.source != rss.source || rss2.ETag() != `"v1"` {
+		t.Error("source or validators didn't round-trip")
+	}
+	if !rss2.lastUpdateAt.Equal(rss.lastUpdateAt.Truncate(0)) {
+		t.Errorf("lastUpdateAt != %v, %v", rss.lastUpdateAt, rss2.lastUpdateAt)
+	}
+	if rss2.Channel.Title != rss.Channel.Title || len(rss2.Channel.Items) != len(rss.Channel.Items) {
+		t.Fatal("channel didn't round-trip")
+	}
+	if !sameDate(rss2.Channel.Items[0].PubDate, rss.Channel.Items[0].PubDate) {
+		t.Error("items[0].PubDate didn't round-trip")
+	}
+
+	newItems, err := rss2.Update()
+	if err != nil {
+		t.Fatal("update failed:", err)
+	}
+	if len(newItems) != 0 {
+		t.Errorf("restored feed reports seen items as new, %v", newItems)
+	}
+}
+
+func TestItemState(t *testing.T) {
+	rss, err := FeedFromFile("sample_rss/rss2sample.rss")
+	if err != nil {
+		t.Fatal("decode failed:", err)
+	}
+	guid := rss.ItemKey(rss.Channel.Items[0])
+	if guid != rss.Channel.Items[0].GUID.Value {
+		t.Errorf("ItemKey() != GUID, %q", guid)
+	}
+	rss.SetItemState(guid, ItemState{Read: true})
+	rss.SetItemState("gone", ItemState{Starred: true})
+
+	if _, err := rss.Update(); err != nil {
+		t.Fatal("update failed:", err)
+	}
+	if !rss.ItemStateOf(guid).Read {
+		t.Error("item state lost by Update")
+	}
+
+	var b strings.Builder
+	if err := rss.SaveState(&b); err != nil {
+		t.Fatal("save failed:", err)
+	}
+	rss2, err := LoadState(strings.NewReader(b.String()))
+	if err != nil {
+		t.Fatal("load failed:", err)
+	}
+	if !rss2.ItemStateOf(guid).Read || !rss2.ItemStateOf("gone").Starred {
+		t.Error("item states didn't round-trip")
+	}
+
+	rss2.PruneItemStates()
+	if rss2.ItemStateOf("gone").Starred || !rss2.ItemStateOf(guid).Read {
+		t.Error("PruneItemStates() didn't forget only the state of the missing item")
+	}
+	rss2.SetItemState(guid, ItemState{})
+	if len(rss2.itemStates) != 0 {
+		t.Error("the zero ItemState didn't forget the state")
+	}
+}
+
+func TestIsTruncated(t *testing.T) {
+	tests := []struct {
+		desc string
+		want bool
+	}{
+		{"", false},
+		{"The whole story, told in full.", false},
+		{"The story begins here and then …", true},
+		{"The story begins here [...]", true},
+		{`The story begins here. <a href="http://example.com/story">Read more</a>`, true},
+		{`The story begins here. <p><a href="http://example.com/story">http://example.com/story</a></p>`, true},
+		{`See <a href="http://example.com/">our site</a> for more. ` + strings.Repeat("Long text. ", 40), false},
+	}
+	for _, tt := range tests {
+		if got := (RSSItem{Description: tt.desc}).IsTruncated(); got != tt.want {
+			t.Errorf("IsTruncated(%q) != %v", tt.desc, tt.want)
+		}
+	}
+}
+
+func BenchmarkFeed(b *testing.B) {
+	text, err := ioutil.ReadFile("sample_rss/rss2sample.rss")
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := Feed(text); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestFeedLite(t *testing.T) {
+	for _, filename := range []string{"sample_rss/rss2sample.rss", "sample_rss/engadget_en-us.rss"} {
+		text, err := ioutil.ReadFile(filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		full, err := Feed(text)
+		if err != nil {
+			t.Fatal("decode failed:", err)
+		}
+		lite, err := FeedLite(text)
+		if err != nil {
+			t.Fatal("FeedLite failed:", err)
+		}
+
+		if lite.Version != full.Version || lite.Channel.Title != full.Channel.Title || lite.Channel.Link != full.Channel.Link ||
+			!sameDate(lite.Channel.LastBuildDate, full.Channel.LastBuildDate) {
+			t.Errorf("%s: FeedLite() channel = %v, want %v", filename, lite, full)
+		}
+		if len(lite.Channel.Items) != len(full.Channel.Items) {
+			t.Fatalf("%s: FeedLite() has %d items, want %d", filename, len(lite.Channel.Items), len(full.Channel.Items))
+		}
+		for i, it := range lite.Channel.Items {
+			want := full.Channel.Items[i]
+			if it.Title != want.Title || it.Link != want.Link || it.GUID != want.GUID || !sameDate(it.PubDate, want.PubDate) {
+				t.Errorf("%s: FeedLite() item %d = %v, want %v", filename, i, it, want)
+			}
+			if it.Description != "" || it.Categories != nil || it.Media != nil {
+				t.Errorf("%s: FeedLite() item %d has skipped fields, %v", filename, i, it)
+			}
+		}
+	}
+
+	text := `<rss version="2.0"><channel><title><![CDATA[The <description> tag]]></title>
+<description>A <![CDATA[</description>]]> trap</description>
+<item><title>One</title><description/><content:encoded><![CDATA[<description>]]></content:encoded><guid>1</guid></item>
+<item><title>Two</title><description>x</description><guid>2</guid></item>
+</channel></rss>`
+	rss, err := FeedLite([]byte(text))
+	if err != nil {
+		t.Fatal("FeedLite failed:", err)
+	}
+	if rss.Channel.Title != "The <description> tag" || len(rss.Channel.Items) != 2 || rss.Channel.Items[1].GUID.Value != "2" {
+		t.Errorf("FeedLite() = %v", rss.Dump())
+	}
+
+	if _, err := FeedLite([]byte("<rss")); err == nil {
+		t.Error("FeedLite() of a broken document succeeded")
+	}
+}
+
+func BenchmarkFeedLarge(b *testing.B) {
+	text, err := ioutil.ReadFile("sample_rss/engadget_ja-jp.rss")
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := Feed(text); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkFeedLite(b *testing.B) {
+	text, err := ioutil.ReadFile("sample_rss/engadget_ja-jp.rss")
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := FeedLite(text); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestDuplicateGUIDs(t *testing.T) {
+	text := testFeed("a=Mon, 07 May 2018 10:00:00 GMT", "b=Tue, 08 May 2018 10:00:00 GMT",
+		"a=Wed, 09 May 2018 10:00:00 GMT", "a=Tue, 08 May 2018 10:00:00 GMT")
+
+	tests := []struct {
+		policy DuplicateGUIDPolicy
+		n      int
+		day    int
+	}{
+		{KeepDuplicates, 4, 7},
+		{KeepFirstGUID, 2, 7},
+		{KeepNewestGUID, 2, 9},
+	}
+	for _, tt := range tests {
+		opts := DefaultFeedOptions
+		opts.DuplicateGUIDs = tt.policy
+		rss, err := FeedWithOptions([]byte(text), opts)
+		if err != nil {
+			t.Fatal("decode failed:", err)
+		}
+		if len(rss.Channel.Items) != tt.n {
+			t.Errorf("policy %d: len(Items) != %d, %d", tt.policy, tt.n, len(rss.Channel.Items))
+		}
+		it := rss.Channel.ItemByGUID("a")
+		if it == nil || time.Time(*it.PubDate).Day() != tt.day {
+			t.Errorf("policy %d: ItemByGUID(\"a\") isn't dated May %d, %v", tt.policy, tt.day, it)
+		}
+		if rss.Channel.Items[1].GUID.Value != "b" {
+			t.Errorf("policy %d: items reordered", tt.policy)
+		}
+	}
+
+	if it := (RSSChannel{}).ItemByGUID("a"); it != nil {
+		t.Error("ItemByGUID() of an empty channel != nil")
+	}
+}
+
+func TestInferredTimezone(t *testing.T) {
+	rss, err := FeedFromFile("sample_rss/rss2sample.rss")
+	if err != nil {
+		t.Fatal("decode failed:", err)
+	}
+	if loc := rss.Channel.InferredTimezone(); loc == nil || loc.String() != "GMT" {
+		t.Errorf("InferredTimezone() != GMT, %v", loc)
+	}
+
+	rss, err = Feed([]byte(testFeed("a=Mon, 07 May 2018 10:00:00 +0800", "b=Tue, 08 May 2018 10:00:00 GMT",
+		"c=Wed, 09 May 2018 10:00:00 +0800", "undated")))
+	if err != nil {
+		t.Fatal("decode failed:", err)
+	}
+	loc := rss.Channel.InferredTimezone()
+	if loc == nil {
+		t.Fatal("InferredTimezone() == nil")
+	}
+	if _, offset := time.Date(2018, 5, 7, 0, 0, 0, 0, loc).Zone(); offset != 8*60*60 {
+		t.Errorf("InferredTimezone() offset != +0800, %d", offset)
+	}
+
+	if loc := (RSSChannel{}).InferredTimezone(); loc != nil {
+		t.Errorf("InferredTimezone() of an empty channel != nil, %v", loc)
+	}
+
+	// FeedLite reads dates as DefaultFeedOptions says.
+	saved := DefaultFeedOptions
+	defer func() { DefaultFeedOptions = saved }()
+	DefaultFeedOptions.DefaultLocation = time.FixedZone("CST", 8*60*60)
+	zoneless := []byte(testFeed("a=Mon, 07 May 2018 10:00:00", "b=Tue, 08 May 2018 10:00"))
+	for name, decode := range map[string]func([]byte) (*RSS, error){"Feed": Feed, "FeedLite": FeedLite} {
+		rss, err := decode(zoneless)
+		if err != nil {
+			t.Fatalf("%s failed: %v", name, err)
+		}
+		if loc := rss.Channel.InferredTimezone(); loc != nil {
+			t.Errorf("InferredTimezone() of a %s feed dated without zones != nil, %v", name, loc)
+		}
+	}
+}
+
+func TestToJSONWithOptions(t *testing.T) {
+	rss, err := FeedFromFile("sample_rss/rss2sample.rss")
+	if err != nil {
+		t.Fatal("decode failed:", err)
+	}
+
+	rss.Channel.Items[1].ContentEncoded = "<p>Full story</p>"
+
+	if !strings.Contains(rss.ToJSON(), "\n  \"version\": \"2.0\"") {
+		t.Error("ToJSON() isn't indented")
+	}
+	if !strings.Contains(rss.ToJSON(), "Russia's") || !strings.Contains(rss.ToJSON(), "Full story") {
+		t.Error("ToJSON() left out descriptions")
+	}
+
+	b, err := rss.ToJSONWithOptions(JSONOptions{MaxItems: 2})
+	if err != nil {
+		t.Fatal("encode failed:", err)
+	}
+	s := string(b)
+	if strings.Contains(s, "\n") {
+		t.Error("output is indented")
+	}
+	if strings.Contains(s, "Russia's") || strings.Contains(s, "contentEncoded") {
+		t.Error("item descriptions weren't left out")
+	}
+	if !strings.Contains(s, `"description":"Liftoff to Space Exploration."`) {
+		t.Error("channel description was left out")
+	}
+	if strings.Count(s, `"guid"`) != 2 {
+		t.Errorf("output doesn't have 2 items, %s", s)
+	}
+	if rss.Channel.Items[0].Description == "" || rss.Channel.Items[1].ContentEncoded == "" || len(rss.Channel.Items) != 4 {
+		t.Error("ToJSONWithOptions modified rss")
+	}
+}
+
+func TestGUID(t *testing.T) {
+	rss, err := Feed([]byte(`<rss version="2.0"><channel><title>Example</title>
+<item><guid>http://example.com/1</guid></item>
+<item><guid isPermaLink="false">urn:uuid:2</guid></item>
+<item><guid isPermaLink="true">http://example.com/3</guid></item>
+<item><title>No GUID</title></item>
+</channel></rss>`))
+	if err != nil {
+		t.Fatal("decode failed:", err)
+	}
+	want := []GUID{{"http://example.com/1", true}, {"urn:uuid:2", false}, {"http://example.com/3", true}, {}}
+	for i, it := range rss.Channel.Items {
+		if it.GUID != want[i] {
+			t.Errorf("items[%d].GUID = %#v, want %#v", i, it.GUID, want[i])
+		}
+	}
+
+	b, err := rss.ToXML()
+	if err != nil {
+		t.Fatal("encode failed:", err)
+	}
+	if !strings.Contains(string(b), `<guid>http://example.com/1</guid>`) ||
+		!strings.Contains(string(b), `<guid isPermaLink="false">urn:uuid:2</guid>`) || strings.Count(string(b), "<guid") != 3 {
+		t.Errorf("GUIDs weren't encoded as they were read, %s", b)
+	}
+
+	b, err = json.Marshal(rss.Channel.Items[:2])
+	if err != nil {
+		t.Fatal("JSON encode failed:", err)
+	}
+	if !strings.Contains(string(b), `"guid":"http://example.com/1"`) ||
+		!strings.Contains(string(b), `"guid":{"value":"urn:uuid:2","isPermaLink":false}`) {
+		t.Errorf("GUIDs weren't encoded to JSON as expected, %s", b)
+	}
+	var items []RSSItem
+	if err := json.Unmarshal(b, &items); err != nil {
+		t.Fatal("JSON decode failed:", err)
+	}
+	if len(items) != 2 || items[0].GUID != want[0] || items[1].GUID != want[1] {
+		t.Errorf("GUIDs didn't round-trip through JSON, %v", items)
+	}
+
+	if j := rss.ToJSON(); strings.Count(j, `"guid"`) != 3 {
+		t.Errorf("ToJSON() didn't leave out the missing GUID, %s", j)
+	}
+}
+
+func TestCanonicalURL(t *testing.T) {
+	tests := []struct {
+		it   RSSItem
+		want string
+	}{
+		{RSSItem{GUID: GUID{"http://liftoff.msfc.nasa.gov/2003/06/03.html#item573", true}, Link: "http://example.com/"},
+			"http://liftoff.msfc.nasa.gov/2003/06/03.html"},
+		{RSSItem{GUID: GUID{"item573", false}, Link: "HTTPS://Example.COM:443"}, "https://example.com/"},
+		{RSSItem{GUID: GUID{"http://example.com/ids/573", false}, Link: "http://example.com/573"}, "http://example.com/573"},
+		{RSSItem{Link: "/story", AltLinks: []Link{
+			{Href: "http://example.com/story.amp", Rel: "amphtml"},
+			{Href: "http://example.com:80/story"},
+		}}, "http://example.com/story"},
+		{RSSItem{GUID: GUID{"urn:uuid:1225c695", false}, Source: &RSSSource{URL: "http://example.com/feed"}}, ""},
+	}
+	for _, tt := range tests {
+		if got := tt.it.CanonicalURL(); got != tt.want {
+			t.Errorf("CanonicalURL() != %#v, %#v", tt.want, got)
+		}
+	}
+}
+
+func TestFeedTrimsCDATA(t *testing.T) {
+	text := `<?xml version="1.0"?>
+	<rss version="2.0">
+		<channel>
+			<title><![CDATA[  Example  ]]></title>
+			<link>http://example.com/</link>
+			<description>CDATA</description>
+			<category><![CDATA[ News ]]></category>
+			<item>
+				<title><![CDATA[
+					Wrapped title
+				]]></title>
+				<category domain=" http://example.com/tags "><![CDATA[ Go ]]></category>
+				<guid> http://example.com/1 </guid>
+			</item>
+		</channel>
+	</rss>`
+
+	rss, err := Feed([]byte(text))
+	if err != nil {
+		t.Fatal("decode failed:", err)
+	}
+	ch := rss.Channel
+	if ch.Title != "Example" {
+		t.Errorf("ch.Title != \"Example\", %#v", ch.Title)
+	}
+	if len(ch.Categories) != 1 || ch.Categories[0].Value != "News" {
+		t.Errorf("ch.Categories != [\"News\"], %v", ch.Categories)
+	}
+	it := ch.Items[0]
+	if it.Title != "Wrapped title" {
+		t.Errorf("it.Title != \"Wrapped title\", %#v", it.Title)
+	}
+	if len(it.Categories) != 1 || it.Categories[0].Value != "Go" || it.Categories[0].Domain != "http://example.com/tags" {
+		t.Errorf("it.Categories != [\"Go\"], %#v", it.Categories)
+	}
+	if it.GUID.Value != "http://example.com/1" {
+		t.Errorf("it.GUID != \"http://example.com/1\", %#v", it.GUID)
+	}
+}
+
+func TestFeedTrimFields(t *testing.T) {
+	text := `<?xml version="1.0"?>
+	<rss version="2.0">
+		<channel>
+			<title> Snippets </title>
+			<link>http://example.com/</link>
+			<description> Code </description>
+			<item>
+				<title> Hello </title>
+				<description><![CDATA[<pre>
+    fmt.Println("hello")
+</pre>
+]]></description>
+				<guid> http://example.com/1 </guid>
+			</item>
+		</channel>
+	</rss>`
+
+	opts := DefaultFeedOptions
+	opts.Trim = TrimAll &^ TrimDescription
+	rss, err := FeedWithOptions([]byte(text), opts)
+	if err != nil {
+		t.Fatal("decode failed:", err)
+	}
+	ch := rss.Channel
+	if ch.Title != "Snippets" || ch.Description != " Code " {
+		t.Errorf("channel title and description = %q, %q", ch.Title, ch.Description)
+	}
+	it := ch.Items[0]
+	if it.Title != "Hello" || it.GUID.Value != "http://example.com/1" {
+		t.Errorf("item title and guid = %q, %q", it.Title, it.GUID)
+	}
+	if want := "<pre>\n    fmt.Println(\"hello\")\n</pre>\n"; it.Description != want {
+		t.Errorf("it.Description != %q, %q", want, it.Description)
+	}
+}
+
+func TestItemUpdatedDate(t *testing.T) {
+	text := `<?xml version="1.0"?>
+	<rss version="2.0" xmlns:atom="http://www.w3.org/2005/Atom">
+		<channel>
+			<title>Example</title>
+			<link>http://example.com/</link>
+			<description>Updates</description>
+			<item>
+				<title>Revised</title>
+				<pubDate>Mon, 01 Jan 2018 10:00:00 GMT</pubDate>
+				<atom:updated>2018-01-03T12:00:00Z</atom:updated>
+			</item>
+			<item>
+				<title>Atom dated</title>
+				<atom:published>2018-01-02T08:00:00+01:00</atom:published>
+				<updated>not atom</updated>
+			</item>
+		</channel>
+	</rss>`
+
+	rss, err := Feed([]byte(text))
+	if err != nil {
+		t.Fatal("decode failed:", err)
+	}
+	a, b := rss.Channel.Items[0], rss.Channel.Items[1]
+	if !hasDate(a.PubDate) || a.PubDate.String() != "2018-01-01T10:00:00Z" {
+		t.Errorf("a.PubDate != 2018-01-01T10:00:00Z, %v", a.PubDate)
+	}
+	if !hasDate(a.UpdatedDate) || a.UpdatedDate.String() != "2018-01-03T12:00:00Z" {
+		t.Errorf("a.UpdatedDate != 2018-01-03T12:00:00Z, %v", a.UpdatedDate)
+	}
+	if !hasDate(b.PubDate) || !time.Time(*b.PubDate).Equal(time.Date(2018, 1, 2, 7, 0, 0, 0, time.UTC)) {
+		t.Errorf("b.PubDate != 2018-01-02T08:00:00+01:00, %v", b.PubDate)
+	}
+	if b.UpdatedDate != nil {
+		t.Errorf("b.UpdatedDate != nil, %v", b.UpdatedDate)
+	}
+
+	out, err := rss.ToXML()
+	if err != nil {
+		t.Fatal("ToXML failed:", err)
+	}
+	if !strings.Contains(string(out), "<atom:updated>2018-01-03T12:00:00Z</atom:updated>") {
+		t.Errorf("ToXML() lost atom:updated, %s", out)
+	}
+}
+
+func TestSanitizeUTF8(t *testing.T) {
+	rss, err := Feed([]byte(rss20Text))
+	if err != nil {
+		t.Fatal("decode failed:", err)
+	}
+	if rss.HasInvalidUTF8() {
+		t.Error("HasInvalidUTF8() of a valid feed == true")
+	}
+
+	rss.Channel.Title = "Caf\xc3"
+	rss.Channel.Items[0].Categories = []RSSCategory{{Value: "ok"}, {Value: "\xff\xfebad"}}
+	rss.Channel.Items[0].Enclosure = &RSSEnclosure{URL: "http://example.com/\xe6\x9c"}
+	if !rss.HasInvalidUTF8() {
+		t.Fatal("HasInvalidUTF8() == false")
+	}
+	rss.SanitizeUTF8()
+	if rss.HasInvalidUTF8() {
+		t.Error("HasInvalidUTF8() after SanitizeUTF8() == true")
+	}
+	it := rss.Channel.Items[0]
+	if rss.Channel.Title != "Caf\uFFFD" || it.Categories[1].Value != "\uFFFDbad" ||
+		it.Enclosure.URL != "http://example.com/\uFFFD" || it.Categories[0].Value != "ok" {
+		t.Errorf("SanitizeUTF8() = %q, %q, %q", rss.Channel.Title, it.Categories, it.Enclosure.URL)
+	}
+}
+
+func TestSummaryAndBody(t *testing.T) {
+	long := strings.Repeat("Words go here. ", 30)
+	for _, tt := range []struct {
+		it            RSSItem
+		summary, body string
+	}{
+		{RSSItem{Description: "<p>Lede</p>", ContentEncoded: "<p>Lede</p><p>Article</p>"}, "<p>Lede</p>", "<p>Lede</p><p>Article</p>"},
+		{RSSItem{Description: "<p>Lede</p> <!-- more --> <p>One</p><p>Two</p>"}, "<p>Lede</p>", "<p>One</p><p>Two</p>"},
+		{RSSItem{Description: "<p>One</p>\n<p>Two</p>"}, "<p>One</p>", "<p>Two</p>"},
+		{RSSItem{Description: "Short. Text."}, "Short. Text.", ""},
+		{RSSItem{Description: "<b>Long</b> " + long}, "<b>Long</b> " + strings.TrimSpace(long), ""},
+		{RSSItem{Description: long}, strings.TrimSpace(long[:15*20]), strings.TrimSpace(long[15*20:])},
+	} {
+		summary, body := tt.it.SummaryAndBody()
+		if summary != tt.summary || body != tt.body {
+			t.Errorf("SummaryAndBody() of %q = %q, %q, want %q, %q", tt.it.Description, summary, body, tt.summary, tt.body)
+		}
+	}
+
+	text := `<rss version="2.0" xmlns:content="http://purl.org/rss/1.0/modules/content/"><channel>
+		<title>Example</title><link>http://example.com/</link><description>Content</description>
+		<item><title>Post</title><description>Lede</description>
+		<content:encoded><![CDATA[ <p>Full</p> ]]></content:encoded><encoded>not content</encoded></item>
+	</channel></rss>`
+	rss, err := Feed([]byte(text))
+	if err != nil {
+		t.Fatal("decode failed:", err)
+	}
+	if c := rss.Channel.Items[0].ContentEncoded; c != "<p>Full</p>" {
+		t.Errorf("ContentEncoded != \"<p>Full</p>\", %q", c)
+	}
+	b, err := rss.ToXML()
+	if err != nil {
+		t.Fatal("encode failed:", err)
+	}
+	if rss2, err := Feed(b); err != nil || rss2.Channel.Items[0].ContentEncoded != "<p>Full</p>" {
+		t.Errorf("ContentEncoded didn't round-trip, %s", b)
+	}
+
+	if s := rss.Channel.Items[0].String(); !strings.Contains(s, `ContentEncoded: "<p>Full</p>"`) {
+		t.Errorf("String() doesn't show ContentEncoded, %s", s)
+	}
+	var doc struct {
+		Channel struct {
+			Items []struct {
+				ContentEncoded string `json:"contentEncoded"`
+			} `json:"item"`
+		} `json:"channel"`
+	}
+	if err := json.Unmarshal([]byte(rss.ToJSON()), &doc); err != nil || len(doc.Channel.Items) != 1 || doc.Channel.Items[0].ContentEncoded != "<p>Full</p>" {
+		t.Errorf("ToJSON() doesn't include ContentEncoded, %s", rss.ToJSON())
+	}
+}
+
+func TestParseRFC822Variants(t *testing.T) {
+	want := time.Date(2006, 1, 2, 15, 4, 5, 0, time.UTC)
+	for _, v := range []string{
+		"Mon, 02 Jan 2006 15:04:05 GMT",
+		"02 Jan 2006 15:04:05 GMT",
+		"2 Jan 2006 15:04:05 GMT",
+		"Mon 02 Jan 2006 15:04:05 GMT",
+		"Mon,02 Jan 2006 15:04:05 GMT",
+		"Mon,  02 Jan  2006 15:04:05   GMT",
+		"\n\t Mon, 02 Jan 2006\t15:04:05 +0000 \n",
+		"Tue, 02 Jan 2006 15:04:05 GMT",
+		"Monday, 02 Jan 2006 15:04:05 GMT",
+		"Mon, 02 Jan 06 15:04:05 GMT",
+		"Mon, 02 Jan 2006 16:04:05 +0100",
+		"Mon, 02 Jan 2006 15:04:05",
+		"02 Jan 2006 15:04:05",
+	} {
+		d, zoned, err := parseRFC822(v, nil)
+		if err != nil {
+			t.Errorf("parseRFC822(%q) failed: %v", v, err)
+		} else if !time.Time(d).Equal(want) {
+			t.Errorf("parseRFC822(%q) = %v, want %v", v, d, want)
+		} else if hasZone := strings.Contains(v, "GMT") || strings.Contains(v, "+0"); zoned != hasZone {
+			t.Errorf("parseRFC822(%q) zoned = %v", v, zoned)
+		}
+	}
+
+	d, _, err := parseRFC822("Mon, 02 Jan 2006 15:04 GMT", nil)
+	if err != nil || !time.Time(d).Equal(want.Add(-5*time.Second)) {
+		t.Errorf("parseRFC822() without seconds = %v, %v", d, err)
+	}
+	for _, v := range []string{"", "Mon,", "yesterday", "2006-01-02"} {
+		if _, _, err := parseRFC822(v, nil); err == nil {
+			t.Errorf("parseRFC822(%q) didn't fail", v)
+		}
+	}
+}
+
+func TestRFC822Equal(t *testing.T) {
+	a := RFC822(time.Date(2018, 5, 11, 16, 45, 56, 0, time.FixedZone("CST", 8*60*60)))
+	b := RFC822(time.Date(2018, 5, 11, 8, 45, 56, 0, time.UTC))
+	if !a.Equal(b) {
+		t.Error("a.Equal(b) is false for the same instant")
+	}
+	if a.Equal(RFC822(time.Time(b).Add(time.Second))) {
+		t.Error("a.Equal() is true for another instant")
+	}
+	if !a.After(nil) || (RFC822{}).After(nil) {
+		t.Error("After(nil) isn't true only for set dates")
+	}
+}
+
+func TestBestMedia(t *testing.T) {
+	text := `<?xml version="1.0"?>
+	<rss version="2.0" xmlns:media="http://search.yahoo.com/mrss/" xmlns:content="http://purl.org/rss/1.0/modules/content/">
+		<channel>
+			<title>Example</title>
+			<link>http://example.com/</link>
+			<description>Media</description>
+			<item>
+				<title>Episode 12</title>
+				<enclosure url="http://example.com/ep12.mp3" length="12216320" type="audio/mpeg"/>
+				<enclosure url="http://example.com/ep12.ogg" length="9216320" type="audio/ogg"/>
+				<content:encoded><![CDATA[<p>Show notes</p>]]></content:encoded>
+				<media:group>
+					<media:content url="http://example.com/ep12-low.mp4" type="video/mp4" bitrate="400"/>
+					<media:content url="http://example.com/ep12-high.mp4" type="video/mp4" bitrate="1200.5" fileSize="n/a"/>
+				</media:group>
+			</item>
+		</channel>
+	</rss>`
+
+	rss, err := Feed([]byte(text))
+	if err != nil {
+		t.Fatal("decode failed:", err)
+	}
+	it := rss.Channel.Items[0]
+	if len(it.Media) != 4 {
+		t.Fatalf("len(it.Media) != 4, %v", it.Media)
+	}
+	if it.Enclosure == nil || it.Enclosure.URL != "http://example.com/ep12.mp3" {
+		t.Errorf("it.Enclosure isn't the first enclosure, %v", it.Enclosure)
+	}
+
+	tests := []struct {
+		prefer []string
+		want   string
+	}{
+		{nil, "http://example.com/ep12-high.mp4"},
+		{[]string{"audio/ogg"}, "http://example.com/ep12.ogg"},
+		{[]string{"audio/*"}, "http://example.com/ep12.mp3"},
+		{[]string{"application/pdf", "video/mp4"}, "http://example.com/ep12-high.mp4"},
+	}
+	for _, tt := range tests {
+		if m := it.BestMedia(tt.prefer...); m == nil || m.URL != tt.want {
+			t.Errorf("BestMedia(%q) != %s, %v", tt.prefer, tt.want, m)
+		}
+	}
+
+	if m := (RSSItem{}).BestMedia(); m != nil {
+		t.Errorf("BestMedia() of an item without media != nil, %v", m)
+	}
+	enc := &RSSEnclosure{URL: "http://example.com/a.mp3"}
+	if m := (RSSItem{Enclosure: enc}).BestMedia(); m == nil || m.URL != enc.URL {
+		t.Errorf("BestMedia() ignored Enclosure, %v", m)
+	}
+}
+
+func TestDigest(t *testing.T) {
+	rss, err := FeedFromFile("sample_rss/rss2sample.rss")
+	if err != nil {
+		t.Fatal("decode failed:", err)
+	}
+	rss.Channel.Items[2].PubDate = nil
+
+	want := "Liftoff News\n" +
+		"- [2003-06-03] Star City — http://liftoff.msfc.nasa.gov/news/2003/news-starcity.asp\n" +
+		"- [2003-05-30] Sky watchers in Europe, Asia, and parts of Alaska and Canada will experience a…\n" +
+		"- [2003-05-20] Astronauts' Dirty Laundry — http://liftoff.msfc.nasa.gov/news/2003/news-laundry.asp"
+	if got := rss.Digest(3); got != want {
+		t.Errorf("Digest(3) != %q, %q", want, got)
+	}
+	if got := rss.Digest(0); !strings.HasSuffix(got, "\n- The Engine That Does More — http://liftoff.msfc.nasa.gov/news/2003/news-VASIMR.asp") {
+		t.Errorf("Digest(0) doesn't end with the undated item, %q", got)
+	}
+}
+
+func TestFeedMissingVersion(t *testing.T) {
+	tests := []struct {
+		text string
+		want string
+	}{
+		{`<rss><channel><title>Example</title></channel></rss>`, "2.0"},
+		{`<rss version=""><channel><title>Example</title></channel></rss>`, "2.0"},
+		{`<rss version="0.91"><channel><title>Example</title></channel></rss>`, "0.91"},
+		{`<rss></rss>`, ""},
+	}
+	for _, tt := range tests {
+		rss, err := Feed([]byte(tt.text))
+		if err != nil {
+			t.Fatal("decode failed:", err)
+		}
+		if rss.Version != tt.want {
+			t.Errorf("Version of %s != %#v, %#v", tt.text, tt.want, rss.Version)
+		}
+	}
+}
+
+func TestTotalEnclosureBytes(t *testing.T) {
+	ch := RSSChannel{Items: []RSSItem{
+		{Media: []RSSEnclosure{{Length: 3 << 30}, {Length: 1}}},
+		{Enclosure: &RSSEnclosure{Length: 2}},
+		{Media: []RSSEnclosure{{Length: -1}}},
+		{Title: "no media"},
+	}}
+	if n := ch.TotalEnclosureBytes(); n != 3<<30+3 {
+		t.Errorf("TotalEnclosureBytes() != %d, %d", int64(3<<30+3), n)
+	}
+}
+
+func TestEnclosureIsValid(t *testing.T) {
+	tests := []struct {
+		ec   RSSEnclosure
+		want bool
+	}{
+		{RSSEnclosure{URL: "http://www.scripting.com/mp3s/weatherReportSuite.mp3", Length: 12216320, Type: "audio/mpeg"}, true},
+		{RSSEnclosure{URL: "https://example.com/a.mp3"}, true},
+		{RSSEnclosure{URL: "ftp://example.com/a.mp3"}, false},
+		{RSSEnclosure{URL: "data:audio/mpeg;base64,AAAA"}, false},
+		{RSSEnclosure{URL: "/a.mp3"}, false},
+		{RSSEnclosure{URL: "http://example.com/a.mp3", Length: -1}, false},
+	}
+	for _, tt := range tests {
+		if got := tt.ec.IsValid(); got != tt.want {
+			t.Errorf("IsValid() of %v != %v", tt.ec, tt.want)
+		}
+	}
+}
+
+func TestItemsByDay(t *testing.T) {
+	rss, err := Feed([]byte(testFeed("a=Mon, 07 May 2018 10:00:00 GMT", "b=Mon, 07 May 2018 23:30:00 GMT",
+		"c=Tue, 08 May 2018 01:00:00 +0800", "undated")))
+	if err != nil {
+		t.Fatal("decode failed:", err)
+	}
+
+	days := rss.Channel.ItemsByDay(nil)
+	if len(days) != 2 || len(days["2018-05-07"]) != 3 || len(days[""]) != 1 {
+		t.Errorf("ItemsByDay(nil) != {2018-05-07: [a b c], \"\": [undated]}, %v", days)
+	}
+
+	days = rss.Channel.ItemsByDay(time.FixedZone("CST", 8*60*60))
+	if len(days["2018-05-07"]) != 1 || len(days["2018-05-08"]) != 2 || days["2018-05-08"][0].Title != "b" {
+		t.Errorf("ItemsByDay(CST) != {2018-05-07: [a], 2018-05-08: [b c]}, %v", days)
+	}
+}
+
+func TestItemsInCategory(t *testing.T) {
+	ch := RSSChannel{
+		Categories: []RSSCategory{{Value: "Space"}},
+		Items: []RSSItem{
+			{Title: "a", Categories: []RSSCategory{{Value: "go"}}},
+			{Title: "b"},
+			{Title: "c", Categories: []RSSCategory{{Value: "XML"}, {Value: "Go"}}},
+		},
+	}
+
+	if items := ch.ItemsInCategory("Go", false); len(items) != 2 || items[1].Title != "c" {
+		t.Errorf("ItemsInCategory(\"Go\", false) != [a c], %v", items)
+	}
+	if items := ch.ItemsInCategory("space", false); len(items) != 0 {
+		t.Errorf("ItemsInCategory(\"space\", false) != [], %v", items)
+	}
+	if items := ch.ItemsInCategory("space", true); len(items) != 3 {
+		t.Errorf("ItemsInCategory(\"space\", true) != [a b c], %v", items)
+	}
+	if items := ch.ItemsInCategory("XML", true); len(items) != 1 {
+		t.Errorf("ItemsInCategory(\"XML\", true) != [c], %v", items)
+	}
+}
+
+func TestWatch(t *testing.T) {
+	defer func(d time.Duration) { WatchInterval = d }(WatchInterval)
+	WatchInterval = 10 * time.Millisecond
+
+	filename := t.TempDir() + "/feed.rss"
+	writeFile(t, filename, testFeed("a"))
+	rss, err := FeedFromFile(filename)
+	if err != nil {
+		t.Fatal("decode failed:", err)
+	}
+	notified := make(chan []RSSItem, 1)
+	rss.RegisterRSSUpdateNotifier(func(items []RSSItem) { notified <- items })
+
+	done := make(chan error)
+	go func() { done <- rss.Watch() }()
+	time.Sleep(50 * time.Millisecond)
+	writeFile(t, filename, testFeed("a", "b"))
+	select {
+	case items := <-notified:
+		if len(items) != 1 || items[0].Title != "b" {
+			t.Errorf("notified of %v, want [b]", items)
+		}
+	case <-time.After(2 * time.Second):
+		t.Error("change wasn't noticed")
+	}
+	rss.Stop()
+	if err := <-done; err != nil {
+		t.Error("watch failed:", err)
+	}
+
+	rss, _ = Feed([]byte(rss20Text))
+	if err := rss.Watch(); err != ErrNotFileSource {
+		t.Error("Watch() error != ErrNotFileSource,", err)
+	}
+}
+
+func TestAtomFeed(t *testing.T) {
+	text := `<?xml version="1.0" encoding="utf-8"?>
+<feed xmlns="http://www.w3.org/2005/Atom">
+	<title type="html">Liftoff &amp;amp; News</title>
+	<subtitle>Liftoff to Space Exploration.</subtitle>
+	<link rel="self" href="http://liftoff.msfc.nasa.gov/atom.xml"/>
+	<link href="http://liftoff.msfc.nasa.gov/"/>
+	<id>urn:uuid:60a76c80-d399-11d9-b93C-0003939e0af6</id>
+	<updated>2003-06-10T04:00:00Z</updated>
+	<author><name>Editor</name><email>editor@example.com</email></author>
+	<icon>http://liftoff.msfc.nasa.gov/favicon.ico</icon>
+	<entry>
+		<title>Star City</title>
+		<link rel="alternate" type="application/pdf" href="http://liftoff.msfc.nasa.gov/news/starcity.pdf"/>
+		<link rel="alternate" type="text/html" href="http://liftoff.msfc.nasa.gov/news/starcity"/>
+		<link rel="replies" href="http://liftoff.msfc.nasa.gov/news/starcity/comments"/>
+		<link rel="enclosure" type="audio/mpeg" length="1000" href="http://liftoff.msfc.nasa.gov/media/starcity.mp3"/>
+		<id>urn:uuid:1225c695-cfb8-4ebb-aaaa-80da344efa6a</id>
+		<published>2003-06-03T09:39:21Z</published>
+		<updated>2003-06-04T09:39:21Z</updated>
+		<summary>How do Americans get ready to work with Russians?</summary>
+		<content type="xhtml"><div xmlns="http://www.w3.org/1999/xhtml"><p>Full story</p></div></content>
+	</entry>
+	<entry>
+		<title>Sky watchers</title>
+		<link href="http://liftoff.msfc.nasa.gov/news/eclipse"/>
+		<id>urn:uuid:2</id>
+		<updated>2003-05-30T11:06:42Z</updated>
+		<content type="html">&lt;p&gt;A partial eclipse.&lt;/p&gt;</content>
+	</entry>
+</feed>`
+	rss, err := AtomFeed([]byte(text))
+	if err != nil {
+		t.Fatal("AtomFeed failed:", err)
+	}
+
+	ch := rss.Channel
+	if rss.Version != AtomVersion || ch.Title != "Liftoff &amp; News" || ch.Description != "Liftoff to Space Exploration." ||
+		ch.Link != "http://liftoff.msfc.nasa.gov/" || ch.AtomLinkByRel("self") != "http://liftoff.msfc.nasa.gov/atom.xml" ||
+		ch.ManagingEditor != "editor@example.com (Editor)" || ch.Image == nil || ch.Image.URL != "http://liftoff.msfc.nasa.gov/favicon.ico" {
+		t.Errorf("AtomFeed() channel = %v", ch)
+	}
+	if ch.LastBuildDate == nil || ch.LastBuildDate.String() != "2003-06-10T04:00:00Z" {
+		t.Errorf("AtomFeed() lastBuildDate = %v", ch.LastBuildDate)
+	}
+	if len(ch.Items) != 2 {
+		t.Fatalf("AtomFeed() has %d items, want 2", len(ch.Items))
+	}
+
+	it := ch.Items[0]
+	if it.GUID.Value != "urn:uuid:1225c695-cfb8-4ebb-aaaa-80da344efa6a" || it.Link != "http://liftoff.msfc.nasa.gov/news/starcity" ||
+		it.Description != "How do Americans get ready to work with Russians?" || it.ContentEncoded != "<p>Full story</p>" {
+		t.Errorf("AtomFeed() item 0 = %v", it)
+	}
+	if len(it.AltLinks) != 2 || it.AltLinks[0].Type != "application/pdf" || it.AltLinks[1].Rel != "replies" {
+		t.Errorf("AtomFeed() item 0 AltLinks = %v", it.AltLinks)
+	}
+	if it.Enclosure == nil || it.Enclosure.URL != "http://liftoff.msfc.nasa.gov/media/starcity.mp3" || it.Enclosure.Length != 1000 {
+		t.Errorf("AtomFeed() item 0 enclosure = %v", it.Enclosure)
+	}
+	if it.PubDate.String() != "2003-06-03T09:39:21Z" || it.UpdatedDate.String() != "2003-06-04T09:39:21Z" {
+		t.Errorf("AtomFeed() item 0 dates = %v, %v", it.PubDate, it.UpdatedDate)
+	}
+	it = ch.Items[1]
+	if it.Link != "http://liftoff.msfc.nasa.gov/news/eclipse" || it.Description != "<p>A partial eclipse.</p>" ||
+		it.PubDate == nil || it.PubDate.String() != "2003-05-30T11:06:42Z" {
+		t.Errorf("AtomFeed() item 1 = %v", it)
+	}
+
+	b, err := rss.ToAtom()
+	if err != nil {
+		t.Fatal("ToAtom failed:", err)
+	}
+	rss2, err := AtomFeed(b)
+	if err != nil || rss2.Channel.Title != ch.Title || len(rss2.Channel.Items) != 2 ||
+		rss2.Channel.Items[0].Link != ch.Items[0].Link || rss2.Channel.Items[0].GUID != ch.Items[0].GUID {
+		t.Errorf("AtomFeed() of ToAtom() = %v, %v", rss2, err)
+	}
+
+	if _, err := AtomFeed([]byte(rss20Text)); err == nil {
+		t.Error("AtomFeed() of an RSS feed succeeded")
+	}
+	if rss, err := Feed([]byte(text)); rss != nil || err == nil {
+		t.Errorf("Feed() of an Atom feed = %v, %v", rss, err)
+	} else if e, ok := err.(*FormatError); !ok || e.Format != "atom" {
+		t.Errorf("Feed() of an Atom feed error != FormatError{Format: \"atom\"}, %v", err)
+	}
+	if _, err := FeedLite([]byte(text)); err == nil {
+		t.Error("FeedLite() of an Atom feed succeeded")
+	}
+}
+
+func TestFeedFromFileFormat(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		name, text, format string
+	}{
+		{"subs.opml", `<?xml version="1.0"?><opml version="2.0"><body><outline text="Liftoff"/></body></opml>`, "opml"},
+		{"feed.xml", `<feed xmlns="http://www.w3.org/2005/Atom"><title>Example</title></feed>`, "atom"},
+		{"feed.txt", `{"version": "https://jsonfeed.org/version/1.1", "title": "Example"}`, "jsonfeed"},
+		{"feed.json", `{}`, "jsonfeed"},
+		{"index.html", "<!DOCTYPE html>\n<html><body>Hello</body></html>", "html"},
+		{"notes.txt", "just some notes", ""},
+	}
+	for _, tt := range tests {
+		filename := dir + "/" + tt.name
+		writeFile(t, filename, tt.text)
+		_, err := FeedFromFile(filename)
+		if e, ok := err.(*FormatError); !ok || e.Format != tt.format {
+			t.Errorf("FeedFromFile(%q) error != FormatError{Format: %q}, %v", tt.name, tt.format, err)
+		}
+	}
+
+	if _, err := FeedFromFile("sample_rss/rss2sample.rss"); err != nil {
+		t.Error("decode of an RSS file failed:", err)
+	}
+}
+
+func TestClassify(t *testing.T) {
+	tests := []struct {
+		text, kind string
+		feeds      []string
+	}{
+		{rss20Text, "feed", nil},
+		{`<feed xmlns="http://www.w3.org/2005/Atom"><title>Example</title></feed>`, "feed", nil},
+		{`{"version": "https://jsonfeed.org/version/1.1", "title": "Example"}`, "feed", nil},
+		{`<?xml version="1.0"?><opml version="2.0"><body>
+			<outline text="Liftoff" xmlUrl="http://liftoff.msfc.nasa.gov/rss"/>
+			<outline text="Space">
+				<outline text="Nested" type="rss" xmlUrl="http://example.com/space.xml"/>
+				<outline text="Again" xmlUrl="http://liftoff.msfc.nasa.gov/rss"/>
+			</outline>
+		</body></opml>`, "opml", []string{"http://liftoff.msfc.nasa.gov/rss", "http://example.com/space.xml"}},
+		{`<?xml version="1.0"?><opml version="2.0"><body><outline text="Empty"/></body></opml>`, "", nil},
+		{`<!DOCTYPE html>
+<html><head>
+<link rel="stylesheet" href="/style.css">
+<LINK REL="alternate" TYPE="application/rss+xml" HREF="/feed.rss?a=1&amp;b=2">
+<link rel=alternate type='application/atom+xml; charset=utf-8' href=http://example.com/atom.xml>
+<link rel="alternate" hreflang="fr" href="/fr/">
+<link rel="alternate" type="application/feed+json" href="/feed.json">
+</head><body><p>Hello</body></html>`, "html-index", []string{"/feed.rss?a=1&b=2", "http://example.com/atom.xml", "/feed.json"}},
+		{"<!DOCTYPE html>\n<html><body>Hello</body></html>", "", nil},
+		{"just some notes", "", nil},
+	}
+	for _, tt := range tests {
+		kind, feeds := Classify([]byte(tt.text))
+		if kind != tt.kind || strings.Join(feeds, " ") != strings.Join(tt.feeds, " ") || (feeds == nil) != (tt.feeds == nil) {
+			t.Errorf("Classify(%.40q) = %q, %q, want %q, %q", tt.text, kind, feeds, tt.kind, tt.feeds)
+		}
+	}
+}
+
+func TestPreview(t *testing.T) {
+	rss, err := Feed([]byte(`<rss version="2.0"><channel>
+<title>Liftoff &amp;amp; &lt;b&gt;News&lt;/b&gt;</title>
+<link>http://liftoff.msfc.nasa.gov/</link>
+<description>Liftoff to Space Exploration.</description>
+<image><url>/images/logo.png</url><title>Liftoff</title><link>http://liftoff.msfc.nasa.gov/</link></image>
+<item>
+<title>Star City</title>
+<link>news/2003/news-starcity.asp</link>
+<description>&lt;p&gt;How do Americans get ready to work with Russians?&lt;/p&gt;&lt;script&gt;alert(1)&lt;/script&gt;</description>
+<enclosure url="http://liftoff.msfc.nasa.gov/media/starcity.jpg" length="1000" type="image/jpeg"/>
+<pubDate>Tue, 03 Jun 2003 09:39:21 GMT</pubDate>
+</item>
+<item>
+<description>&lt;img src="/media/eclipse.png"&gt;Sky watchers in Europe will experience a partial eclipse.</description>
+<link>javascript:alert(1)</link>
+<pubDate>Fri, 30 May 2003 11:06:42 GMT</pubDate>
+</item>
+<item><title>Undated</title></item>
+</channel></rss>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := rss.Preview(0)
+	if p.Title != "Liftoff & News" || p.Link != "http://liftoff.msfc.nasa.gov/" ||
+		p.Image != "http://liftoff.msfc.nasa.gov/images/logo.png" {
+		t.Errorf("Preview channel = %+v", p)
+	}
+	if len(p.Items) != 3 {
+		t.Fatalf("len(Preview items) != 3, %d", len(p.Items))
+	}
+
+	it := p.Items[0]
+	switch {
+	case it.Title != "Star City":
+		t.Errorf("Title != Star City, %q", it.Title)
+	case it.Summary != "How do Americans get ready to work with Russians?":
+		t.Errorf("Summary = %q", it.Summary)
+	case it.Link != "http://liftoff.msfc.nasa.gov/news/2003/news-starcity.asp":
+		t.Errorf("Link = %q", it.Link)
+	case it.Thumbnail != "http://liftoff.msfc.nasa.gov/media/starcity.jpg":
+		t.Errorf("Thumbnail = %q", it.Thumbnail)
+	case it.Date == nil || !it.Date.Equal(time.Date(2003, 6, 3, 9, 39, 21, 0, time.UTC)):
+		t.Errorf("Date = %v", it.Date)
+	}
+
+	it = p.Items[1]
+	switch {
+	case it.Title != "Sky watchers in Europe will experience a partial eclipse.":
+		t.Errorf("Title = %q", it.Title)
+	case it.Link != "":
+		t.Errorf("javascript: link kept, %q", it.Link)
+	case it.Thumbnail != "http://liftoff.msfc.nasa.gov/media/eclipse.png":
+		t.Errorf("Thumbnail = %q", it.Thumbnail)
+	}
+
+	if p.Items[2].Date != nil {
+		t.Errorf("Date of undated item = %v", p.Items[2].Date)
+	}
+
+	if n := len(rss.Preview(1).Items); n != 1 {
+		t.Errorf("len(Preview(1) items) != 1, %d", n)
+	}
+	if _, err := json.Marshal(p); err != nil {
+		t.Error("json.Marshal:", err)
+	}
+}
+
+func TestFeedMaxItems(t *testing.T) {
+	opts := DefaultFeedOptions
+	opts.MaxItems = 2
+	var decoded []string
+	opts.ItemTransform = func(it *RSSItem) bool {
+		decoded = append(decoded, it.GUID.Value)
+		return it.GUID.Value != "a"
+	}
+	rss, err := FeedWithOptions([]byte(testFeed("a", "b", "c", "d", "e")), opts)
+	if err != nil {
+		t.Fatal("decode failed:", err)
+	}
+	if len(rss.Channel.Items) != 2 || rss.Channel.Items[0].GUID.Value != "b" || rss.Channel.Items[1].GUID.Value != "c" {
+		t.Errorf("Items != [b c], %v", rss.Channel.Items)
+	}
+	if strings.Join(decoded, " ") != "a b c" {
+		t.Errorf("items past MaxItems decoded, %v", decoded)
+	}
+}
+
+func TestPodcast(t *testing.T) {
+	rss, err := Feed([]byte(`<rss version="2.0" xmlns:itunes="http://www.itunes.com/dtds/podcast-1.0.dtd"><channel>
+<title>Liftoff Radio</title>
+<link>http://liftoff.msfc.nasa.gov/</link>
+<description>Liftoff to Space Exploration.</description>
+<managingEditor>editor@example.com</managingEditor>
+<category>Space</category>
+<itunes:author> NASA </itunes:author>
+<itunes:category text="Science"><itunes:category text="Astronomy"/></itunes:category>
+<itunes:explicit>no</itunes:explicit>
+<item>
+<title>Star City</title>
+<enclosure url="http://liftoff.msfc.nasa.gov/media/starcity.mp3" length="24986239" type="audio/mpeg"/>
+<itunes:duration>1:02:03</itunes:duration>
+<itunes:episode>2</itunes:episode>
+<itunes:season>1</itunes:season>
+<itunes:explicit>yes</itunes:explicit>
+</item>
+<item><title>Show notes</title><link>http://liftoff.msfc.nasa.gov/notes</link></item>
+<item>
+<title>Eclipse</title>
+<enclosure url="http://liftoff.msfc.nasa.gov/media/eclipse.m4a" length="2498623"/>
+<itunes:duration>754</itunes:duration>
+</item>
+</channel></rss>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rss.Channel.Categories) != 3 || rss.Channel.Categories[0].Value != "Space" {
+		t.Errorf("Categories != [Space Science Science/Astronomy], %v", rss.Channel.Categories)
+	}
+	if !rss.IsPodcast() {
+		t.Fatal("IsPodcast() == false")
+	}
+
+	p := rss.Podcast()
+	if p.Author != "NASA" || p.Explicit || strings.Join(p.Categories, ",") != "Science,Science/Astronomy" {
+		t.Errorf("Podcast() = %+v", p)
+	}
+	if len(p.Episodes) != 2 {
+		t.Fatalf("len(Episodes) != 2, %d", len(p.Episodes))
+	}
+	ep := p.Episodes[0]
+	if ep.Item != &rss.Channel.Items[0] || ep.Audio.Type != "audio/mpeg" || ep.Duration != time.Hour+2*time.Minute+3*time.Second ||
+		ep.Number != 2 || ep.Season != 1 || !ep.Explicit {
+		t.Errorf("Episodes[0] = %+v", ep)
+	}
+	ep = p.Episodes[1]
+	if ep.Item.Title != "Eclipse" || ep.Duration != 754*time.Second || ep.Number != 0 || ep.Explicit {
+		t.Errorf("Episodes[1] = %+v", ep)
+	}
+
+	// A blog feed declaring the iTunes namespace isn't a podcast.
+	rss, _ = Feed([]byte(`<rss version="2.0" xmlns:itunes="http://www.itunes.com/dtds/podcast-1.0.dtd"><channel>
+<title>Blog</title><itunes:author>Me</itunes:author>
+<item><title>Photo</title><enclosure url="http://example.com/a.jpg" length="100" type="image/jpeg"/></item>
+</channel></rss>`))
+	if rss.IsPodcast() || rss.Podcast() != nil {
+		t.Error("blog feed taken for a podcast")
+	}
+}
+
+func TestITunes(t *testing.T) {
+	rss, err := Feed([]byte(`<rss version="2.0" xmlns:itunes="http://www.itunes.com/dtds/podcast-1.0.dtd"><channel>
+<title>Liftoff Radio</title>
+<image><url>http://liftoff.msfc.nasa.gov/logo.png</url><title>Liftoff Radio</title><link>http://liftoff.msfc.nasa.gov/</link></image>
+<itunes:image href=" http://liftoff.msfc.nasa.gov/art.jpg "/>
+<itunes:subtitle>Space news</itunes:subtitle>
+<itunes:summary>News of the space exploration, weekly.</itunes:summary>
+<item>
+<title>Star City</title>
+<author>editor@example.com (Liftoff Editor)</author>
+<itunes:author>Ann Host</itunes:author>
+<itunes:subtitle>A visit</itunes:subtitle>
+<itunes:summary>How do Americans get ready to work with Russians?</itunes:summary>
+<itunes:image href="http://liftoff.msfc.nasa.gov/starcity.jpg"/>
+<itunes:duration>01:02:03</itunes:duration>
+</item>
+<item><title>Eclipse</title><itunes:author>Bob Host</itunes:author></item>
+<item><title>Plain</title><author>editor@example.com</author></item>
+</channel></rss>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ch := rss.Channel
+	if ch.Image == nil || ch.Image.URL != "http://liftoff.msfc.nasa.gov/logo.png" {
+		t.Errorf("Image = %+v", ch.Image)
+	}
+	if c := ch.ITunes; c == nil || c.Image != "http://liftoff.msfc.nasa.gov/art.jpg" || c.Subtitle != "Space news" ||
+		c.Summary != "News of the space exploration, weekly." {
+		t.Errorf("ITunes = %+v", c)
+	}
+
+	it := ch.Items[0]
+	if it.Author != "editor@example.com (Liftoff Editor)" {
+		t.Errorf("Items[0].Author = %q", it.Author)
+	}
+	want := ITunesItem{
+		Author:   "Ann Host",
+		Subtitle: "A visit",
+		Summary:  "How do Americans get ready to work with Russians?",
+		Image:    "http://liftoff.msfc.nasa.gov/starcity.jpg",
+		Duration: time.Hour + 2*time.Minute + 3*time.Second,
+	}
+	if it.ITunes == nil || *it.ITunes != want {
+		t.Errorf("Items[0].ITunes = %+v", it.ITunes)
+	}
+	if it := ch.Items[1]; it.Author != "Bob Host" || it.ITunes == nil || it.ITunes.Author != "Bob Host" {
+		t.Errorf("Items[1] author = %q, %+v", it.Author, it.ITunes)
+	}
+	if it := ch.Items[2]; it.Author != "editor@example.com" || it.ITunes != nil {
+		t.Errorf("Items[2] author = %q, %+v", it.Author, it.ITunes)
+	}
+
+	for _, tt := range []struct {
+		v string
+		d time.Duration
+	}{
+		{"754", 754 * time.Second},
+		{" 12:34 ", 12*time.Minute + 34*time.Second},
+		{"1:02:03", time.Hour + 2*time.Minute + 3*time.Second},
+		{"90.5", 90*time.Second + 500*time.Millisecond},
+		{"", 0},
+		{"1:2:3:4", 0},
+		{"-5", 0},
+		{"an hour", 0},
+		{"NaN", 0},
+		{"Inf", 0},
+		{"-Inf", 0},
+		{"1e30", 0},
+		{"1:1e30", 0},
+	} {
+		if d := parseDuration(tt.v); d != tt.d {
+			t.Errorf("parseDuration(%q) = %v, want %v", tt.v, d, tt.d)
+		}
+	}
+}
+
+func TestOwner(t *testing.T) {
+	rss, err := Feed([]byte(`<rss version="2.0" xmlns:itunes="http://www.itunes.com/dtds/podcast-1.0.dtd"><channel>
+<title>Liftoff Radio</title>
+<link>http://liftoff.msfc.nasa.gov/</link>
+<description>Liftoff to Space Exploration.</description>
+<managingEditor>editor@example.com (Editor)</managingEditor>
+<itunes:owner><itunes:name> NASA </itunes:name><itunes:email>podcast@nasa.gov</itunes:email></itunes:owner>
+</channel></rss>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name, email := rss.Owner(); name != "NASA" || email != "podcast@nasa.gov" {
+		t.Errorf("Owner() != NASA, podcast@nasa.gov, %q, %q", name, email)
+	}
+
+	for _, tt := range []struct {
+		managingEditor, webMaster string
+		name, email               string
+	}{
+		{"geo@herald.com (George Matesky)", "", "George Matesky", "geo@herald.com"},
+		{"", "Betty Guernsey <betty@herald.com>", "Betty Guernsey", "betty@herald.com"},
+		{"", "betty@herald.com", "", "betty@herald.com"},
+		{"George Matesky", "betty@herald.com", "George Matesky", ""},
+		{"", "", "", ""},
+	} {
+		rss := &RSS{Channel: RSSChannel{ManagingEditor: tt.managingEditor, WebMaster: tt.webMaster}}
+		if name, email := rss.Owner(); name != tt.name || email != tt.email {
+			t.Errorf("Owner() of %q, %q = %q, %q", tt.managingEditor, tt.webMaster, name, email)
+		}
+	}
+}
+
+func TestNormalizedTitle(t *testing.T) {
+	tests := []struct {
+		title, want string
+	}{
+		{"Star City", "Star City"},
+		{"  Star\u00a0\u00a0City\t\n", "Star City"},
+		{"“Star\u200b City” — the Russians’ view", "\"Star City\" — the Russians' view"},
+		{"Cafe\u0301 a\u0300 Ge\u0300ne\u0300ve", "Café à Gènève"},
+		{"C\u0327a\x07 va", "Ça va"},
+		{"Tie\u0302\u0301ng Vie\u0323\u0302t", "Tiếng Việt"},
+		{"\u0301x q\u0301", "\u0301x q\u0301"},
+	}
+	for _, tt := range tests {
+		if s := (RSSItem{Title: tt.title}).NormalizedTitle(); s != tt.want {
+			t.Errorf("NormalizedTitle(%q) != %q, %q", tt.title, tt.want, s)
+		}
+	}
+}
+
+func TestPageResponse(t *testing.T) {
+	rss, _ := Feed([]byte(testFeed("a", "b", "c", "d", "e")))
+	tests := []struct {
+		offset, limit int
+		want          string
+	}{
+		{0, 2, "a b"},
+		{2, 2, "c d"},
+		{4, 2, "e"},
+		{5, 2, ""},
+		{9, 2, ""},
+		{-1, 1, "a"},
+		{1, 0, "b c d e"},
+	}
+	for _, tt := range tests {
+		items, total := rss.Channel.PageResponse(tt.offset, tt.limit)
+		var guids []string
+		for _, it := range items {
+			guids = append(guids, it.GUID.Value)
+		}
+		if strings.Join(guids, " ") != tt.want || total != 5 || items == nil {
+			t.Errorf("PageResponse(%d, %d) != [%s], 5; %v, %d", tt.offset, tt.limit, tt.want, guids, total)
+		}
+	}
+}
+
+func TestToMap(t *testing.T) {
+	rss, _ := Feed([]byte(rss20Text))
+	m := rss.Channel.ToMap()
+	if m["title"] != rss.Channel.Title || m["link"] != rss.Channel.Link {
+		t.Errorf("channel ToMap() = %v", m)
+	}
+	if _, ok := m["items"]; ok {
+		t.Error("channel ToMap() has items")
+	}
+
+	it := RSSItem{
+		Title:      "Star City",
+		Categories: []RSSCategory{{Value: "Space"}},
+		Enclosure:  &RSSEnclosure{URL: "http://example.com/a.mp3", Length: 100, Type: "audio/mpeg"},
+	}
+	m = it.ToMap()
+	if m["title"] != "Star City" || m["author"] != "" || !m["date"].(time.Time).IsZero() {
+		t.Errorf("item ToMap() = %v", m)
+	}
+	if ca := m["categories"].([]string); len(ca) != 1 || ca[0] != "Space" {
+		t.Errorf("item ToMap()[\"categories\"] != [Space], %v", ca)
+	}
+	if enc := m["enclosure"].(map[string]interface{}); enc["url"] != "http://example.com/a.mp3" || enc["length"] != int64(100) {
+		t.Errorf("item ToMap()[\"enclosure\"] = %v", enc)
+	}
+
+	var b strings.Builder
+	tmpl := template.Must(template.New("").Parse(`{{.title}} ({{index .categories 0}})`))
+	if err := tmpl.Execute(&b, m); err != nil || b.String() != "Star City (Space)" {
+		t.Errorf("template output != \"Star City (Space)\", %q, %v", b.String(), err)
+	}
+}
+
+func TestEnclosureDecodeData(t *testing.T) {
+	tests := []struct {
+		url, data, mediaType string
+	}{
+		{"data:image/gif;base64,R0lGODlhAQABAAAAACw=", "GIF89a\x01\x00\x01\x00\x00\x00\x00,", "image/gif"},
+		{"DATA:image/gif;base64,R0lG\n ODlh", "GIF89a", "image/gif"},
+		{"data:,Star%20City", "Star City", "text/plain;charset=US-ASCII"},
+		{"data:;charset=utf-8,Caf%C3%A9", "Café", "text/plain;charset=utf-8"},
+	}
+	for _, tt := range tests {
+		ec := RSSEnclosure{URL: tt.url}
+		if !ec.IsDataURI() {
+			t.Errorf("IsDataURI(%q) == false", tt.url)
+		}
+		b, mediaType, err := ec.DecodeData()
+		if err != nil || string(b) != tt.data || mediaType != tt.mediaType {
+			t.Errorf("DecodeData(%q) != %q, %q; %q, %q, %v", tt.url, tt.data, tt.mediaType, b, mediaType, err)
+		}
+	}
+
+	for _, url := range []string{"http://example.com/a.gif", "data:image/gif;base64", "data:image/gif;base64,R0l*"} {
+		if _, _, err := (RSSEnclosure{URL: url}).DecodeData(); err == nil {
+			t.Errorf("DecodeData(%q) didn't fail", url)
+		}
+	}
+	if (RSSEnclosure{URL: "http://example.com/a.gif"}).IsDataURI() {
+		t.Error("IsDataURI() of an http URL == true")
+	}
+}
+
+func TestUse(t *testing.T) {
+	filename := t.TempDir() + "/feed.rss"
+	writeFile(t, filename, testFeed("a"))
+	rss, err := FeedFromFile(filename)
+	if err != nil {
+		t.Fatal("decode failed:", err)
+	}
+
+	var calls []string
+	rss.Use(func(rss *RSS) error {
+		calls = append(calls, "title")
+		for i := range rss.Channel.Items {
+			rss.Channel.Items[i].Title = strings.ToUpper(rss.Channel.Items[i].Title)
+		}
+		return nil
+	}, func(rss *RSS) error {
+		calls = append(calls, "drop")
+		rss.Channel.Items = rss.Channel.Items[1:]
+		return nil
+	})
+
+	writeFile(t, filename, testFeed("a", "b", "c"))
+	newItems, err := rss.Update()
+	if err != nil {
+		t.Fatal("update failed:", err)
+	}
+	if strings.Join(calls, " ") != "title drop" {
+		t.Errorf("transforms called as [%s], want [title drop]", strings.Join(calls, " "))
+	}
+	if len(newItems) != 2 || newItems[0].Title != "B" || newItems[1].Title != "C" {
+		t.Errorf("Update() != [B C], %v", newItems)
+	}
+
+	fail := errors.New("broken feed")
+	rss.Use(func(*RSS) error { return fail })
+	writeFile(t, filename, testFeed("a", "b", "c", "d"))
+	if _, err := rss.Update(); err != fail {
+		t.Errorf("Update() error != %v, %v", fail, err)
+	}
+	if len(rss.Channel.Items) != 2 {
+		t.Errorf("failed transform changed the items, %v", rss.Channel.Items)
+	}
+}
+
+func TestNewFeedFromItems(t *testing.T) {
+	items := []RSSItem{{Title: "Star City", Link: "http://liftoff.msfc.nasa.gov/news/2003/news-starcity.asp"}}
+	rss := NewFeedFromItems("Liftoff News", "http://liftoff.msfc.nasa.gov/", "Liftoff to Space Exploration.", items)
+	if rss.Version != "2.0" || rss.Channel.LastBuildDate == nil || time.Since(time.Time(*rss.Channel.LastBuildDate)) > time.Minute {
+		t.Errorf("NewFeedFromItems() = %v", rss)
+	}
+
+	b, err := rss.ToXMLWithOptions(XMLOptions{PreserveDates: true})
+	if err != nil {
+		t.Fatal("encode failed:", err)
+	}
+	rss2, err := Feed(b)
+	if err != nil {
+		t.Fatal("decode failed:", err)
+	}
+	if rss2.Channel.Title != "Liftoff News" || len(rss2.Channel.Items) != 1 || rss2.Channel.Items[0].Title != "Star City" {
+		t.Errorf("feed didn't round-trip, %v", rss2)
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	for _, filename := range []string{"sample_rss/rss2sample.rss", "sample_rss/rss2entries.rss", "sample_rss/podcast.rss"} {
+		rss, err := FeedFromFile(filename)
+		if err != nil {
+			t.Fatal("decode failed:", err)
+		}
+		rss2, err := RoundTrip(rss)
+		if err != nil {
+			t.Fatal("RoundTrip failed:", err)
+		}
+		if a, b := rss.Dump(), rss2.Dump(); a != b {
+			t.Errorf("%s didn't round-trip:\n%s\n%s", filename, a, b)
+		}
+	}
+
+	// The podcast elements have to be there to survive.
+	rss, err := FeedFromFile("sample_rss/podcast.rss")
+	if err != nil {
+		t.Fatal("decode failed:", err)
+	}
+	c := rss.Channel
+	if c.NewLocation == "" || c.ITunes == nil || len(c.ITunes.Categories) != 4 || c.ITunes.OwnerEmail == "" {
+		t.Errorf("podcast channel not decoded, %v", c)
+	}
+	it := c.Items[0]
+	if len(it.Media) != 3 || it.Media[2].Bitrate != 32 || it.ITunes == nil || it.ITunes.Duration != 26*time.Minute+time.Second {
+		t.Errorf("podcast item not decoded, %v", it)
+	}
+}
+
+func TestFeedsEqual(t *testing.T) {
+	a, err := FeedFromFile("sample_rss/rss2sample.rss")
+	if err != nil {
+		t.Fatal("decode failed:", err)
+	}
+	if !FeedsEqual(a, a) {
+		t.Error("FeedsEqual(a, a) is false")
+	}
+
+	b, err := RoundTrip(a)
+	if err != nil {
+		t.Fatal("RoundTrip failed:", err)
+	}
+	items := b.Channel.Items
+	items[0], items[3] = items[3], items[0]
+	items[1].Title = "\n  " + strings.Replace(items[1].Title, " ", "   ", -1) + "\t"
+	d := RFC822(time.Time(*items[2].PubDate).In(time.FixedZone("CST", 8*60*60)))
+	items[2].PubDate = &d
+	if !FeedsEqual(a, b) {
+		t.Error("FeedsEqual() is false for a reordered and reformatted copy")
+	}
+
+	b.Channel.Image = &RSSImage{}
+	if !FeedsEqual(a, b) {
+		t.Error("FeedsEqual() is false for an empty image")
+	}
+	b.Channel.Image = nil
+
+	items[1].Title = "Another title"
+	if FeedsEqual(a, b) {
+		t.Error("FeedsEqual() is true for a changed item")
+	}
+	items[1] = a.Channel.Items[1]
+	b.Channel.Categories = append(b.Channel.Categories, RSSCategory{Value: "News"})
+	if FeedsEqual(a, b) || FeedsEqual(b, a) {
+		t.Error("FeedsEqual() is true for a changed channel")
+	}
+	b.Channel.Categories = a.Channel.Categories
+	b.Channel.Items = items[:3]
+	if FeedsEqual(a, b) {
+		t.Error("FeedsEqual() is true with an item missing")
+	}
+}
+
+func TestItemAsFeed(t *testing.T) {
+	it := RSSItem{Title: "Star City", Link: "http://liftoff.msfc.nasa.gov/news/2003/news-starcity.asp"}
+	rss := it.AsFeed("", "")
+	ch := rss.Channel
+	if ch.Title != "Star City" || ch.Link != it.Link || ch.Description == "" {
+		t.Errorf("AsFeed(\"\", \"\").Channel = %v", ch)
+	}
+
+	rss = it.AsFeed("Liftoff News", "http://liftoff.msfc.nasa.gov/")
+	b, err := rss.ToXML()
+	if err != nil {
+		t.Fatal("encode failed:", err)
+	}
+	rss2, err := Feed(b)
+	if err != nil {
+		t.Fatal("decode failed:", err)
+	}
+	ch = rss2.Channel
+	if ch.Title != "Liftoff News" || ch.Description != "Liftoff News: Star City" || len(ch.Items) != 1 || ch.Items[0].Link != it.Link {
+		t.Errorf("feed didn't round-trip, %v", rss2)
+	}
+}
+
+func TestMergeSeries(t *testing.T) {
+	date := func(day int) *RFC822 {
+		d := RFC822(time.Date(2018, 1, day, 0, 0, 0, 0, time.UTC))
+		return &d
+	}
+	ch := RSSChannel{Items: []RSSItem{
+		{Title: "The Long Read (Part 2 of 2)", Description: "second", PubDate: date(2)},
+		{Title: "News"},
+		{Title: "The long read (Part 1 of 2)", Description: "first", PubDate: date(1), GUID: GUID{"1", true}},
+		{Title: "Part 3: Other story", Description: "alone"},
+	}}
+
+	items := ch.MergeSeries()
+	if len(items) != 3 {
+		t.Fatalf("len(MergeSeries()) != 3, %v", items)
+	}
+	merged := items[0]
+	if merged.Title != "The long read" || merged.Description != "first\nsecond" || merged.GUID.Value != "1" ||
+		!merged.PubDate.Equal(*date(2)) {
+		t.Errorf("merged item = %v", merged)
+	}
+	if items[1].Title != "News" || items[2].Title != "Part 3: Other story" {
+		t.Errorf("unmerged items changed, %v", items[1:])
+	}
+	if ch.Items[0].Title != "The Long Read (Part 2 of 2)" {
+		t.Error("MergeSeries modified c.Items")
+	}
+
+	chapters := regexp.MustCompile(`\s*Chapter (\d+)`)
+	ch = RSSChannel{Items: []RSSItem{{Title: "Book Chapter 2", Description: "b"}, {Title: "Book Chapter 1", Description: "a"}}}
+	if items := ch.MergeSeriesWith(chapters); len(items) != 1 || items[0].Title != "Book" || items[0].Description != "a\nb" {
+		t.Errorf("MergeSeriesWith(chapters) = %v", items)
+	}
+}
+
+func TestChannelCategoryVariants(t *testing.T) {
+	tests := []struct {
+		name, categories string
+	}{
+		{"direct", `<category>Space</category><category domain="http://example.com/">NASA</category>`},
+		{"wrapped", `<categories><category>Space</category><category domain="http://example.com/">NASA</category></categories>`},
+		{"mixed", `<category>Space</category><categories><category domain="http://example.com/">NASA</category></categories>`},
+		{"itunes", `<itunes:category text="Space"/><itunes:category text="NASA"/>`},
+		{"itunes duplicate", `<category>Space</category><category domain="http://example.com/">NASA</category><itunes:category text="space"/>`},
+	}
+	for _, tt := range tests {
+		rss, err := Feed([]byte(`<rss version="2.0" xmlns:itunes="http://www.itunes.com/dtds/podcast-1.0.dtd"><channel><title>Liftoff News</title>` +
+			tt.categories + `</channel></rss>`))
+		if err != nil {
+			t.Fatalf("%s: decode failed: %v", tt.name, err)
+		}
+		var values []string
+		for _, ca := range rss.Channel.Categories {
+			values = append(values, ca.Value)
+		}
+		if strings.Join(values, ",") != "Space,NASA" {
+			t.Errorf("%s: Categories != [Space NASA], %v", tt.name, values)
+		}
+	}
+}
+
+func TestToAtom(t *testing.T) {
+	rss, err := FeedFromFile("sample_rss/rss2sample.rss")
+	if err != nil {
+		t.Fatal("decode failed:", err)
+	}
+	rss.Channel.Items[1].ContentEncoded = "<p>Full story</p>"
+	b, err := rss.ToAtom()
+	if err != nil {
+		t.Fatal("encode failed:", err)
+	}
+
+	var feed struct {
+		XMLName xml.Name
+		ID      string `xml:"id"`
+		Title   string `xml:"title"`
+		Updated string `xml:"updated"`
+		Entries []struct {
+			ID      string `xml:"id"`
+			Title   string `xml:"title"`
+			Updated string `xml:"updated"`
+			Links   []Link `xml:"link"`
+		} `xml:"entry"`
+	}
+	if err := xml.Unmarshal(b, &feed); err != nil {
+		t.Fatal("Atom output isn't well-formed:", err)
+	}
+	if feed.XMLName.Space != atomNS || feed.XMLName.Local != "feed" || feed.ID != "http://liftoff.msfc.nasa.gov/" ||
+		feed.Title != rss.Channel.Title || feed.Updated != rss.Channel.LastBuildDate.String() {
+		t.Errorf("Atom feed = %s %q %q %q", feed.XMLName, feed.ID, feed.Title, feed.Updated)
+	}
+	if len(feed.Entries) != len(rss.Channel.Items) {
+		t.Fatalf("len(entries) != %d, %d", len(rss.Channel.Items), len(feed.Entries))
+	}
+	for i, e := range feed.Entries {
+		it := rss.Channel.Items[i]
+		if e.ID == "" || e.Updated == "" || e.Title != it.Title {
+			t.Errorf("entry %d = %+v", i, e)
+		}
+	}
+	if rss2, err := AtomFeed(b); err != nil || rss2.Channel.Items[1].ContentEncoded != "<p>Full story</p>" ||
+		rss2.Channel.Items[1].Description != rss.Channel.Items[1].Description {
+		t.Errorf("ContentEncoded didn't round-trip through Atom, %s", b)
+	}
+
+	// Without a self link or link, the feed is named after its content.
+	rss.Channel.Link = ""
+	rss.Channel.AtomLinks = nil
+	if b, err = rss.ToAtom(); err != nil {
+		t.Fatal("encode failed:", err)
+	}
+	if err := xml.Unmarshal(b, &feed); err != nil {
+		t.Fatal("Atom output isn't well-formed:", err)
+	}
+	if !strings.HasPrefix(feed.ID, "urn:sha1:") {
+		t.Errorf("id of a feed without links = %q", feed.ID)
+	}
+	rss.Channel.Title = "Other News"
+	if b, _ = rss.ToAtom(); strings.Contains(string(b), "<id>"+feed.ID+"</id>") {
+		t.Errorf("feeds with different titles share the id %q", feed.ID)
+	}
+}
+
+func TestToJSONFeed(t *testing.T) {
+	rss, err := FeedFromFile("sample_rss/rss2sample.rss")
+	if err != nil {
+		t.Fatal("decode failed:", err)
+	}
+	rss.Channel.Items = append(rss.Channel.Items, RSSItem{
+		Title:     "Podcast",
+		Enclosure: &RSSEnclosure{URL: "http://example.com/a.mp3", Length: 100, Type: "audio/mpeg"},
+	})
+	rss.Channel.Items[1].ContentEncoded = "<p>Full story</p>"
+	b, err := rss.ToJSONFeed()
+	if err != nil {
+		t.Fatal("encode failed:", err)
+	}
+
+	var feed struct {
+		Version     string `json:"version"`
+		Title       string `json:"title"`
+		HomePageURL string `json:"home_page_url"`
+		Items       []struct {
+			ID            string `json:"id"`
+			ContentHTML   string `json:"content_html"`
+			DatePublished string `json:"date_published"`
+			Attachments   []struct {
+				URL string `json:"url"`
+			} `json:"attachments"`
+		} `json:"items"`
+	}
+	if err := json.Unmarshal(b, &feed); err != nil {
+		t.Fatal("JSON Feed output is malformed:", err)
+	}
+	if feed.Version != "https://jsonfeed.org/version/1.1" || feed.Title != rss.Channel.Title || feed.HomePageURL != rss.Channel.Link {
+		t.Errorf("JSON Feed = %+v", feed)
+	}
+	if len(feed.Items) != len(rss.Channel.Items) {
+		t.Fatalf("len(items) != %d, %d", len(rss.Channel.Items), len(feed.Items))
+	}
+	if feed.Items[0].ID != rss.Channel.Items[0].GUID.Value || feed.Items[0].DatePublished != rss.Channel.Items[0].PubDate.String() ||
+		feed.Items[0].ContentHTML != rss.Channel.Items[0].Description {
+		t.Errorf("items[0] = %+v", feed.Items[0])
+	}
+	if c := feed.Items[1].ContentHTML; c != "<p>Full story</p>" {
+		t.Errorf("items[1].content_html != ContentEncoded, %q", c)
+	}
+	last := feed.Items[len(feed.Items)-1]
+	if last.ID == "" || len(last.Attachments) != 1 || last.Attachments[0].URL != "http://example.com/a.mp3" {
+		t.Errorf("item with enclosure = %+v", last)
+	}
+}
+
+func TestMerge(t *testing.T) {
+	date := func(day int) *RFC822 {
+		d := RFC822(time.Date(2018, 1, day, 0, 0, 0, 0, time.UTC))
+		return &d
+	}
+	a := &RSS{Channel: RSSChannel{Title: "A", Items: []RSSItem{
+		{Title: "Story", Link: "http://example.com/story", PubDate: date(1)},
+		{Title: "Only in A", GUID: GUID{"a1", true}, PubDate: date(3)},
+	}}}
+	b := &RSS{Channel: RSSChannel{Title: "B", Items: []RSSItem{
+		{Title: "Story, as told by B", Link: "http://example.com/story", PubDate: date(2)},
+		{Title: "Only in B", GUID: GUID{"b1", true}},
+	}}}
+
+	r := Merge(nil, a, nil, b)
+	var titles []string
+	for _, it := range r.Items {
+		titles = append(titles, it.Title)
+	}
+	if got, want := strings.Join(titles, ","), "Only in A,Story,Only in B"; got != want {
+		t.Errorf("Merge() items = %s, want %s", got, want)
+	}
+	if len(r.Duplicates) != 1 {
+		t.Fatalf("Merge() found %d duplicates, want 1", len(r.Duplicates))
+	}
+	g := r.Duplicates[0]
+	if g.Key != "http://example.com/story" || len(g.Items) != 2 || len(g.Sources) != 2 ||
+		g.Items[1].Title != "Story, as told by B" || g.Sources[0] != a || g.Sources[1] != b {
+		t.Errorf("Merge() duplicate group = %+v", g)
+	}
+
+	if r := Merge(func(RSSItem) string { return "" }, a, b); len(r.Items) != 1 || len(r.Duplicates[0].Items) != 4 {
+		t.Errorf("Merge() with a constant key = %d items", len(r.Items))
+	}
+	if r := Merge(nil); len(r.Items) != 0 || r.Duplicates != nil {
+		t.Errorf("Merge() of no feeds = %+v", r)
+	}
+}
+
+func TestContentKey(t *testing.T) {
+	it := RSSItem{
+		Title:       "Star Citi",
+		Link:        "http://liftoff.msfc.nasa.gov/news/2003/news-starcity.asp",
+		Description: "<p>How do Americans get ready to work with Russians aboard the International Space Station?</p>",
+		GUID:        GUID{"1", true},
+	}
+	edited := it
+	edited.Title = "Star City"
+	edited.GUID = GUID{"2", true}
+	edited.Link = "https://www.liftoff.msfc.nasa.gov/news/2003/news-starcity.asp?utm_source=rss"
+	edited.Description = "How do  americans get ready to work with Russians aboard the International Space Station?"
+	if ContentKey(it) != ContentKey(edited) {
+		t.Error("ContentKey() changed with the title, GUID, scheme and markup")
+	}
+	other := it
+	other.Link = "http://liftoff.msfc.nasa.gov/news/2003/news-VeniceFilmFestival.asp"
+	if ContentKey(it) == ContentKey(other) {
+		t.Error("ContentKey() of an item with another link didn't change")
+	}
+
+	old := NewFeedFromItems("Liftoff News", "http://liftoff.msfc.nasa.gov/", "", []RSSItem{it})
+	cur := NewFeedFromItems("Liftoff News", "http://liftoff.msfc.nasa.gov/", "", []RSSItem{edited})
+	if d := old.Diff(cur); len(d.Added) != 1 {
+		t.Errorf("Diff() without DedupKey didn't add the republished item, %+v", d)
+	}
+	old.DedupKey = ContentKey
+	if d := old.Diff(cur); len(d.Added) != 0 || len(d.Changed) != 1 {
+		t.Errorf("Diff() with ContentKey didn't match the republished item, %+v", d)
+	}
+}
+
+func TestItemsMissingFields(t *testing.T) {
+	rss, err := FeedFromFile("sample_rss/rss2sample.rss")
+	if err != nil {
+		t.Fatal("decode failed:", err)
+	}
+	ch := rss.Channel
+	ch.Items = append(ch.Items, RSSItem{Title: "Undated", GUID: GUID{"undated", true}, Link: "http://example.com/"}, RSSItem{})
+	n := len(ch.Items)
+
+	missing := ch.ItemsMissingFields()
+	// The second item of the sample has no link.
+	if len(missing) != 3 || strings.Join(missing[1], ",") != "link" {
+		t.Errorf("ItemsMissingFields() != {1: [link], ...}, %v", missing)
+	}
+	if f := strings.Join(missing[n-2], ","); f != "pubDate" {
+		t.Errorf("missing fields of undated item != [pubDate], %v", f)
+	}
+	if f := strings.Join(missing[n-1], ","); f != "title or description,link,guid,pubDate" {
+		t.Errorf("missing fields of empty item = [%s]", f)
+	}
+}
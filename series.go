@@ -0,0 +1,100 @@
+// Copyright 2018 cotox. All rights reserved.
+// Use of this source code is governed by a GPLv3
+// license that can be found in the LICENSE file.
+
+package rssutil
+
+import (
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// DefaultSeriesMarker is the part marker used by MergeSeries. It matches
+// "Part 2", "(Pt. 2 of 3)", "- part 2/3" and the like; its first group
+// is the part number.
+var DefaultSeriesMarker = regexp.MustCompile(`(?i)\s*[-–—:,(\[]?\s*\b(?:part|pt\.?)\s*(\d+)(?:\s*(?:of|/)\s*\d+)?\s*[)\]]?`)
+
+// MergeSeries returns the items of c with the parts of multi-part posts
+// stitched together, as MergeSeriesWith does with DefaultSeriesMarker.
+func (c RSSChannel) MergeSeries() []RSSItem {
+	return c.MergeSeriesWith(DefaultSeriesMarker)
+}
+
+// MergeSeriesWith returns the items of c with the parts of multi-part
+// posts stitched together, leaving c.Items as they are. The part of an
+// item is given by the first match of marker in its title, whose first
+// group must be the part number; items whose titles are the same once
+// the match is removed, compared as NormalizedTitle does, are parts of
+// one post, whatever their case.
+//
+// A post of several parts becomes a single item, in the place of its
+// first part in c: the lowest numbered part, titled without the marker,
+// with the descriptions of the parts joined in part order, and the
+// pubDate of the newest part. Items without a marker, and posts of a
+// single part, are returned unchanged.
+func (c RSSChannel) MergeSeriesWith(marker *regexp.Regexp) []RSSItem {
+	type part struct {
+		n     int
+		title string // the title without the marker
+		it    RSSItem
+	}
+	var keys []string // the series and lone items, in order of appearance
+	series := make(map[string][]part)
+	for i, it := range c.Items {
+		key, title, n := seriesPart(it, marker)
+		if key == "" {
+			// Keyed by index, which no title yields, to keep its place.
+			key = "\x00" + strconv.Itoa(i)
+		}
+		if _, ok := series[key]; !ok {
+			keys = append(keys, key)
+		}
+		series[key] = append(series[key], part{n, title, it})
+	}
+
+	items := make([]RSSItem, 0, len(keys))
+	for _, key := range keys {
+		parts := series[key]
+		if len(parts) == 1 {
+			items = append(items, parts[0].it)
+			continue
+		}
+		sort.SliceStable(parts, func(i, j int) bool { return parts[i].n < parts[j].n })
+
+		merged := parts[0].it
+		merged.Title = parts[0].title
+		var descriptions []string
+		for _, p := range parts {
+			if p.it.Description != "" {
+				descriptions = append(descriptions, p.it.Description)
+			}
+			if newer(p.it.PubDate, merged.PubDate) {
+				merged.PubDate = p.it.PubDate
+			}
+		}
+		merged.Description = strings.Join(descriptions, "\n")
+		items = append(items, merged)
+	}
+	return items
+}
+
+// seriesPart returns the series key of it, its title without the part
+// marker and its part number, or a "" key if its title has no marker.
+func seriesPart(it RSSItem, marker *regexp.Regexp) (key, title string, n int) {
+	m := marker.FindStringSubmatchIndex(it.Title)
+	if m == nil || len(m) < 4 || m[2] < 0 {
+		return "", "", 0
+	}
+	n, err := strconv.Atoi(it.Title[m[2]:m[3]])
+	if err != nil {
+		return "", "", 0
+	}
+	title = strings.Trim(it.Title[:m[0]]+" "+it.Title[m[1]:], " -–—:,")
+	key = strings.ToLower(RSSItem{Title: title}.NormalizedTitle())
+	if key == "" {
+		return "", "", 0
+	}
+	return key, title, n
+}
@@ -0,0 +1,141 @@
+// Copyright 2018 cotox. All rights reserved.
+// Use of this source code is governed by a GPLv3
+// license that can be found in the LICENSE file.
+
+package rssutil
+
+import (
+	"encoding/json"
+	"io"
+	"time"
+)
+
+// feedState is the persisted form of an RSS, see SaveState.
+type feedState struct {
+	Source       string     `json:"source,omitempty"`
+	SourceKind   SourceKind `json:"sourceKind"`
+	ETag         string     `json:"etag,omitempty"`
+	LastModified string     `json:"lastModified,omitempty"`
+	ModTime      time.Time  `json:"modTime"`
+	LastUpdateAt time.Time  `json:"lastUpdateAt"`
+	MovedTo      string     `json:"movedTo,omitempty"`
+	MaxAge       *int64     `json:"maxAge,omitempty"` // in seconds
+	Version      string     `json:"version"`
+	Channel      RSSChannel `json:"channel"`
+
+	ItemStates map[string]ItemState `json:"itemStates,omitempty"`
+}
+
+// SaveState writes the state of rss to w as JSON: its content, where it
+// was read from, the validators of the last fetch and when it was last
+// updated, along with the ItemStates set on it. LoadState restores it,
+// so a feed served again after a restart knows which items it has
+// already seen and can keep making conditional requests.
+//
+// The configuration of rss, such as TTLSkew, and its notifiers are not
+// saved.
+func (rss *RSS) SaveState(w io.Writer) error {
+	// Held while encoding, as the item states are encoded from the map.
+	rss.mu.Lock()
+	defer rss.mu.Unlock()
+
+	state := feedState{
+		Source:       rss.source,
+		SourceKind:   rss.sourceKind,
+		ETag:         rss.etag,
+		LastModified: rss.lastModified,
+		ModTime:      rss.modTime,
+		LastUpdateAt: rss.lastUpdateAt,
+		MovedTo:      rss.movedTo,
+		Version:      rss.Version,
+		Channel:      rss.Channel,
+		ItemStates:   rss.itemStates,
+	}
+	if rss.hasMaxAge {
+		secs := int64(rss.maxAge / time.Second)
+		state.MaxAge = &secs
+	}
+	if err := json.NewEncoder(w).Encode(state); err != nil {
+		logErr(err)
+		return err
+	}
+	return nil
+}
+
+// LoadState reads an RSS written by SaveState from r.
+func LoadState(r io.Reader) (*RSS, error) {
+	var state feedState
+	if err := json.NewDecoder(r).Decode(&state); err != nil {
+		logErr(err)
+		return nil, err
+	}
+
+	rss := &RSS{
+		Version:      state.Version,
+		Channel:      state.Channel,
+		source:       state.Source,
+		sourceKind:   state.SourceKind,
+		etag:         state.ETag,
+		lastModified: state.LastModified,
+		modTime:      state.ModTime,
+		lastUpdateAt: state.LastUpdateAt,
+		movedTo:      state.MovedTo,
+		itemStates:   state.ItemStates,
+	}
+	if state.MaxAge != nil {
+		rss.maxAge, rss.hasMaxAge = time.Duration(*state.MaxAge)*time.Second, true
+	}
+	return rss, nil
+}
+
+// ItemState is the state a reader keeps about an item, such as whether
+// it was read, apart from the content of the feed. See SetItemState.
+type ItemState struct {
+	Read    bool `json:"read,omitempty"`
+	Starred bool `json:"starred,omitempty"`
+}
+
+// SetItemState records state for the item known to rss as guid, its GUID
+// unless rss.DedupKey says otherwise (see ItemKey). The state is kept by
+// rss as Update and Serve replace its items, so an item a new copy of
+// the feed still has keeps its state. The zero ItemState forgets it.
+func (rss *RSS) SetItemState(guid string, state ItemState) {
+	rss.mu.Lock()
+	defer rss.mu.Unlock()
+	if state == (ItemState{}) {
+		delete(rss.itemStates, guid)
+		return
+	}
+	if rss.itemStates == nil {
+		rss.itemStates = make(map[string]ItemState)
+	}
+	rss.itemStates[guid] = state
+}
+
+// ItemStateOf returns the state recorded for the item known to rss as
+// guid, or the zero ItemState.
+func (rss *RSS) ItemStateOf(guid string) ItemState {
+	rss.mu.Lock()
+	defer rss.mu.Unlock()
+	return rss.itemStates[guid]
+}
+
+// ItemKey returns the key by which rss knows it, for SetItemState: its
+// GUID, falling back to its link, then to its title and description, or
+// that given by rss.DedupKey if set.
+func (rss *RSS) ItemKey(it RSSItem) string { return rss.itemKey(it) }
+
+// PruneItemStates forgets the states of the items rss no longer has.
+func (rss *RSS) PruneItemStates() {
+	keep := make(map[string]bool, len(rss.Channel.Items))
+	for _, it := range rss.Channel.Items {
+		keep[rss.itemKey(it)] = true
+	}
+	rss.mu.Lock()
+	defer rss.mu.Unlock()
+	for guid := range rss.itemStates {
+		if !keep[guid] {
+			delete(rss.itemStates, guid)
+		}
+	}
+}
@@ -0,0 +1,119 @@
+// Copyright 2018 cotox. All rights reserved.
+// Use of this source code is governed by a GPLv3
+// license that can be found in the LICENSE file.
+
+package rssutil
+
+import (
+	"html"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// ContinuationMarkers are the endings IsTruncated looks for in a
+// description, compared without regard to case. Replace or extend it to
+// match the language of your feeds.
+var ContinuationMarkers = []string{
+	"…",
+	"...",
+	"[…]",
+	"[...]",
+	"read more",
+	"continue reading",
+	"more »",
+}
+
+// TruncatedTextLen is the length in characters under which a description
+// that ends with a link is taken to be a teaser for the linked article.
+var TruncatedTextLen = 300
+
+// htmlTagRE matches HTML tags and comments.
+var htmlTagRE = regexp.MustCompile(`<!--[\s\S]*?-->|<[^>]*>`)
+
+// htmlScriptRE matches HTML script and style elements, whose content isn't
+// text.
+var htmlScriptRE = regexp.MustCompile(`(?is)<script\b.*?</script\s*>|<style\b.*?</style\s*>`)
+
+// trailingLinkRE matches an HTML anchor closing a description.
+var trailingLinkRE = regexp.MustCompile(`(?i)<a\s[^>]*>[^<]*</a>\s*(?:</p>\s*)?$`)
+
+// SummaryLen is the length in characters within which SummaryAndBody
+// looks for the end of a sentence to cut a plain text description at.
+var SummaryLen = 300
+
+// moreRE matches the marker blogs put in their posts where the teaser
+// ends.
+var moreRE = regexp.MustCompile(`(?i)<!--\s*more\b[^>]*-->`)
+
+// firstParagraphRE matches an HTML description opening with a paragraph.
+var firstParagraphRE = regexp.MustCompile(`(?is)^\s*<p\b[^>]*>.*?</p>`)
+
+// sentenceEndRE matches the end of a sentence and the space after it.
+var sentenceEndRE = regexp.MustCompile(`[.!?…]["')\]]?\s+`)
+
+// SummaryAndBody splits the content of it in a short summary, for lists,
+// and the body following it, for reading. An item with ContentEncoded
+// has its description as summary and ContentEncoded as body. Otherwise
+// the description is split at a <!-- more --> marker, or else after its
+// first paragraph, or else, if it is plain text, at the last end of a
+// sentence within SummaryLen characters. A description that can't be
+// split is all summary; body is "".
+func (it RSSItem) SummaryAndBody() (summary, body string) {
+	if it.ContentEncoded != "" {
+		return it.Description, it.ContentEncoded
+	}
+	d := strings.TrimSpace(it.Description)
+
+	if loc := moreRE.FindStringIndex(d); loc != nil {
+		return strings.TrimSpace(d[:loc[0]]), strings.TrimSpace(d[loc[1]:])
+	}
+	if loc := firstParagraphRE.FindStringIndex(d); loc != nil {
+		return strings.TrimSpace(d[:loc[1]]), strings.TrimSpace(d[loc[1]:])
+	}
+	if strings.ContainsRune(d, '<') || utf8.RuneCountInString(d) <= SummaryLen {
+		return d, ""
+	}
+
+	cut := -1
+	for _, loc := range sentenceEndRE.FindAllStringIndex(d, -1) {
+		if utf8.RuneCountInString(d[:loc[0]]) >= SummaryLen {
+			break
+		}
+		cut = loc[1]
+	}
+	if cut < 0 {
+		return d, ""
+	}
+	return strings.TrimSpace(d[:cut]), d[cut:]
+}
+
+// IsTruncated reports whether the description of it looks like an excerpt
+// of a longer article rather than its full content: its text ends with
+// one of ContinuationMarkers, possibly followed by the link to the
+// article, or it is shorter than TruncatedTextLen and ends with a link.
+// It is a heuristic, meant to decide whether fetching the linked page is
+// worthwhile.
+func (it RSSItem) IsTruncated() bool {
+	text := strings.ToLower(plainText(it.Description))
+	if text == "" {
+		return false
+	}
+
+	for _, m := range ContinuationMarkers {
+		if m != "" && strings.HasSuffix(text, strings.ToLower(m)) {
+			return true
+		}
+	}
+
+	return trailingLinkRE.MatchString(it.Description) &&
+		utf8.RuneCountInString(text) < TruncatedTextLen
+}
+
+// plainText returns the text of the HTML fragment s, without markup and
+// with entities replaced, trimmed of surrounding space.
+func plainText(s string) string {
+	s = htmlScriptRE.ReplaceAllString(s, " ")
+	s = htmlTagRE.ReplaceAllString(s, " ")
+	return strings.Join(strings.Fields(html.UnescapeString(s)), " ")
+}
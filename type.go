@@ -8,6 +8,8 @@ import (
 	"encoding/json"
 	"encoding/xml"
 	"fmt"
+	"net/http"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -36,28 +38,121 @@ type RSS struct {
 	Version string     `xml:"version,attr" json:"version"`
 	Channel RSSChannel `xml:"channel"      json:"channel"`
 
+	// TTLSkew is added to the interval Serve waits between updates, so a
+	// feed isn't refetched at the very moment the origin's cache expires
+	// and served back unchanged.
+	TTLSkew time.Duration `xml:"-" json:"-"`
+
+	// AdaptiveTTL makes Serve, when not given a ttl, poll about as often
+	// as the feed publishes (see RSSChannel.AverageInterval) instead of
+	// following the channel TTL.
+	AdaptiveTTL bool `xml:"-" json:"-"`
+
+	// MinTTL and MaxTTL, when not zero, bound the interval Serve waits
+	// between updates.
+	MinTTL time.Duration `xml:"-" json:"-"`
+	MaxTTL time.Duration `xml:"-" json:"-"`
+
+	// TrustLastBuildDate makes Update, and so Serve, take the feed at its
+	// word: a fresh copy whose channel lastBuildDate is that of the last
+	// one is deemed unchanged, without comparing its items, and the items
+	// rss holds are kept. Set it for well-behaved feeds which only bump
+	// their lastBuildDate when their content changes. Copies without a
+	// lastBuildDate are always compared.
+	TrustLastBuildDate bool `xml:"-" json:"-"`
+
+	// TTLUnit is the unit of RSSChannel.TTL, a minute when zero as RSS
+	// says. Set it to time.Second or time.Hour for a feed known to give
+	// its ttl in the wrong unit.
+	TTLUnit time.Duration `xml:"-" json:"-"`
+
+	// Client sends the requests of Update for a feed read from a URL. If
+	// nil, http.DefaultClient is used.
+	Client *http.Client `xml:"-" json:"-"`
+
+	// OnMetrics, if not nil, is called at the end of every Update,
+	// including those made by Serve, with figures about it.
+	OnMetrics func(FeedMetrics) `xml:"-" json:"-"`
+
+	// DedupKey, if not nil, returns the identity of an item, by which
+	// Update, Diff and ServeWithSeen tell whether it was seen before. By
+	// default items are known by GUID, falling back to link, then to
+	// title and description; ContentKey is a fuzzier alternative.
+	DedupKey func(RSSItem) string `xml:"-" json:"-"`
+
 	origin       []byte
 	source       string
+	sourceKind   SourceKind
+	status       int
+	etag         string
+	lastModified string
+	modTime      time.Time
 	lastUpdateAt time.Time
+	movedTo      string
+	maxAge       time.Duration // the freshness lifetime given by the server
+	hasMaxAge    bool
+	transforms   []func(*RSS) error
 
 	mu                 sync.Mutex
 	rssUpdateNotifiers []RSSUpdateNotifier
+	itemStates         map[string]ItemState
+	stop               chan struct{} // closed by Stop, nil unless served
+}
+
+// SourceKind tells where the content of an RSS was read from, and so how
+// Update reloads it.
+type SourceKind int
+
+const (
+	// SourceBytes is the kind of an RSS decoded by Feed. It can't be
+	// reloaded.
+	SourceBytes SourceKind = iota
+
+	// SourceFile is the kind of an RSS read by FeedFromFile.
+	SourceFile
+
+	// SourceURL is the kind of an RSS fetched by FeedFromURL or FetchFeed.
+	SourceURL
+)
+
+func (k SourceKind) String() string {
+	switch k {
+	case SourceBytes:
+		return "bytes"
+	case SourceFile:
+		return "file"
+	case SourceURL:
+		return "url"
+	}
+	return "SourceKind(" + strconv.Itoa(int(k)) + ")"
 }
 
-func (rss RSS) String() string {
+// SourceKind reports where rss was read from.
+func (rss *RSS) SourceKind() SourceKind { return rss.sourceKind }
+
+// String returns a one-line summary of rss. Use Dump for the full
+// content.
+func (rss *RSS) String() string {
+	a := []string{
+		"Version: \"" + rss.Version + "\"",
+		"Title: \"" + rss.Channel.Title + "\"",
+		"Link: \"" + rss.Channel.Link + "\"",
+		"Items: " + strconv.Itoa(len(rss.Channel.Items)),
+	}
+	if !rss.lastUpdateAt.IsZero() {
+		a = append(a, "LastUpdate: "+rss.lastUpdateAt.Format(time.RFC3339))
+	}
+	return strings.Join(a, ", ")
+}
+
+// Dump returns every element of rss, items included, as a string.
+func (rss *RSS) Dump() string {
 	return "Version: \"" + rss.Version + "\", Channel: {" + rss.Channel.String() + "}"
 }
 
-func (rss RSS) ToJSON() string {
-	data := struct {
-		Source  string     `json:"source"`
-		Version string     `json:"version"`
-		Channel RSSChannel `json:"channel"`
-	}{rss.source, rss.Version, rss.Channel}
-	b, err := json.MarshalIndent(data, "", "  ")
-	// b, err := json.Marshal(data)
+func (rss *RSS) ToJSON() string {
+	b, err := rss.ToJSONWithOptions(DefaultJSONOptions)
 	if err != nil {
-		logErr(err)
 		return err.Error()
 	}
 	return string(b)
@@ -141,6 +236,8 @@ type RSSChannel struct {
 	// Follows the same rules as the <item>-level
 	// [category](https://cyber.harvard.edu/rss/rss.html#ltcategorygtSubelementOfLtitemgt)
 	// element. More [info](https://cyber.harvard.edu/rss/rss.html#syndic8).
+	// Feeds are also read for categories wrapped in a <categories>
+	// element and for the <itunes:category> ones.
 	//
 	// Sample:
 	//   <category>Newspapers</category>
@@ -198,6 +295,22 @@ type RSSChannel struct {
 	// More info [here](https://cyber.harvard.edu/rss/skipHoursDays.html#skipdays).
 	SkipDays []time.Weekday `xml:"skipDays>day,omitempty" json:"skipDays,omitempty"`
 
+	// The <atom:link> elements of the channel, in document order. Feeds
+	// use them to point at themselves (rel="self"), at a WebSub hub
+	// (rel="hub") or at further pages (rel="next", rel="prev").
+	//
+	// Sample:
+	//   <atom:link href="https://www.solidot.org/index.rss" rel="self" type="application/rss+xml"/>
+	AtomLinks []Link `xml:"-" json:"atomLinks,omitempty"`
+
+	// The URL the feed says it moved to, given by a <newLocation> or
+	// <itunes:new-feed-url> element. See RSS.MovedTo.
+	NewLocation string `xml:"-" json:"newLocation,omitempty"`
+
+	// The iTunes podcast elements of the channel, if it has any. See
+	// RSS.Podcast.
+	ITunes *ITunesChannel `xml:"-" json:"itunes,omitempty"`
+
 	Items []RSSItem `xml:"item,omitempty" json:"item,omitempty"`
 }
 
@@ -222,10 +335,10 @@ func (c RSSChannel) String() string {
 	if c.WebMaster != "" {
 		a = append(a, "WebMaster: \""+c.WebMaster+"\"")
 	}
-	if !c.PubDate.IsZero() {
+	if hasDate(c.PubDate) {
 		a = append(a, "PubDate: "+c.PubDate.String())
 	}
-	if !c.LastBuildDate.IsZero() {
+	if hasDate(c.LastBuildDate) {
 		a = append(a, "LastBuildDate: "+c.LastBuildDate.String())
 	}
 	if c.Categories != nil {
@@ -245,7 +358,7 @@ func (c RSSChannel) String() string {
 		a = append(a, "Cloud: {"+c.Cloud.String()+"}")
 	}
 	if c.TTL != 0 {
-		a = append(a, "TTL: "+string(c.TTL))
+		a = append(a, "TTL: "+strconv.Itoa(c.TTL))
 	}
 	if c.Image != nil {
 		a = append(a, "Image: {"+c.Image.String()+"}")
@@ -259,17 +372,30 @@ func (c RSSChannel) String() string {
 	if c.SkipHours != nil {
 		var b []string
 		for _, v := range c.SkipHours {
-			b = append(b, string(v))
+			b = append(b, strconv.Itoa(v))
 		}
 		a = append(a, "SkipHours: ["+strings.Join(b, ", ")+"]")
 	}
 	if c.SkipDays != nil {
 		var b []string
 		for _, v := range c.SkipDays {
-			b = append(b, string(v))
+			b = append(b, v.String())
 		}
 		a = append(a, "SkipDays: ["+strings.Join(b, ", ")+"]")
 	}
+	if c.AtomLinks != nil {
+		var b []string
+		for _, l := range c.AtomLinks {
+			b = append(b, l.String())
+		}
+		a = append(a, "AtomLinks: [{"+strings.Join(b, "}, {")+"}]")
+	}
+	if c.NewLocation != "" {
+		a = append(a, "NewLocation: \""+c.NewLocation+"\"")
+	}
+	if c.ITunes != nil {
+		a = append(a, "ITunes: {"+c.ITunes.String()+"}")
+	}
 	if c.Items != nil {
 		var b []string
 		for i := range c.Items {
@@ -281,6 +407,62 @@ func (c RSSChannel) String() string {
 	return strings.Join(a, ", ")
 }
 
+// AtomLinkByRel returns the href of the first <atom:link> of c with the
+// given rel, or "" if there is none. A link without a rel attribute has
+// rel "alternate", as in Atom.
+func (c RSSChannel) AtomLinkByRel(rel string) string {
+	for _, l := range c.AtomLinks {
+		if l.rel() == rel {
+			return l.Href
+		}
+	}
+	return ""
+}
+
+// Link is an <atom:link> element, a typed reference from a channel or
+// item to a related resource. Its attributes follow the Atom
+// Syndication Format, RFC 4287.
+//
+// <atom:link href="https://www.solidot.org/index.rss" rel="self" type="application/rss+xml"/>
+type Link struct {
+
+	/*************************** Required elements ***************************/
+
+	Href string `xml:"href,attr" json:"href"`
+
+	/*************************** Optional elements ***************************/
+
+	Rel      string `xml:"rel,attr,omitempty"      json:"rel,omitempty"`
+	Type     string `xml:"type,attr,omitempty"     json:"type,omitempty"`
+	HrefLang string `xml:"hreflang,attr,omitempty" json:"hreflang,omitempty"`
+	Title    string `xml:"title,attr,omitempty"    json:"title,omitempty"`
+}
+
+func (l Link) String() string {
+	a := []string{"Href: \"" + l.Href + "\""}
+	if l.Rel != "" {
+		a = append(a, "Rel: \""+l.Rel+"\"")
+	}
+	if l.Type != "" {
+		a = append(a, "Type: \""+l.Type+"\"")
+	}
+	if l.HrefLang != "" {
+		a = append(a, "HrefLang: \""+l.HrefLang+"\"")
+	}
+	if l.Title != "" {
+		a = append(a, "Title: \""+l.Title+"\"")
+	}
+	return strings.Join(a, ", ")
+}
+
+// rel returns the relation of l, defaulting to "alternate".
+func (l Link) rel() string {
+	if l.Rel == "" {
+		return "alternate"
+	}
+	return l.Rel
+}
+
 // RSSCategory is an optional sub-element of RSSChannel/RSSItem.
 //
 // It has one optional attribute, domain, a string that identifies a
@@ -470,6 +652,13 @@ type RSSItem struct {
 	//   http://nytimes.com/2004/12/07FEST.html
 	Link string `xml:"link,omitempty" json:"link,omitempty"`
 
+	// Further links of the item, such as <atom:link> elements pointing at
+	// an AMP or PDF version of it, in document order.
+	//
+	// Sample:
+	//   <atom:link rel="alternate" type="application/pdf" href="http://nytimes.com/2004/12/07FEST.pdf"/>
+	AltLinks []Link `xml:"-" json:"altLinks,omitempty"`
+
 	// The item synopsis.
 	//
 	// Sample:
@@ -478,6 +667,10 @@ type RSSItem struct {
 	//   Palazzo del Cinema was being staged.
 	Description string `xml:"description,omitempty" json:"description,omitempty"`
 
+	// The full content of the item, as HTML, given by <content:encoded>
+	// when the description is only a synopsis.
+	ContentEncoded string `xml:"-" json:"contentEncoded,omitempty"`
+
 	// Email address of the author of the item.
 	// [More](https://cyber.harvard.edu/rss/rss.html#ltauthorgtSubelementOfLtitemgt).
 	//
@@ -500,12 +693,18 @@ type RSSItem struct {
 	// [More](https://cyber.harvard.edu/rss/rss.html#ltenclosuregtSubelementOfLtitemgt).
 	Enclosure *RSSEnclosure `xml:"enclosure,omitempty" json:"enclosure,omitempty"`
 
+	// Every media object attached to the item: its <enclosure> elements,
+	// of which RSS allows only one but feeds often give several, then its
+	// Media RSS <media:content> elements, grouped or not. Enclosure is
+	// the first of them.
+	Media []RSSEnclosure `xml:"-" json:"media,omitempty"`
+
 	// A string that uniquely identifies the item.
 	// [More](https://cyber.harvard.edu/rss/rss.html#ltguidgtSubelementOfLtitemgt).
 	//
 	// Sample:
 	//   http://inessential.com/2002/09/01.php#a2
-	GUID string `xml:"guid,omitempty" json:"guid,omitempty"`
+	GUID GUID `xml:"guid,omitempty" json:"guid"`
 
 	// Indicates when the item was published.
 	// [More](https://cyber.harvard.edu/rss/rss.html#ltpubdategtSubelementOfLtitemgt).
@@ -514,12 +713,23 @@ type RSSItem struct {
 	//   Sun, 19 May 2002 15:21:36 GMT
 	PubDate *RFC822 `xml:"pubDate,omitempty" json:"pubDate,omitempty"`
 
+	// When the item was last modified, as given by <atom:updated>. Feed
+	// also fills PubDate from <atom:published> when <pubDate> is missing,
+	// so PubDate stays the original publication date.
+	UpdatedDate *RFC822 `xml:"-" json:"updated,omitempty"`
+
 	// The RSS channel that the item came from.
 	// [More](https://cyber.harvard.edu/rss/rss.html#ltsourcegtSubelementOfLtitemgt).
 	//
 	// Sample:
 	//   <source url="http://www.tomalak.org/links2.xml">Tomalak's Realm</source>
 	Source *RSSSource `xml:"source,omitempty" json:"source,omitempty"`
+
+	// The iTunes podcast elements of the item, if it has any. See
+	// RSS.Podcast.
+	ITunes *ITunesItem `xml:"-" json:"itunes,omitempty"`
+
+	localDate bool // PubDate was read from a date without a zone, see InferredTimezone
 }
 
 func (it RSSItem) String() string {
@@ -532,9 +742,20 @@ func (it RSSItem) String() string {
 		desc := strings.Replace(it.Description, "\n", "\\n", -1)
 		a = append(a, "Description: \""+desc+"\"")
 	}
+	if it.ContentEncoded != "" {
+		content := strings.Replace(it.ContentEncoded, "\n", "\\n", -1)
+		a = append(a, "ContentEncoded: \""+content+"\"")
+	}
 	if it.Link != "" {
 		a = append(a, "Link: \""+it.Link+"\"")
 	}
+	if it.AltLinks != nil {
+		var b []string
+		for _, l := range it.AltLinks {
+			b = append(b, l.String())
+		}
+		a = append(a, "AltLinks: [{"+strings.Join(b, "}, {")+"}]")
+	}
 	if it.Author != "" {
 		a = append(a, "Author: \""+it.Author+"\"")
 	}
@@ -551,19 +772,58 @@ func (it RSSItem) String() string {
 	if it.Enclosure != nil {
 		a = append(a, "Enclosure: {"+it.Enclosure.String()+"}")
 	}
-	if it.GUID != "" {
-		a = append(a, "GUID: \""+it.GUID+"\"")
+	if it.Media != nil {
+		var b []string
+		for _, m := range it.Media {
+			b = append(b, m.String())
+		}
+		a = append(a, "Media: [{"+strings.Join(b, "}, {")+"}]")
 	}
-	if !it.PubDate.IsZero() {
+	if it.GUID.Value != "" {
+		a = append(a, "GUID: \""+it.GUID.Value+"\"")
+	}
+	if hasDate(it.PubDate) {
 		a = append(a, "PubDate: "+it.PubDate.String())
 	}
+	if hasDate(it.UpdatedDate) {
+		a = append(a, "UpdatedDate: "+it.UpdatedDate.String())
+	}
 	if it.Source != nil {
 		a = append(a, "Source: {"+it.Source.String()+"}")
 	}
+	if it.ITunes != nil {
+		a = append(a, "ITunes: {"+it.ITunes.String()+"}")
+	}
 
 	return strings.Join(a, ", ")
 }
 
+// LinkByType returns the href of the first alternate link of it with the
+// given media type, such as "application/pdf", or "" if there is none.
+func (it RSSItem) LinkByType(mime string) string {
+	for _, l := range it.AltLinks {
+		if strings.EqualFold(l.Type, mime) {
+			return l.Href
+		}
+	}
+	return ""
+}
+
+// MarshalJSON implements the json.Marshaler interface. It is the default
+// encoding, but for the GUID, left out when empty: omitempty doesn't
+// apply to structs.
+func (it RSSItem) MarshalJSON() ([]byte, error) {
+	type item RSSItem // without the MarshalJSON method
+	v := struct {
+		item
+		GUID *GUID `json:"guid,omitempty"`
+	}{item: item(it)}
+	if !it.GUID.IsZero() {
+		v.GUID = &it.GUID
+	}
+	return json.Marshal(v)
+}
+
 // RSSEnclosure is an optional sub-element of RSSItem.
 //
 // It has three required attributes. url says where the enclosure is
@@ -579,19 +839,37 @@ type RSSEnclosure struct {
 
 	/*************************** Required elements ***************************/
 
-	URL    string `xml:"url,attr"    json:"url"`
-	Length int    `xml:"length,attr" json:"length"`
-	Type   string `xml:"type,attr"   json:"type"`
+	URL string `xml:"url,attr" json:"url"`
+
+	// The size of the media in bytes. It's an int64 so that the sizes
+	// of long videos fit on 32-bit platforms.
+	Length int64 `xml:"length,attr" json:"length"`
+
+	Type string `xml:"type,attr" json:"type"`
 
 	/*************************** Optional elements ***************************/
 
-	// No optional element.
+	// The bitrate of the media in kilobits per second, as given by
+	// <media:content>. It isn't part of RSS and is only written on the
+	// <media:content> of ToXML.
+	Bitrate int `xml:"-" json:"bitrate,omitempty"`
 }
 
 func (ec RSSEnclosure) String() string {
 	// All attributes are required.
-	return fmt.Sprintf(
+	s := fmt.Sprintf(
 		"URL: \"%s\", Length: %d, Type: \"%s\"", ec.URL, ec.Length, ec.Type)
+	if ec.Bitrate != 0 {
+		s += ", Bitrate: " + strconv.Itoa(ec.Bitrate)
+	}
+	return s
+}
+
+// IsValid reports whether ec can be downloaded as the specification
+// requires: its url is an absolute http or https URL and its length isn't
+// negative.
+func (ec RSSEnclosure) IsValid() bool {
+	return httpURL(ec.URL) != nil && ec.Length >= 0
 }
 
 // RSSSource is an optional sub-element of RSSItem.
@@ -623,6 +901,96 @@ func (s RSSSource) String() string {
 	return fmt.Sprintf("\"%s\", URL: \"%s\"", s.Value, s.URL)
 }
 
+// GUID is an optional sub-element of RSSItem.
+//
+// Its value is a string that uniquely identifies the item. It has one
+// optional attribute, isPermaLink. If its value is true, the reader may
+// assume that the guid is a permalink to the item, that is, a url that
+// can be opened in a Web browser, that points to the full item described
+// by the <item> element. isPermaLink is optional, its default value is
+// true. If its value is false, the guid may not be assumed to be a url,
+// or a url to anything in particular.
+//
+// <guid isPermaLink="false">urn:uuid:1225c695-cfb8-4ebb-aaaa-80da344efa6a</guid>
+type GUID struct {
+
+	/*************************** Required elements ***************************/
+
+	Value string
+
+	/*************************** Optional elements ***************************/
+
+	IsPermaLink bool
+}
+
+func (g GUID) String() string { return g.Value }
+
+// IsZero reports whether g is empty, as an item without a GUID has it.
+func (g GUID) IsZero() bool { return g.Value == "" }
+
+// UnmarshalXML implements the xml.Unmarshaler interface. isPermaLink is
+// true unless it says "false".
+func (g *GUID) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	var v string
+	if err := d.DecodeElement(&v, &start); err != nil {
+		return err
+	}
+	g.Value, g.IsPermaLink = v, true
+	for _, a := range start.Attr {
+		if a.Name.Local == "isPermaLink" && strings.EqualFold(strings.TrimSpace(a.Value), "false") {
+			g.IsPermaLink = false
+		}
+	}
+	return nil
+}
+
+// MarshalXML implements the xml.Marshaler interface. An empty GUID is
+// not written, and isPermaLink only when false.
+func (g GUID) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	if g.Value == "" {
+		return nil
+	}
+	if !g.IsPermaLink {
+		start.Attr = append(start.Attr, xml.Attr{Name: xml.Name{Local: "isPermaLink"}, Value: "false"})
+	}
+	return e.EncodeElement(g.Value, start)
+}
+
+// MarshalJSON implements the json.Marshaler interface. A permalink is
+// written as a string, as GUIDs were before they had a type, another
+// GUID as an object:
+//
+//	"guid": "http://inessential.com/2002/09/01.php#a2"
+//	"guid": {"value": "urn:uuid:1225c695", "isPermaLink": false}
+func (g GUID) MarshalJSON() ([]byte, error) {
+	if g.IsPermaLink || g.Value == "" {
+		return json.Marshal(g.Value)
+	}
+	return json.Marshal(struct {
+		Value       string `json:"value"`
+		IsPermaLink bool   `json:"isPermaLink"`
+	}{g.Value, false})
+}
+
+// UnmarshalJSON implements the json.Unmarshaler interface, reading either
+// form written by MarshalJSON.
+func (g *GUID) UnmarshalJSON(b []byte) error {
+	var v string
+	if err := json.Unmarshal(b, &v); err == nil {
+		*g = GUID{Value: v, IsPermaLink: v != ""}
+		return nil
+	}
+	var o struct {
+		Value       string `json:"value"`
+		IsPermaLink bool   `json:"isPermaLink"`
+	}
+	if err := json.Unmarshal(b, &o); err != nil {
+		return err
+	}
+	*g = GUID{Value: o.Value, IsPermaLink: o.IsPermaLink}
+	return nil
+}
+
 type RFC822 time.Time
 
 var rfc822layout = [2]string{
@@ -630,20 +998,94 @@ var rfc822layout = [2]string{
 	"Mon, 02 Jan 2006 15:04:05 -0700",
 }
 
+// rfc822ParseLayouts are the layouts parseRFC822 tries once the weekday
+// is gone: RFC 822 allows, and feeds use, single digit days, two digit
+// years and times without seconds. rfc822LocalLayouts are those of dates
+// that carry no zone.
+var rfc822ParseLayouts, rfc822LocalLayouts []string
+
+func init() {
+	for _, date := range []string{"2 Jan 2006", "2 Jan 06"} {
+		for _, clock := range []string{"15:04:05", "15:04"} {
+			rfc822LocalLayouts = append(rfc822LocalLayouts, date+" "+clock)
+			for _, zone := range []string{"MST", "-0700"} {
+				rfc822ParseLayouts = append(rfc822ParseLayouts, date+" "+clock+" "+zone)
+			}
+		}
+	}
+}
+
 // UnmarshalXML implements the xml.Unmarshal interface.
 func (r *RFC822) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
-	var v, layout string
-	var t time.Time
-	var err error
+	var v string
 	d.DecodeElement(&v, &start)
-	for _, layout = range rfc822layout {
+	t, _, err := parseRFC822(v, nil)
+	if err != nil {
+		return err
+	}
+	*r = t
+	return nil
+}
+
+// parseRFC822 parses v with the RFC 822 layouts, reporting whether it
+// has a zone, an offset or a zone name. A date without a zone is taken to
+// be in loc, or UTC if loc is nil.
+//
+// The weekday is optional, with or without its comma, and runs of
+// whitespace count as one space. The weekday isn't checked against the
+// date, as feeds get it wrong.
+func parseRFC822(v string, loc *time.Location) (d RFC822, zoned bool, err error) {
+	v = normalizeRFC822(v)
+	var t time.Time
+	for _, layout := range rfc822ParseLayouts {
 		t, err = time.Parse(layout, v)
 		if err == nil {
-			*r = RFC822(t)
-			return nil
+			return RFC822(t), true, nil
 		}
 	}
-	return err
+	if loc == nil {
+		loc = time.UTC
+	}
+	for _, layout := range rfc822LocalLayouts {
+		if t, err := time.ParseInLocation(layout, v, loc); err == nil {
+			return RFC822(t), false, nil
+		}
+	}
+	return RFC822{}, false, err
+}
+
+// normalizeRFC822 returns the date v with its whitespace collapsed and its
+// weekday, if any, removed.
+func normalizeRFC822(v string) string {
+	f := strings.Fields(v)
+	if len(f) == 0 {
+		return ""
+	}
+	// "Mon," "Mon" or "Mon,02".
+	if i := strings.IndexByte(f[0], ','); i >= 0 && isLetters(f[0][:i]) {
+		if f[0] = f[0][i+1:]; f[0] == "" {
+			f = f[1:]
+		}
+	} else if isLetters(f[0]) {
+		f = f[1:]
+	}
+	return strings.Join(f, " ")
+}
+
+// isLetters reports whether s is a non-empty run of ASCII letters.
+func isLetters(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if c := s[i] | 0x20; c < 'a' || c > 'z' {
+			return false
+		}
+	}
+	return s != ""
+}
+
+// MarshalXML implements the xml.Marshaler interface. The date is written
+// with a numeric zone, e.g. "Mon, 02 Jan 2006 15:04:05 -0700".
+func (r RFC822) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	return e.EncodeElement(time.Time(r).Format(rfc822layout[1]), start)
 }
 
 // MarshalJSON implements the json.Marshal interface.
@@ -651,11 +1093,36 @@ func (r *RFC822) MarshalJSON() ([]byte, error) {
 	return json.Marshal(r.String())
 }
 
+// UnmarshalJSON implements the json.Unmarshaler interface, reading the
+// RFC 3339 form written by MarshalJSON.
+func (r *RFC822) UnmarshalJSON(b []byte) error {
+	var v string
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+	t, err := time.Parse(time.RFC3339, v)
+	if err != nil {
+		return err
+	}
+	*r = RFC822(t)
+	return nil
+}
+
 // IsZero reports whether r represents the zero time instant,
 // January 1, year 1, 00:00:00 UTC.
 func (r RFC822) IsZero() bool { return time.Time(r).IsZero() }
 
 func (r RFC822) String() string { return time.Time(r).Format(time.RFC3339) }
 
-// After reports whether the RFC822 instant r is after t.
-func (r RFC822) After(t *RFC822) bool { return time.Time(r).After(time.Time(*t)) }
+// After reports whether the RFC822 instant r is after t. A nil t is an
+// unset date, which every other date is after.
+func (r RFC822) After(t *RFC822) bool {
+	if t == nil {
+		return !r.IsZero()
+	}
+	return time.Time(r).After(time.Time(*t))
+}
+
+// Equal reports whether r and t are the same instant, even if they are in
+// different locations.
+func (r RFC822) Equal(t RFC822) bool { return time.Time(r).Equal(time.Time(t)) }
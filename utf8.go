@@ -0,0 +1,65 @@
+// Copyright 2018 cotox. All rights reserved.
+// Use of this source code is governed by a GPLv3
+// license that can be found in the LICENSE file.
+
+package rssutil
+
+import (
+	"reflect"
+	"strings"
+	"unicode/utf8"
+)
+
+// SanitizeUTF8 replaces the invalid UTF-8 sequences of the text of rss,
+// its version and every string of its channel and items, with the
+// Unicode replacement character, a run of invalid bytes making one. Such
+// sequences survive charset conversion when a feed truncates multibyte
+// characters, and break consumers of the output of ToJSON and ToXML.
+func (rss *RSS) SanitizeUTF8() {
+	walkStrings(reflect.ValueOf(rss).Elem(), func(v reflect.Value) {
+		if s := v.String(); !utf8.ValidString(s) {
+			v.SetString(strings.ToValidUTF8(s, string(utf8.RuneError)))
+		}
+	})
+}
+
+// HasInvalidUTF8 reports whether some text of rss, as SanitizeUTF8 sees
+// it, isn't valid UTF-8.
+func (rss *RSS) HasInvalidUTF8() bool {
+	invalid := false
+	walkStrings(reflect.ValueOf(rss).Elem(), func(v reflect.Value) {
+		invalid = invalid || !utf8.ValidString(v.String())
+	})
+	return invalid
+}
+
+// walkStrings calls f with every settable string reachable from v
+// through exported struct fields, pointers and slices. The configuration
+// fields of an RSS, none of which is text of the feed, are left out.
+func walkStrings(v reflect.Value, f func(reflect.Value)) {
+	switch v.Kind() {
+	case reflect.String:
+		if v.CanSet() {
+			f(v)
+		}
+	case reflect.Ptr:
+		if !v.IsNil() {
+			walkStrings(v.Elem(), f)
+		}
+	case reflect.Slice:
+		for i := 0; i < v.Len(); i++ {
+			walkStrings(v.Index(i), f)
+		}
+	case reflect.Struct:
+		if v.Type() == reflect.TypeOf(RSS{}) {
+			walkStrings(v.FieldByName("Version"), f)
+			walkStrings(v.FieldByName("Channel"), f)
+			return
+		}
+		for i := 0; i < v.NumField(); i++ {
+			if v.Type().Field(i).PkgPath == "" {
+				walkStrings(v.Field(i), f)
+			}
+		}
+	}
+}
@@ -0,0 +1,64 @@
+// Copyright 2018 cotox. All rights reserved.
+// Use of this source code is governed by a GPLv3
+// license that can be found in the LICENSE file.
+
+package rssutil
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// WatchInterval is how often Watch looks at the file when file system
+// notifications aren't available.
+var WatchInterval = time.Second
+
+// ErrNotFileSource is returned by Watch when rss wasn't read from a file.
+var ErrNotFileSource = errors.New("rss isn't read from a file")
+
+// Watch is like Serve for a feed read by FeedFromFile, but updates rss as
+// soon as the file changes instead of every TTL. It uses file system
+// notifications when the package is built with the fsnotify tag and they
+// work for the file, and otherwise checks the modification time of the
+// file every WatchInterval.
+//
+// Watch returns when Stop is called, or with the error of a failed
+// update.
+func (rss *RSS) Watch() error {
+	if rss.sourceKind != SourceFile {
+		return ErrNotFileSource
+	}
+
+	stop, err := rss.startServing()
+	if err != nil {
+		return err
+	}
+	defer rss.stopServing(stop)
+
+	changes, closeWatch, err := watchFile(rss.source)
+	if err != nil {
+		logDebugln("file notifications unavailable, polling:", err)
+	} else {
+		defer closeWatch()
+	}
+
+	// A nil changes never fires, leaving the ticker alone.
+	ticker := time.NewTicker(WatchInterval)
+	defer ticker.Stop()
+	if changes != nil {
+		ticker.Stop()
+	}
+
+	for {
+		select {
+		case <-stop:
+			return nil
+		case <-changes:
+		case <-ticker.C:
+		}
+		if err := rss.updateAndNotify(context.Background()); err != nil {
+			return err
+		}
+	}
+}
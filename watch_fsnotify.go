@@ -0,0 +1,56 @@
+// Copyright 2018 cotox. All rights reserved.
+// Use of this source code is governed by a GPLv3
+// license that can be found in the LICENSE file.
+
+//go:build fsnotify
+// +build fsnotify
+
+package rssutil
+
+import (
+	"path/filepath"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// watchFile returns a channel receiving a value whenever filename may
+// have changed, and a function to stop watching. It watches the directory
+// of the file, so a file replaced by renaming another over it is still
+// seen.
+func watchFile(filename string) (<-chan struct{}, func(), error) {
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, nil, err
+	}
+	filename = filepath.Clean(filename)
+	if err := w.Add(filepath.Dir(filename)); err != nil {
+		w.Close()
+		return nil, nil, err
+	}
+
+	changes := make(chan struct{}, 1)
+	go func() {
+		for {
+			select {
+			case ev, ok := <-w.Events:
+				if !ok {
+					return
+				}
+				if filepath.Clean(ev.Name) != filename || ev.Op&(fsnotify.Write|fsnotify.Create|fsnotify.Rename) == 0 {
+					continue
+				}
+				select {
+				case changes <- struct{}{}:
+				default:
+				}
+			case err, ok := <-w.Errors:
+				if !ok {
+					return
+				}
+				logErr(err)
+			}
+		}
+	}()
+
+	return changes, func() { w.Close() }, nil
+}
@@ -0,0 +1,16 @@
+// Copyright 2018 cotox. All rights reserved.
+// Use of this source code is governed by a GPLv3
+// license that can be found in the LICENSE file.
+
+//go:build !fsnotify
+// +build !fsnotify
+
+package rssutil
+
+import "errors"
+
+// watchFile reports that file system notifications aren't built in; see
+// watch_fsnotify.go.
+func watchFile(filename string) (<-chan struct{}, func(), error) {
+	return nil, nil, errors.New("built without the fsnotify tag")
+}